@@ -0,0 +1,258 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/validation"
+)
+
+var (
+	contextType     = reflect.TypeOf((*Context)(nil))
+	echoContextType = reflect.TypeOf((*echo.Context)(nil)).Elem()
+	errorType       = reflect.TypeOf((*error)(nil)).Elem()
+
+	signatures      = make(map[signatureKey]*actionSignature)
+	signaturesMutex = &sync.RWMutex{}
+)
+
+// 行为方法的参数来源
+const (
+	argContext = iota //*Context或echo.Context
+	argScalar         //基本类型或其指针：依次从路由参数、表单和查询字符串中按名称获取，非指针类型的参数不能缺少
+	argStruct         //结构体或结构体指针：通过Context.MapForm填充并使用validation验证
+)
+
+//方法签名缓存的键。使用reflect.Type而不是类型名称，避免不同包中的同名类型冲突
+type signatureKey struct {
+	typ   reflect.Type
+	names string
+}
+
+type actionArg struct {
+	kind  int
+	name  string
+	typ   reflect.Type
+	isPtr bool
+}
+
+// actionSignature 记录动态实例中行为方法的参数和返回值。
+// 例如：func (a *User) Show_GET(id int64, form *UserForm) (*User, error)
+type actionSignature struct {
+	args    []*actionArg
+	dataOut int //作为Output.Data的返回值的位置，-1表示没有
+	errOut  int //error类型返回值的位置，-1表示没有
+}
+
+// 解析方法签名。names为基本类型参数依次对应的参数名称
+func parseActionSignature(t reflect.Type, names []string) (*actionSignature, error) {
+	key := signatureKey{typ: t, names: strings.Join(names, `,`)}
+	signaturesMutex.RLock()
+	sig, ok := signatures[key]
+	signaturesMutex.RUnlock()
+	if ok {
+		return sig, nil
+	}
+	sig = &actionSignature{
+		args:    make([]*actionArg, 0, t.NumIn()),
+		dataOut: -1,
+		errOut:  -1,
+	}
+	var scalars int
+	for i := 0; i < t.NumIn(); i++ {
+		in := t.In(i)
+		arg := &actionArg{typ: in}
+		switch {
+		case in == contextType || in == echoContextType:
+			arg.kind = argContext
+		case in.Kind() == reflect.Struct:
+			arg.kind = argStruct
+		case in.Kind() == reflect.Ptr && in.Elem().Kind() == reflect.Struct:
+			arg.kind = argStruct
+			arg.isPtr = true
+		case isScalarKind(in.Kind()) || in.Kind() == reflect.Ptr && isScalarKind(in.Elem().Kind()):
+			if scalars >= len(names) {
+				return nil, fmt.Errorf(`The parameter %d(%v) of %v has no name to bind, please declare it in the route rule or the "args" tag`, i, in, t)
+			}
+			arg.kind = argScalar
+			arg.isPtr = in.Kind() == reflect.Ptr
+			arg.name = names[scalars]
+			scalars++
+		default:
+			return nil, fmt.Errorf(`Unsupported parameter type %v of %v`, in, t)
+		}
+		sig.args = append(sig.args, arg)
+	}
+	for i := 0; i < t.NumOut(); i++ {
+		out := t.Out(i)
+		if out.Implements(errorType) && sig.errOut < 0 {
+			sig.errOut = i
+			continue
+		}
+		if sig.dataOut < 0 {
+			sig.dataOut = i
+		}
+	}
+	signaturesMutex.Lock()
+	signatures[key] = sig
+	signaturesMutex.Unlock()
+	return sig, nil
+}
+
+func isScalarKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// 根据当前请求生成调用参数。返回的valid不为nil时表示结构体参数验证未通过
+func (s *actionSignature) bind(c *Context) (args []reflect.Value, valid *validation.Validation, err error) {
+	args = make([]reflect.Value, len(s.args))
+	for i, arg := range s.args {
+		switch arg.kind {
+		case argContext:
+			args[i] = reflect.ValueOf(c)
+		case argScalar:
+			v := c.Param(arg.name)
+			if v == `` {
+				v = c.Form(arg.name)
+			}
+			if v == `` {
+				v = c.Query(arg.name)
+			}
+			args[i], err = arg.scalar(v)
+			if err != nil {
+				return
+			}
+		case argStruct:
+			t := arg.typ
+			if arg.isPtr {
+				t = t.Elem()
+			}
+			ptr := reflect.New(t)
+			if err = c.MapForm(ptr.Interface()); err != nil {
+				return
+			}
+			v := &validation.Validation{}
+			if ok, _ := v.Valid(ptr.Interface()); !ok {
+				valid = v
+				return
+			}
+			if arg.isPtr {
+				args[i] = ptr
+			} else {
+				args[i] = ptr.Elem()
+			}
+		}
+	}
+	return
+}
+
+// 将请求中的值转换为基本类型参数。值为空时，指针类型的参数为nil，非指针类型的参数返回错误
+func (arg *actionArg) scalar(s string) (v reflect.Value, err error) {
+	if s == `` {
+		if arg.isPtr {
+			return reflect.Zero(arg.typ), nil
+		}
+		return v, fmt.Errorf(`Missing parameter "%v"`, arg.name)
+	}
+	t := arg.typ
+	if arg.isPtr {
+		t = t.Elem()
+	}
+	v, err = scalarValue(t, s)
+	if err != nil {
+		return v, fmt.Errorf(`Invalid value of parameter "%v": %v`, arg.name, err)
+	}
+	if arg.isPtr {
+		ptr := reflect.New(t)
+		ptr.Elem().Set(v)
+		v = ptr
+	}
+	return
+}
+
+func scalarValue(t reflect.Type, s string) (v reflect.Value, err error) {
+	v = reflect.New(t).Elem()
+	if s == `` {
+		return
+	}
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		var b bool
+		b, err = strconv.ParseBool(s)
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var n int64
+		n, err = strconv.ParseInt(s, 10, t.Bits())
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var n uint64
+		n, err = strconv.ParseUint(s, 10, t.Bits())
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		var n float64
+		n, err = strconv.ParseFloat(s, t.Bits())
+		v.SetFloat(n)
+	}
+	return
+}
+
+// 调用行为方法。返回值中的数据会被赋值给Output.Data，此时hasData为true，
+// 由调用者在执行完After后通过Context.Display输出
+func (a *Wrapper) callAction(c *Context, m reflect.Value, sig *actionSignature) (hasData bool, err error) {
+	args, valid, err := sig.bind(c)
+	if err != nil {
+		return false, c.RenderError(NewError(http.StatusBadRequest, err.Error(), `invalid_param`))
+	}
+	if valid != nil {
+		e := NewError(http.StatusBadRequest, valid.Errors[0].Message, `invalid_param`)
+		for _, v := range valid.Errors {
+			e.AddField(v.Field, v.Message)
+		}
+		return false, c.RenderError(e)
+	}
+	r, err := a.SafelyCall(m, args)
+	if err != nil {
+		return
+	}
+	if sig.errOut >= 0 {
+		if e, ok := r[sig.errOut].Interface().(error); ok && e != nil {
+			return false, c.RenderError(e)
+		}
+	}
+	if sig.dataOut >= 0 {
+		c.Output.Data = r[sig.dataOut].Interface()
+		hasData = true
+	}
+	return
+}
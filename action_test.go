@@ -0,0 +1,112 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"reflect"
+	"testing"
+)
+
+type actionTestForm struct {
+	Name string
+}
+
+func TestParseActionSignature(t *testing.T) {
+	var fn func(*Context, int64, *string, *actionTestForm) (map[string]interface{}, error)
+	sig, err := parseActionSignature(reflect.TypeOf(fn), []string{`id`, `tab`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := []int{argContext, argScalar, argScalar, argStruct}
+	for i, arg := range sig.args {
+		if arg.kind != kinds[i] {
+			t.Errorf(`args[%d].kind = %d, want %d`, i, arg.kind, kinds[i])
+		}
+	}
+	if sig.args[1].name != `id` || sig.args[2].name != `tab` || !sig.args[2].isPtr {
+		t.Errorf(`scalar args = %+v, %+v`, sig.args[1], sig.args[2])
+	}
+	if sig.dataOut != 0 || sig.errOut != 1 {
+		t.Errorf(`dataOut = %d, errOut = %d`, sig.dataOut, sig.errOut)
+	}
+	if _, err = parseActionSignature(reflect.TypeOf(fn), []string{`id`}); err == nil {
+		t.Error(`a scalar parameter without name should fail`)
+	}
+	var bad func(chan int)
+	if _, err = parseActionSignature(reflect.TypeOf(bad), nil); err == nil {
+		t.Error(`an unsupported parameter type should fail`)
+	}
+}
+
+func TestActionArgScalar(t *testing.T) {
+	arg := &actionArg{kind: argScalar, name: `id`, typ: reflect.TypeOf(int64(0))}
+	v, err := arg.scalar(`42`)
+	if err != nil || v.Int() != 42 {
+		t.Errorf(`scalar(42) = %v, %v`, v, err)
+	}
+	if _, err = arg.scalar(``); err == nil {
+		t.Error(`a missing non-pointer parameter should fail`)
+	}
+	if _, err = arg.scalar(`abc`); err == nil {
+		t.Error(`an invalid int should fail`)
+	}
+
+	var s *string
+	arg = &actionArg{kind: argScalar, name: `tab`, typ: reflect.TypeOf(s), isPtr: true}
+	v, err = arg.scalar(``)
+	if err != nil || !v.IsNil() {
+		t.Errorf(`scalar("") = %v, %v; want nil pointer`, v, err)
+	}
+	v, err = arg.scalar(`info`)
+	if err != nil || *(v.Interface().(*string)) != `info` {
+		t.Errorf(`scalar(info) = %v, %v`, v, err)
+	}
+
+	arg = &actionArg{kind: argScalar, name: `ok`, typ: reflect.TypeOf(true)}
+	if v, err = arg.scalar(`true`); err != nil || !v.Bool() {
+		t.Errorf(`scalar(true) = %v, %v`, v, err)
+	}
+}
+
+func TestParseActionSignatureSameName(t *testing.T) {
+	//两个同名的局部类型的String()相同，签名缓存不能混淆
+	t1 := func() reflect.Type {
+		type form struct{ A string }
+		var fn func(form)
+		return reflect.TypeOf(fn)
+	}()
+	t2 := func() reflect.Type {
+		type form struct{ B int }
+		var fn func(form)
+		return reflect.TypeOf(fn)
+	}()
+	s1, err := parseActionSignature(t1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := parseActionSignature(t2, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if t1.String() != t2.String() {
+		t.Skipf(`%v != %v`, t1, t2)
+	}
+	if s1 == s2 || s1.args[0].typ != t1.In(0) || s2.args[0].typ != t2.In(0) {
+		t.Errorf(`signatures of different types are shared: %v, %v`, s1.args[0].typ, s2.args[0].typ)
+	}
+}
@@ -20,8 +20,10 @@ package webx
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/com"
 )
 
 type Webxer interface {
@@ -61,6 +63,7 @@ func NewApp(name string, domain string, s *Server, middlewares ...echo.Middlewar
 		a.Group.Use(middlewares...)
 	} else {
 		e := echo.NewWithContext(s.InitContext)
+		e.SetHTTPErrorHandler(s.HTTPErrorHandler)
 		e.Use(s.DefaultMiddlewares...)
 		e.Use(middlewares...)
 		a.Handler = e
@@ -70,6 +73,39 @@ func NewApp(name string, domain string, s *Server, middlewares ...echo.Middlewar
 	return
 }
 
+// AppRoute 是App.Route的返回值，用于为刚注册的路由命名，同时可以继续调用App的方法
+type AppRoute struct {
+	*App
+	url *Url
+}
+
+// Name 为路由命名，以便通过Server.URL.For生成网址
+func (r *AppRoute) Name(name string) *AppRoute {
+	r.App.Server.URL.SetName(name, r.url)
+	return r
+}
+
+// Perm 设置路由需要的权限，由Server.PermChecker检查
+func (r *AppRoute) Perm(perm string) *AppRoute {
+	r.url.Perm = perm
+	return r
+}
+
+// RateLimit 设置路由的访问频率限制，由Server.RateLimiter检查。规则无效时panic
+func (r *AppRoute) RateLimit(rule string) *AppRoute {
+	if err := r.Server.parseRateLimit(rule); err != nil {
+		panic(err)
+	}
+	r.url.RateLimit = rule
+	return r
+}
+
+// CORS 设置路由使用的跨域资源共享策略
+func (r *AppRoute) CORS(policy string) *AppRoute {
+	r.url.CORS = policy
+	return r
+}
+
 type App struct {
 	*Server
 	*echo.Group            //没有指定域名时有效
@@ -80,6 +116,10 @@ type App struct {
 	controllers map[string]*Wrapper
 	Url         string
 	Dir         string
+	routes      *routeNode      //路由索引，参见MatchRoute
+	routeCount  int             //生成路由索引时引擎中的路由数量
+	urls        map[string]*Url //路由规则对应的Url，参见RouteUrl
+	routeMutex  sync.RWMutex
 }
 
 func (a *App) G() *echo.Group {
@@ -92,18 +132,30 @@ func (a *App) E() *echo.Echo {
 
 // 注册路由：app.R(`/index`,Index.Index,"GET","POST")
 func (a *App) R(path string, h HandlerFunc, methods ...string) *App {
+	a.Route(path, h, methods...)
+	return a
+}
+
+// Route 与R相同，但返回AppRoute以便继续设置该路由：app.Route(`/user/:id`,User.Show).Name(`user.show`)
+func (a *App) Route(path string, h HandlerFunc, methods ...string) *AppRoute {
 	if len(methods) < 1 {
 		methods = append(methods, "GET")
 	}
-	_, ctl, act := a.Server.URL.Set(path, h)
+	key := a.Server.URL.FuncPath(h)
+	u := a.Server.URL.SetByKey(path, key)
+	a.setUrl(u)
+	_, ctl, act := com.ParseFuncName(key)
 	a.Webx().Match(methods, path, echo.HandlerFunc(func(ctx echo.Context) error {
 		c := X(ctx)
 		if err := c.Init(a, nil, ctl, act); err != nil {
 			return err
 		}
+		if err := c.checkRoute(u); err != nil || c.Exit {
+			return err
+		}
 		return h(c)
 	}))
-	return a
+	return &AppRoute{App: a, url: u}
 }
 
 func (a *App) Webx() Webxer {
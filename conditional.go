@@ -0,0 +1,117 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ETag 根据内容生成ETag。weak为true时生成弱ETag(W/"...")，表示内容语义相同即可，
+// 如压缩前后的同一页面
+func ETag(content []byte, weak bool) string {
+	sum := sha1.Sum(content)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:15]) + `"`
+	if weak {
+		etag = `W/` + etag
+	}
+	return etag
+}
+
+// MatchETag 判断If-None-Match或If-Match请求头header中是否包含etag。
+// weak为true时使用弱比较(If-None-Match)，忽略W/前缀；否则使用强比较(If-Match)，弱ETag不匹配任何值
+func MatchETag(header string, etag string, weak bool) bool {
+	if etag == `` {
+		return false
+	}
+	if strings.TrimSpace(header) == `*` {
+		return true
+	}
+	if weak {
+		etag = strings.TrimPrefix(etag, `W/`)
+	} else if strings.HasPrefix(etag, `W/`) {
+		return false
+	}
+	for _, v := range strings.Split(header, `,`) {
+		v = strings.TrimSpace(v)
+		if weak {
+			v = strings.TrimPrefix(v, `W/`)
+		}
+		if v == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Conditional 按RFC 7232判断条件请求，返回应当直接输出的状态码：
+// http.StatusNotModified(GET或HEAD请求的内容未改变)、http.StatusPreconditionFailed(前提条件不成立)，
+// 或0(继续正常处理)。etag为空时不比较ETag，modTime为零值时不比较修改时间
+func Conditional(method string, header func(string) string, etag string, modTime time.Time) int {
+	safe := method == `GET` || method == `HEAD`
+	if im := header(`If-Match`); im != `` {
+		if !MatchETag(im, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if ius := header(`If-Unmodified-Since`); ius != `` && !modTime.IsZero() {
+		if t, err := http.ParseTime(ius); err == nil && modTime.Truncate(time.Second).After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+	if inm := header(`If-None-Match`); inm != `` {
+		if !MatchETag(inm, etag, true) {
+			return 0
+		}
+		if safe {
+			return http.StatusNotModified
+		}
+		return http.StatusPreconditionFailed
+	}
+	if ims := header(`If-Modified-Since`); ims != `` && safe && !modTime.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil && !modTime.Truncate(time.Second).After(t) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// CheckConditional 设置ETag和Last-Modified响应头并判断条件请求。
+// 返回true表示已输出304或412，不需要再输出内容
+func (c *Context) CheckConditional(etag string, modTime time.Time) bool {
+	header := c.Response().Header()
+	if etag != `` {
+		header.Set(`ETag`, etag)
+	}
+	if !modTime.IsZero() {
+		header.Set(`Last-Modified`, modTime.UTC().Format(http.TimeFormat))
+	}
+	code := Conditional(c.Request().Method(), c.Request().Header().Get, etag, modTime)
+	if code == 0 {
+		return false
+	}
+	if code == http.StatusNotModified {
+		header.Del(`Content-Type`)
+		header.Del(`Content-Length`)
+	}
+	c.Response().WriteHeader(code)
+	c.Exit = true
+	return true
+}
@@ -0,0 +1,79 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		weak   bool
+		want   bool
+	}{
+		{`"a"`, `"a"`, false, true},
+		{`"b", "a"`, `"a"`, false, true},
+		{`W/"a"`, `"a"`, false, false},
+		{`"a"`, `W/"a"`, false, false},
+		{`W/"a"`, `"a"`, true, true},
+		{`"a"`, `W/"a"`, true, true},
+		{`*`, `"a"`, false, true},
+		{`"b"`, `"a"`, true, false},
+		{`"a"`, ``, true, false},
+	}
+	for _, test := range tests {
+		if got := MatchETag(test.header, test.etag, test.weak); got != test.want {
+			t.Errorf("MatchETag(%q, %q, %v) = %v; want %v", test.header, test.etag, test.weak, got, test.want)
+		}
+	}
+	if ETag([]byte(`a`), false) == ETag([]byte(`b`), false) {
+		t.Error(`different content must have different etags`)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	mod := time.Date(2016, 5, 1, 8, 0, 0, 500, time.UTC)
+	before := mod.Add(-time.Hour).Format(http.TimeFormat)
+	at := mod.Format(http.TimeFormat)
+	tests := []struct {
+		method string
+		header map[string]string
+		want   int
+	}{
+		{`GET`, map[string]string{}, 0},
+		{`GET`, map[string]string{`If-None-Match`: `W/"x"`}, http.StatusNotModified},
+		{`GET`, map[string]string{`If-None-Match`: `"y"`, `If-Modified-Since`: at}, 0},
+		{`HEAD`, map[string]string{`If-Modified-Since`: at}, http.StatusNotModified},
+		{`GET`, map[string]string{`If-Modified-Since`: before}, 0},
+		{`POST`, map[string]string{`If-None-Match`: `*`}, http.StatusPreconditionFailed},
+		{`PUT`, map[string]string{`If-Match`: `"y"`}, http.StatusPreconditionFailed},
+		{`PUT`, map[string]string{`If-Match`: `"x"`}, 0},
+		{`PUT`, map[string]string{`If-Unmodified-Since`: before}, http.StatusPreconditionFailed},
+		{`PUT`, map[string]string{`If-Unmodified-Since`: at}, 0},
+	}
+	for i, test := range tests {
+		header := func(name string) string { return test.header[name] }
+		if got := Conditional(test.method, header, `"x"`, mod); got != test.want {
+			t.Errorf("#%d: Conditional(%s, %v) = %d; want %d", i, test.method, test.header, got, test.want)
+		}
+	}
+}
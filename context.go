@@ -18,7 +18,6 @@
 package webx
 
 import (
-	"encoding/json"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -71,6 +70,8 @@ type Context struct {
 	Format         string
 	Exit           bool
 	body           []byte
+	forwarded      *Forwarded
+	route          *Url
 }
 
 func (c *Context) Reset(req engine.Request, resp engine.Response) {
@@ -85,6 +86,8 @@ func (c *Context) Reset(req engine.Request, resp engine.Response) {
 	c.Format = c.ResolveFormat()
 	c.middleware = nil
 	c.body = nil
+	c.forwarded = nil
+	c.route = nil
 }
 
 func (c *Context) Init(app *App, ctl interface{}, ctlName string, actName string) error {
@@ -132,18 +135,26 @@ func (c *Context) Use(i ...func(IniterFunc) IniterFunc) {
 	}
 }
 
+// InitSession 设置当前请求的session。sess为nil时使用Server.SessionStore创建，
+// 创建存储失败时记录错误并使用进程内的存储
 func (c *Context) InitSession(sess ssi.Session) {
 	if sess == nil {
-		sess = ss.NewSession(
-			&ssi.Options{
-				Engine:   c.Server.SessionStoreEngine,
-				Path:     `/`,
-				Domain:   c.Server.CookieDomain,
-				MaxAge:   int(c.Server.CookieExpires),
-				Secure:   c.IsSecure(),
-				HttpOnly: c.Server.CookieHttpOnly,
-			},
-			c.Server.SessionStoreConfig, c)
+		store, err := c.Server.SessionStore()
+		if err != nil {
+			c.Server.Core.Logger().Error(err)
+			store = c.Server.fallbackSessionStore()
+		}
+		sess = ss.NewMySession(store, ssi.DefaultName, c)
+		if c.IsSecure() {
+			sess.Options(ssi.Options{
+				Path:        `/`,
+				Domain:      c.Server.CookieDomain,
+				MaxAge:      int(c.Server.CookieExpires),
+				Secure:      true,
+				HttpOnly:    c.Server.CookieHttpOnly,
+				IdleTimeout: c.Server.SessionIdleTimeout,
+			})
+		}
 	}
 	c.session = sess
 }
@@ -264,20 +275,35 @@ func (c *Context) Body() ([]byte, error) {
 	return body, nil
 }
 
+// IP 返回客户端IP。只有直接连接来自Server.TrustedProxies时才使用转发请求头
 func (c *Context) IP() string {
-	proxy := c.Proxy()
-	if len(proxy) > 0 && proxy[0] != "" {
-		return proxy[0]
-	}
-	ip := strings.Split(c.Request().RemoteAddress(), ":")
-	if len(ip) > 0 {
-		if ip[0] != "[" {
-			return ip[0]
-		}
+	if ip := c.Forwarded().IP; ip != "" {
+		return ip
 	}
 	return "127.0.0.1"
 }
 
+// Route 返回当前请求匹配的路由，在控制器的Init之后有效
+func (c *Context) Route() *Url {
+	return c.route
+}
+
+// Forwarded 返回根据Server.TrustedProxies解析的客户端请求原始信息
+func (c *Context) Forwarded() *Forwarded {
+	if c.forwarded == nil {
+		req := c.Request()
+		scheme := req.URL().Scheme()
+		if scheme == "" {
+			scheme = "http"
+			if req.IsTLS() {
+				scheme = "https"
+			}
+		}
+		c.forwarded = c.Server.TrustedProxies.Resolve(req.RemoteAddress(), req.Host(), scheme, req.Header().Get)
+	}
+	return c.forwarded
+}
+
 func (c *Context) Header(name string) string {
 	return c.Request().Header().Get(name)
 }
@@ -367,34 +393,14 @@ func (c *Context) ResolveContentType() string {
 	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
 }
 
-// ResolveFormat maps the request's Accept MIME type declaration to
-// a Request.Format attribute, specifically "html", "xml", "json", or "txt",
-// returning a default of "html" when Accept header cannot be mapped to a
-// value above.
+// ResolveFormat 确定输出格式：优先使用查询参数format指定的格式，
+// 否则根据请求头Accept在Server.Formats已注册的格式中选择，
+// 无法确定时返回Server.Formats.Default(默认为html)
 func (c *Context) ResolveFormat() string {
-	format := c.Query("format")
-	if format != `` {
+	if format := c.Query("format"); format != `` && c.Server.Formats.Has(format) {
 		return format
 	}
-	accept := c.Header("Accept")
-	switch {
-	case accept == "",
-		strings.HasPrefix(accept, "*/*"), // */
-		strings.Contains(accept, "application/xhtml"),
-		strings.Contains(accept, "text/html"):
-		return "html"
-	case strings.Contains(accept, "application/json"),
-		strings.Contains(accept, "text/javascript"),
-		strings.Contains(accept, "application/javascript"):
-		return "json"
-	case strings.Contains(accept, "application/xml"),
-		strings.Contains(accept, "text/xml"):
-		return "xml"
-	case strings.Contains(accept, "text/plain"):
-		return "text"
-	}
-
-	return "html"
+	return c.Server.Formats.Negotiate(c.Header("Accept"))
 }
 
 // Protocol returns request protocol name, such as HTTP/1.1 .
@@ -402,20 +408,15 @@ func (c *Context) Protocol() string {
 	return c.Request().Proto()
 }
 
-// Site returns base site url as scheme://domain type.
+// Site returns base site url as scheme://domain[:port] type.
+// The port is omitted when it is the default port of the scheme.
 func (c *Context) Site() string {
-	return c.Scheme() + "://" + c.Domain()
+	return c.Forwarded().Origin()
 }
 
 // Scheme returns request scheme as "http" or "https".
 func (c *Context) Scheme() string {
-	if c.Request().URL().Scheme() != "" {
-		return c.Request().URL().Scheme()
-	}
-	if c.Request().IsTLS() == false {
-		return "http"
-	}
-	return "https"
+	return c.Forwarded().Scheme
 }
 
 // Domain returns host name.
@@ -427,12 +428,8 @@ func (c *Context) Domain() string {
 // Host returns host name.
 // if no host info in request, return localhost.
 func (c *Context) Host() string {
-	if c.Request().Host() != "" {
-		hostParts := strings.Split(c.Request().Host(), ":")
-		if len(hostParts) > 0 {
-			return hostParts[0]
-		}
-		return c.Request().Host()
+	if host := c.Forwarded().Host; host != "" {
+		return host
 	}
 	return "localhost"
 }
@@ -465,15 +462,10 @@ func (c *Context) SubDomains() string {
 	return ""
 }
 
-// Port returns request client port.
-// when error or empty, return 80.
+// Port returns request port.
+// when empty, return the default port of the scheme.
 func (c *Context) Port() int {
-	parts := strings.Split(c.Request().Host(), ":")
-	if len(parts) == 2 {
-		port, _ := strconv.Atoi(parts[1])
-		return port
-	}
-	return 80
+	return c.Forwarded().Port
 }
 
 func (c *Context) Assign(key string, val interface{}) *Context {
@@ -531,30 +523,7 @@ func (c *Context) Display(args ...interface{}) error {
 		return nil
 	}
 
-	switch c.Format {
-	case `xml`:
-		return c.Object().XML(c.Code, c.Output)
-	case `json`:
-		b, err := json.Marshal(c.Output)
-		if err != nil {
-			return err
-		}
-		if callback := c.Query(`callback`); callback != `` {
-			b = []byte(callback + `(` + string(b) + `);`)
-		}
-		return c.Object().JSONBlob(c.Code, b)
-	default:
-		if c.Tmpl == `` {
-			return nil
-		}
-		c.Context.SetFunc(`Status`, func() int {
-			return c.Output.Status
-		})
-		c.Context.SetFunc(`Message`, func() interface{} {
-			return c.Output.Message
-		})
-		return c.Render(c.Code, c.Tmpl, c.Output.Data)
-	}
+	return c.render()
 }
 
 func (c *Context) DisplayError(msg string, args ...int) error {
@@ -571,31 +540,28 @@ func (c *Context) DisplayError(msg string, args ...int) error {
 		c.Output.Message = msg
 	}
 
-	switch c.Format {
-	case `xml`:
-		return c.Object().XML(c.Code, c.Output)
-	case `json`:
-		b, err := json.Marshal(c.Output)
-		if err != nil {
-			return err
-		}
-		if callback := c.Query(`callback`); callback != `` {
-			b = []byte(callback + `(` + string(b) + `);`)
-		}
-		return c.Object().JSONBlob(c.Code, b)
-	default:
-		if c.Tmpl == `` {
-			msg, _ = c.Output.Message.(string)
-			return c.String(c.Code, msg)
-		}
-		c.Context.SetFunc(`Status`, func() int {
-			return c.Output.Status
-		})
-		c.Context.SetFunc(`Message`, func() interface{} {
-			return c.Output.Message
-		})
-		return c.Render(c.Code, c.Tmpl, c.Output.Data)
+	return c.render()
+}
+
+// 使用Server.Formats中与c.Format对应的方式输出
+func (c *Context) render() error {
+	render := c.Server.Formats.Get(c.Format)
+	if render == nil {
+		render = c.Server.Formats.Get(c.Server.Formats.Default)
+	}
+	if c.Query(`format`) == `` {
+		AddVary(c.Response().Header(), `Accept`)
 	}
+	return render(c)
+}
+
+// Blob 输出指定内容类型的数据
+func (c *Context) Blob(code int, contentType string, b []byte) error {
+	c.Response().Header().Set(`Content-Type`, contentType)
+	c.Response().Header().Set(`Content-Length`, strconv.Itoa(len(b)))
+	c.Response().WriteHeader(code)
+	_, err := c.Response().Write(b)
+	return err
 }
 
 // ParseStruct mapping forms' name and values to struct's field
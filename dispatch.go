@@ -0,0 +1,191 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/webx-top/echo"
+)
+
+// dispatchEntry 是调度表中的一个行为方法
+type dispatchEntry struct {
+	index int //在动态实例方法集中的索引
+	sig   *actionSignature
+}
+
+// dispatcher 是动态实例中某个行为的调度表，在注册路由时生成。
+// 请求时按以下顺序选择方法：fn_METHODFORMAT、fn_METHOD、fn_FORMAT、fn
+type dispatcher struct {
+	typ      reflect.Type                         //动态实例的结构体类型
+	byMethod map[string]map[string]*dispatchEntry //http method => FORMAT(空字符串表示不限) => entry
+	byFormat map[string]*dispatchEntry            //FORMAT => entry
+	def      *dispatchEntry                       //fn
+	pool     *sync.Pool                           //动态实例对象池，为nil时每次请求都新建实例
+}
+
+// 生成动态实例t(指针类型)中行为fn的调度表。names为基本类型参数依次对应的参数名称
+func newDispatcher(t reflect.Type, fn string, names []string, usePool bool) (*dispatcher, error) {
+	e := t.Elem()
+	d := &dispatcher{
+		typ:      e,
+		byMethod: make(map[string]map[string]*dispatchEntry),
+		byFormat: make(map[string]*dispatchEntry),
+	}
+	prefix := fn + `_`
+	v := reflect.New(e)
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		var suffix string
+		if m.Name != fn {
+			if !strings.HasPrefix(m.Name, prefix) {
+				continue
+			}
+			suffix = strings.TrimPrefix(m.Name, prefix)
+			if suffix != strings.ToUpper(suffix) {
+				continue
+			}
+		}
+		sig, err := parseActionSignature(v.Method(m.Index).Type(), names)
+		if err != nil {
+			return nil, err
+		}
+		entry := &dispatchEntry{index: m.Index, sig: sig}
+		if suffix == `` {
+			d.def = entry
+			continue
+		}
+		method, format := splitMethodFormat(suffix)
+		if method == `` {
+			d.byFormat[format] = entry
+			continue
+		}
+		if _, ok := d.byMethod[method]; !ok {
+			d.byMethod[method] = make(map[string]*dispatchEntry)
+		}
+		d.byMethod[method][format] = entry
+	}
+	if usePool {
+		d.pool = &sync.Pool{
+			New: func() interface{} {
+				return reflect.New(e).Interface()
+			},
+		}
+	}
+	return d, nil
+}
+
+// 将方法名后缀拆分为http method和FORMAT，例如：GETJSON => GET,JSON
+func splitMethodFormat(suffix string) (method string, format string) {
+	for _, m := range echo.Methods() {
+		if strings.HasPrefix(suffix, m) {
+			if len(m) > len(method) {
+				method = m
+			}
+		}
+	}
+	format = strings.TrimPrefix(suffix, method)
+	return
+}
+
+// 根据请求方式和格式(大写)选择行为方法，没有匹配的方法时返回nil
+func (d *dispatcher) resolve(method string, format string) *dispatchEntry {
+	if fm, ok := d.byMethod[method]; ok {
+		if entry, ok := fm[format]; ok {
+			return entry
+		}
+		if entry, ok := fm[``]; ok {
+			return entry
+		}
+	}
+	if entry, ok := d.byFormat[format]; ok {
+		return entry
+	}
+	return d.def
+}
+
+// 获取动态实例
+func (d *dispatcher) get() interface{} {
+	if d.pool == nil {
+		return reflect.New(d.typ).Interface()
+	}
+	return d.pool.Get()
+}
+
+// 清空动态实例的字段后放回对象池
+func (d *dispatcher) put(ac interface{}) {
+	if d.pool == nil {
+		return
+	}
+	reflect.ValueOf(ac).Elem().Set(reflect.Zero(d.typ))
+	d.pool.Put(ac)
+}
+
+// 生成动态实例中行为的处理函数
+func (a *Wrapper) dispatchHandler(d *dispatcher, u *Url, act string) echo.HandlerFunc {
+	ctl := d.typ.Name()
+	return func(ctx echo.Context) error {
+		c := X(ctx)
+		if !u.ValidExt(c.Format) {
+			return c.HTML(404, `The contents can not be displayed in this format: `+c.Format)
+		}
+		entry := d.resolve(c.Method(), strings.ToUpper(c.Format))
+		if entry == nil {
+			return echo.NewHTTPError(http.StatusMethodNotAllowed)
+		}
+		ac := d.get()
+		defer d.put(ac)
+		if err := c.Init(a.App, ac, ctl, act); err != nil {
+			return err
+		}
+		if err := ac.(Initer).Init(c); err != nil {
+			return err
+		}
+		if err := c.checkRoute(u); err != nil || c.Exit {
+			return err
+		}
+		if a.HasBefore {
+			if err := ac.(Before).Before(); err != nil {
+				return err
+			}
+			if c.Exit {
+				return nil
+			}
+		}
+		m := reflect.ValueOf(ac).Method(entry.index)
+		hasData, err := a.callAction(c, m, entry.sig)
+		if err != nil {
+			return err
+		}
+		if a.HasAfter {
+			if c.Exit {
+				return nil
+			}
+			if err := ac.(After).After(); err != nil {
+				return err
+			}
+		}
+		if hasData && !c.Exit {
+			return c.Display()
+		}
+		return nil
+	}
+}
@@ -0,0 +1,142 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type benchController struct {
+	Name  string
+	Count int
+}
+
+func (a *benchController) Index() error {
+	a.Count++
+	return nil
+}
+
+func (a *benchController) Index_GET() error {
+	a.Count++
+	return nil
+}
+
+func (a *benchController) Index_POSTJSON() error {
+	a.Count++
+	return nil
+}
+
+func (a *benchController) Index_XML() error {
+	a.Count++
+	return nil
+}
+
+func (a *benchController) Index_helper() {}
+
+func TestDispatcherResolve(t *testing.T) {
+	d, err := newDispatcher(reflect.TypeOf(&benchController{}), `Index`, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := reflect.TypeOf(&benchController{})
+	tests := []struct {
+		method, format, want string
+	}{
+		{`POST`, `JSON`, `Index_POSTJSON`},
+		{`POST`, `HTML`, `Index`},
+		{`GET`, `JSON`, `Index_GET`},
+		{`PUT`, `XML`, `Index_XML`},
+		{`DELETE`, ``, `Index`},
+	}
+	for _, test := range tests {
+		entry := d.resolve(test.method, test.format)
+		if entry == nil {
+			t.Fatalf(`%v %v: no method`, test.method, test.format)
+		}
+		if name := typ.Method(entry.index).Name; name != test.want {
+			t.Errorf(`%v %v: got %v, want %v`, test.method, test.format, name, test.want)
+		}
+	}
+}
+
+func TestDispatcherPool(t *testing.T) {
+	d, _ := newDispatcher(reflect.TypeOf(&benchController{}), `Index`, nil, true)
+	ac := d.get().(*benchController)
+	ac.Name = `used`
+	ac.Count = 10
+	d.put(ac)
+	ac = d.get().(*benchController)
+	if ac.Name != `` || ac.Count != 0 {
+		t.Errorf(`pooled instance was not reset: %+v`, ac)
+	}
+}
+
+// 注册时预先生成调度表之前的实现：每次请求新建实例并按名称查找方法
+func BenchmarkDispatchLegacy(b *testing.B) {
+	a := &Wrapper{}
+	e := reflect.TypeOf(benchController{})
+	fn := `Index`
+	method, format := `DELETE`, strings.ToUpper(`html`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v := reflect.New(e)
+		m := v.MethodByName(fn + `_` + method + format)
+		if !m.IsValid() {
+			m = v.MethodByName(fn + `_` + method)
+			if !m.IsValid() {
+				m = v.MethodByName(fn + `_` + format)
+				if !m.IsValid() {
+					m = v.MethodByName(fn)
+				}
+			}
+		}
+		if _, err := a.SafelyCall(m, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDispatchTable(b *testing.B) {
+	benchmarkDispatchTable(b, true)
+}
+
+func BenchmarkDispatchTableNoPool(b *testing.B) {
+	benchmarkDispatchTable(b, false)
+}
+
+func benchmarkDispatchTable(b *testing.B, usePool bool) {
+	a := &Wrapper{}
+	d, err := newDispatcher(reflect.TypeOf(&benchController{}), `Index`, nil, usePool)
+	if err != nil {
+		b.Fatal(err)
+	}
+	method, format := `DELETE`, strings.ToUpper(`html`)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entry := d.resolve(method, format)
+		ac := d.get()
+		if _, err := a.SafelyCall(reflect.ValueOf(ac).Method(entry.index), nil); err != nil {
+			b.Fatal(err)
+		}
+		d.put(ac)
+	}
+}
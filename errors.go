@@ -0,0 +1,206 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"net/http"
+	"sort"
+
+	"github.com/webx-top/echo"
+)
+
+// Error 是可以由行为方法返回的错误。
+// Message和Fields会展示给客户端，Cause只记录到日志中
+type Error struct {
+	Code    string            //业务错误码，例如：invalid_param
+	Status  int               //HTTP状态码
+	Message string            //展示给客户端的信息
+	Type    string            //错误类型说明文档的网址，为空时使用about:blank
+	Cause   error             //内部原因
+	Fields  map[string]string //字段名 => 字段错误信息
+}
+
+// NewError 创建错误。message为空时使用HTTP状态码对应的文本
+func NewError(status int, message string, code ...string) *Error {
+	if message == `` {
+		message = http.StatusText(status)
+	}
+	e := &Error{Status: status, Message: message}
+	if len(code) > 0 {
+		e.Code = code[0]
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + `: ` + e.Cause.Error()
+	}
+	return e.Message
+}
+
+// SetCause 设置内部原因
+func (e *Error) SetCause(err error) *Error {
+	e.Cause = err
+	return e
+}
+
+// AddField 添加字段错误
+func (e *Error) AddField(field string, message string) *Error {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+	e.Fields[field] = message
+	return e
+}
+
+// Problem 生成RFC 7807格式的错误详情
+func (e *Error) Problem(instance string) *Problem {
+	p := &Problem{
+		Type:     e.Type,
+		Title:    http.StatusText(e.Status),
+		Status:   e.Status,
+		Detail:   e.Message,
+		Instance: instance,
+		Code:     e.Code,
+		Errors:   e.Fields,
+	}
+	if p.Type == `` {
+		p.Type = `about:blank`
+	}
+	if p.Title == `` {
+		p.Title = e.Message
+	}
+	return p
+}
+
+// ToError 将err转换为*Error。public为true时err的信息会展示给客户端，
+// 否则只展示HTTP状态码对应的文本，err作为内部原因
+func ToError(err error, public bool) *Error {
+	switch e := err.(type) {
+	case *Error:
+		return e
+	case *echo.HTTPError:
+		return NewError(e.Code(), e.Error())
+	}
+	if public {
+		return NewError(http.StatusInternalServerError, err.Error())
+	}
+	return NewError(http.StatusInternalServerError, ``).SetCause(err)
+}
+
+// Problem 是RFC 7807定义的错误详情
+type Problem struct {
+	XMLName  xml.Name          `json:"-" xml:"urn:ietf:rfc:7807 problem"`
+	Type     string            `json:"type" xml:"type"`
+	Title    string            `json:"title" xml:"title"`
+	Status   int               `json:"status" xml:"status"`
+	Detail   string            `json:"detail,omitempty" xml:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty" xml:"instance,omitempty"`
+	Code     string            `json:"code,omitempty" xml:"code,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty" xml:"-"`
+}
+
+type problemField struct {
+	Field   string `xml:"field,attr"`
+	Message string `xml:",chardata"`
+}
+
+// MarshalXML 将Errors输出为<errors><error field="...">...</error></errors>
+func (p *Problem) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type problem Problem
+	v := struct {
+		*problem
+		Fields []problemField `xml:"errors>error,omitempty"`
+	}{problem: (*problem)(p)}
+	names := make([]string, 0, len(p.Errors))
+	for name := range p.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		v.Fields = append(v.Fields, problemField{Field: name, Message: p.Errors[name]})
+	}
+	return e.Encode(v)
+}
+
+// ErrorRender 以某种格式输出错误详情，状态码为Context.Code
+type ErrorRender func(c *Context, p *Problem) error
+
+// 输出application/problem+json
+func renderProblemJSON(c *Context, p *Problem) error {
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.Blob(c.Code, `application/problem+json`, b)
+}
+
+// 输出application/problem+xml
+func renderProblemXML(c *Context, p *Problem) error {
+	b, err := xml.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.Blob(c.Code, `application/problem+xml`, append([]byte(xml.Header), b...))
+}
+
+// RenderError 按Context.Format输出错误：json和xml格式输出RFC 7807错误详情，
+// 其它格式将错误信息赋值给Output后输出，模板为Server.ErrorTemplate(为空时只输出错误信息)。
+// 非*Error类型的错误信息会展示给客户端，内部原因只记录到日志中
+func (c *Context) RenderError(err error) error {
+	e := ToError(err, true)
+	if e.Cause != nil {
+		c.Server.Core.Logger().Error(e.Error())
+	}
+	if c.Response().Committed() {
+		return nil
+	}
+	c.Exit = true
+	c.Code = e.Status
+	if c.Code <= 0 {
+		c.Code = http.StatusInternalServerError
+	}
+	p := e.Problem(c.Request().URI())
+	p.Status = c.Code
+	if render := c.Server.Formats.GetError(c.Format); render != nil && c.Query(`callback`) == `` {
+		if c.Query(`format`) == `` {
+			AddVary(c.Response().Header(), `Accept`)
+		}
+		return render(c, p)
+	}
+	c.Output.Status = FAILURE
+	c.Output.Message = e.Message
+	c.Output.Data = p
+	c.Tmpl = ``
+	if c.App != nil {
+		c.Tmpl = c.Server.ErrorTemplate
+	}
+	return c.render()
+}
+
+// HTTPErrorHandler 输出未被处理的错误。
+// 非*Error和*echo.HTTPError类型的错误只记录到日志中，客户端只能看到HTTP状态码对应的文本
+func (s *Server) HTTPErrorHandler(err error, ctx echo.Context) {
+	c := X(ctx)
+	if e := c.RenderError(ToError(err, false)); e != nil {
+		s.Core.Logger().Error(e)
+	}
+}
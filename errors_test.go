@@ -0,0 +1,67 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestErrorProblem(t *testing.T) {
+	e := NewError(400, `Invalid form`, `invalid_param`).
+		AddField(`name`, `Name is required`).
+		AddField(`age`, `Age must be positive`).
+		SetCause(errors.New(`sql: no rows`))
+	p := e.Problem(`/user/add`)
+
+	b, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"about:blank","title":"Bad Request","status":400,"detail":"Invalid form","instance":"/user/add","code":"invalid_param","errors":{"age":"Age must be positive","name":"Name is required"}}`
+	if string(b) != want {
+		t.Errorf("json:\n got %s\nwant %s", b, want)
+	}
+
+	b, err = xml.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `<problem xmlns="urn:ietf:rfc:7807"><type>about:blank</type><title>Bad Request</title><status>400</status><detail>Invalid form</detail><instance>/user/add</instance><code>invalid_param</code><errors><error field="age">Age must be positive</error><error field="name">Name is required</error></errors></problem>`
+	if string(b) != want {
+		t.Errorf("xml:\n got %s\nwant %s", b, want)
+	}
+
+	if strings.Contains(string(b), `sql`) {
+		t.Error(`internal cause must not be exposed`)
+	}
+}
+
+func TestToError(t *testing.T) {
+	e := ToError(errors.New(`boom`), false)
+	if e.Status != 500 || e.Message != `Internal Server Error` || e.Cause == nil {
+		t.Errorf(`unexpected error: %#v`, e)
+	}
+	e = ToError(errors.New(`boom`), true)
+	if e.Message != `boom` {
+		t.Errorf(`unexpected error: %#v`, e)
+	}
+}
@@ -0,0 +1,313 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/webx-top/echo/engine"
+	"github.com/webx-top/webx/lib/events"
+)
+
+const (
+	// 子进程继承监听socket时使用的环境变量名。被继承的socket固定为文件描述符3
+	InheritEnvKey = `WEBX_INHERIT_LISTENER`
+)
+
+// 被继承的socket的文件描述符，由Restart通过ExtraFiles传递时固定为3
+var inheritFd uintptr = 3
+
+var ErrNotListening = errors.New(`The server is not listening on a socket that can be inherited.`)
+
+func newGraceful() *graceful {
+	return &graceful{
+		conns:   make(map[net.Conn]struct{}),
+		idle:    make(chan struct{}),
+		drained: make(chan struct{}),
+	}
+}
+
+// graceful 记录监听socket、活动连接和正在处理的请求，用于平滑关闭和重启
+type graceful struct {
+	listener *gracefulListener
+	engine   engine.Engine //通过Run直接启动的engine.Engine，没有可关闭的listener
+	conns    map[net.Conn]struct{}
+	active   int
+	closing  bool
+	idled    bool          //idle是否已关闭
+	idle     chan struct{} //当closing为true且无正在处理的请求时关闭
+	drained  chan struct{} //排空结束时关闭
+	once     sync.Once
+	mutex    sync.Mutex
+}
+
+func (g *graceful) begin() bool {
+	g.mutex.Lock()
+	g.active++
+	closing := g.closing
+	g.mutex.Unlock()
+	return closing
+}
+
+func (g *graceful) end() {
+	g.mutex.Lock()
+	g.active--
+	if g.closing && g.active == 0 && !g.idled {
+		g.idled = true
+		close(g.idle)
+	}
+	g.mutex.Unlock()
+}
+
+func (g *graceful) isClosing() bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.closing
+}
+
+func (g *graceful) add(c net.Conn) {
+	g.mutex.Lock()
+	g.conns[c] = struct{}{}
+	g.mutex.Unlock()
+}
+
+func (g *graceful) remove(c net.Conn) {
+	g.mutex.Lock()
+	delete(g.conns, c)
+	g.mutex.Unlock()
+}
+
+// startClosing 标记为关闭中并返回在所有请求处理完毕时关闭的通道
+func (g *graceful) startClosing() <-chan struct{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if !g.closing {
+		g.closing = true
+		if g.active == 0 && !g.idled {
+			g.idled = true
+			close(g.idle)
+		}
+	}
+	return g.idle
+}
+
+// 停止接受新连接并等待正在处理的请求完成，超过timeout(大于0时)后强制断开剩余连接。
+// onClosing在停止接受新连接之前执行。
+// 超时时返回true。只有第一次调用会执行，之后的调用等待第一次调用结束
+func (g *graceful) shutdown(timeout time.Duration, onClosing func()) (timedOut bool) {
+	g.once.Do(func() {
+		if onClosing != nil {
+			onClosing()
+		}
+		idle := g.startClosing()
+		if g.listener != nil {
+			g.listener.Close()
+		}
+		if timeout > 0 {
+			select {
+			case <-idle:
+			case <-time.After(timeout):
+				timedOut = true
+			}
+		} else {
+			<-idle
+		}
+		g.closeConns()
+		close(g.drained)
+	})
+	<-g.drained
+	return
+}
+
+// 停止通过Run直接启动的engine.Engine。engine需实现Stop() error、Shutdown() error或Close() error之一，
+// 否则返回false，此时无法停止接受新连接，但Run仍会在排空请求后返回
+func stopEngine(e engine.Engine) bool {
+	switch v := e.(type) {
+	case interface {
+		Stop() error
+	}:
+		v.Stop()
+	case interface {
+		Shutdown() error
+	}:
+		v.Shutdown()
+	case interface {
+		Close() error
+	}:
+		v.Close()
+	default:
+		return false
+	}
+	return true
+}
+
+func (g *graceful) closeConns() {
+	g.mutex.Lock()
+	conns := make([]net.Conn, 0, len(g.conns))
+	for c := range g.conns {
+		conns = append(conns, c)
+	}
+	g.mutex.Unlock()
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// gracefulListener 记录通过它建立的所有连接
+type gracefulListener struct {
+	net.Listener
+	g *graceful
+}
+
+func (l *gracefulListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if tc, ok := c.(*net.TCPConn); ok {
+		tc.SetKeepAlive(true)
+		tc.SetKeepAlivePeriod(3 * time.Minute)
+	}
+	gc := &gracefulConn{Conn: c, g: l.g}
+	l.g.add(gc)
+	return gc, nil
+}
+
+func (l *gracefulListener) File() (*os.File, error) {
+	fl, ok := l.Listener.(interface {
+		File() (*os.File, error)
+	})
+	if !ok {
+		return nil, ErrNotListening
+	}
+	return fl.File()
+}
+
+type gracefulConn struct {
+	net.Conn
+	g    *graceful
+	once sync.Once
+}
+
+func (c *gracefulConn) Close() (err error) {
+	c.once.Do(func() {
+		c.g.remove(c)
+		err = c.Conn.Close()
+	})
+	return
+}
+
+// Listen 创建监听socket。由本程序通过Restart启动的子进程会直接使用继承来的socket
+func Listen(addr string) (net.Listener, error) {
+	if os.Getenv(InheritEnvKey) != `` {
+		os.Unsetenv(InheritEnvKey)
+		f := os.NewFile(inheritFd, `listener`)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err == nil {
+			return ln, nil
+		}
+	}
+	return net.Listen(`tcp`, addr)
+}
+
+// 包装HTTP服务执行入口，记录正在处理的请求数
+func (s *Server) serveGraceful(r engine.Request, w engine.Response) {
+	g := s.graceful
+	if g.begin() {
+		w.Header().Set(`Connection`, `close`)
+	}
+	defer g.end()
+	s.ServeHTTP(r, w)
+}
+
+// 监听系统信号：SIGINT和SIGTERM时平滑关闭，SIGHUP时平滑重启
+func (s *Server) handleSignals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range ch {
+			s.Core.Logger().Infof(`Server "%v" received signal: %v`, s.Name, sig)
+			if sig == syscall.SIGHUP {
+				if err := s.Restart(); err != nil {
+					s.Core.Logger().Error(err)
+					continue
+				}
+				signal.Stop(ch)
+				return
+			}
+			//再次收到信号时按系统默认行为直接退出
+			signal.Stop(ch)
+			s.Shutdown()
+			return
+		}
+	}()
+}
+
+// Shutdown 平滑关闭服务：停止接受新连接，然后等待正在处理的请求完成。
+// 等待时间不超过参数timeout(未指定时使用Server.DrainTimeout)，超时后强制断开剩余连接。
+// 排空前触发事件webx.serverShutdown，排空后由Run触发事件webx.serverExit
+func (s *Server) Shutdown(timeout ...time.Duration) {
+	d := s.DrainTimeout
+	if len(timeout) > 0 {
+		d = timeout[0]
+	}
+	timedOut := s.graceful.shutdown(d, func() {
+		s.Core.Logger().Infof(`Server "%v" is shutting down.`, s.Name)
+		//同步执行，保证事件处理函数在排空开始之前完成
+		events.Event(`webx.serverShutdown`, s, func(_ bool) {})
+		if s.graceful.engine != nil && !stopEngine(s.graceful.engine) {
+			s.Core.Logger().Warnf(`Server "%v": the engine can not be stopped, it still accepts new connections while draining.`, s.Name)
+		}
+	})
+	if timedOut {
+		s.Core.Logger().Warnf(`Server "%v" drain timeout(%v), forcing connections to close.`, s.Name, d)
+	}
+}
+
+// Restart 平滑重启：启动新进程并将监听socket交给它，然后平滑关闭当前服务
+func (s *Server) Restart() error {
+	g := s.graceful
+	if g.listener == nil {
+		return ErrNotListening
+	}
+	f, err := g.listener.File()
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmd := exec.Command(os.Args[0], os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), InheritEnvKey+`=1`)
+	cmd.ExtraFiles = []*os.File{f}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	s.Core.Logger().Infof(`Server "%v" restarted with new process %d.`, s.Name, cmd.Process.Pid)
+	go s.Shutdown()
+	return nil
+}
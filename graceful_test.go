@@ -0,0 +1,154 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// 启动使用gracefulListener的HTTP服务，handler执行期间计入正在处理的请求
+func startGraceful(t *testing.T, g *graceful, handler http.HandlerFunc) string {
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.listener = &gracefulListener{Listener: ln, g: g}
+	go http.Serve(g.listener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if g.begin() {
+			w.Header().Set(`Connection`, `close`)
+		}
+		defer g.end()
+		handler(w, r)
+	}))
+	return `http://` + ln.Addr().String()
+}
+
+func TestGracefulDrain(t *testing.T) {
+	g := newGraceful()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	url := startGraceful(t, g, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte(`done`))
+	})
+	result := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url)
+		if err != nil {
+			result <- err.Error()
+			return
+		}
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		result <- string(b)
+	}()
+	<-started
+
+	var order []string
+	done := make(chan bool)
+	go func() {
+		done <- g.shutdown(5*time.Second, func() { order = append(order, `closing`) })
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-g.drained:
+		t.Fatal(`drained before the active request finished`)
+	default:
+	}
+	if _, err := net.DialTimeout(`tcp`, url[len(`http://`):], time.Second); err == nil {
+		t.Error(`listener still accepts connections after shutdown started`)
+	}
+	close(release)
+	if got := <-result; got != `done` {
+		t.Errorf(`active request got %q; want "done"`, got)
+	}
+	if timedOut := <-done; timedOut {
+		t.Error(`shutdown timed out`)
+	}
+	if len(order) != 1 {
+		t.Errorf(`onClosing called %d times; want 1`, len(order))
+	}
+	//再次调用时直接返回
+	g.shutdown(time.Second, func() { t.Error(`onClosing called twice`) })
+}
+
+func TestGracefulDrainTimeout(t *testing.T) {
+	g := newGraceful()
+	started := make(chan struct{})
+	url := startGraceful(t, g, func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(time.Second)
+	})
+	go http.Get(url)
+	<-started
+	begin := time.Now()
+	if !g.shutdown(50*time.Millisecond, nil) {
+		t.Error(`shutdown should time out`)
+	}
+	if d := time.Since(begin); d > 500*time.Millisecond {
+		t.Errorf(`shutdown took %v`, d)
+	}
+	g.end()
+	g.end() //超时后请求结束不能重复关闭idle
+}
+
+func TestListenInherited(t *testing.T) {
+	ln, err := net.Listen(`tcp`, `127.0.0.1:0`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	//Listen会关闭被继承的文件描述符，所以使用复制的文件描述符
+	fd, err := syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := inheritFd
+	inheritFd = uintptr(fd)
+	defer func() { inheritFd = old }()
+	os.Setenv(InheritEnvKey, `1`)
+
+	inherited, err := Listen(`127.0.0.1:1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	if os.Getenv(InheritEnvKey) != `` {
+		t.Error(`inherit env must be unset`)
+	}
+	if inherited.Addr().String() != ln.Addr().String() {
+		t.Errorf(`inherited listener address %v; want %v`, inherited.Addr(), ln.Addr())
+	}
+	c, err := net.Dial(`tcp`, ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
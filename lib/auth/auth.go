@@ -0,0 +1,252 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/com"
+)
+
+// 保存当前用户的Context键名
+const USER_KEY = `webx:user`
+
+var (
+	ErrInvalidCredentials = errors.New(`auth: invalid username or password`)
+	ErrNoCodec            = errors.New(`auth: Server.Codec is required for remember-me cookie`)
+)
+
+// User 是已登录的用户
+type User interface {
+	UserID() string
+}
+
+// Loader 根据用户ID加载用户，用户不存在时返回nil
+type Loader interface {
+	LoadUser(id string) (User, error)
+}
+
+// LoaderFunc 将函数转换为Loader
+type LoaderFunc func(id string) (User, error)
+
+func (f LoaderFunc) LoadUser(id string) (User, error) {
+	return f(id)
+}
+
+// Provider 是身份提供者(例如表单密码、LDAP、第三方登录)，
+// 从请求中识别用户，凭据无效时返回错误
+type Provider interface {
+	Authenticate(c *X.Context) (User, error)
+}
+
+// RememberTokener 可由User实现，返回随密码等信息变化的值，
+// 变化后已发出的remember-me cookie随之失效
+type RememberTokener interface {
+	RememberToken() string
+}
+
+func New(loader Loader) *Auth {
+	return &Auth{
+		Loader:         loader,
+		LoginURL:       `/login`,
+		NextParam:      `next`,
+		RememberCookie: `remember`,
+		RememberMaxAge: 30 * 24 * time.Hour,
+		Message:        `请先登录`,
+	}
+}
+
+type Auth struct {
+	Loader         Loader
+	LoginURL       string        //html格式未登录时跳转的网址
+	NextParam      string        //跳转到LoginURL时附带的原网址参数名
+	RememberCookie string        //remember-me cookie名称(不含Server.CookiePrefix)
+	RememberMaxAge time.Duration //remember-me cookie有效期
+	Message        string        //json、xml等格式未登录时输出的信息
+}
+
+// Authenticate 使用身份提供者p识别用户并登录
+func (a *Auth) Authenticate(c *X.Context, p Provider, remember bool) (User, error) {
+	user, err := p.Authenticate(c)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidCredentials
+	}
+	return user, a.Login(c, user, remember)
+}
+
+// Login 登录：更换session ID以免session固定攻击，并将session绑定到用户。
+// remember为true时同时设置remember-me cookie
+func (a *Auth) Login(c *X.Context, user User, remember bool) error {
+	sess := c.Session()
+	sess.RegenerateID()
+	sess.SetUser(user.UserID())
+	c.Set(USER_KEY, user)
+	if remember {
+		return a.remember(c, user)
+	}
+	return nil
+}
+
+// Logout 注销：销毁session并删除remember-me cookie
+func (a *Auth) Logout(c *X.Context) {
+	c.Session().Destroy()
+	c.Set(USER_KEY, nil)
+	c.SetCookie(a.RememberCookie, ``, -1)
+}
+
+// User 返回当前用户，未登录时返回nil。
+// 依次从Context、session和remember-me cookie中获取
+func (a *Auth) User(c *X.Context) (User, error) {
+	if user, ok := c.Get(USER_KEY).(User); ok {
+		return user, nil
+	}
+	if uid := c.Session().User(); uid != `` {
+		user, err := a.Loader.LoadUser(uid)
+		if err != nil || user == nil {
+			return nil, err
+		}
+		c.Set(USER_KEY, user)
+		return user, nil
+	}
+	return a.recall(c)
+}
+
+// 生成remember-me cookie的值：用户ID|过期时间|令牌哈希，使用Server.Codec签名加密
+func (a *Auth) remember(c *X.Context, user User) error {
+	if c.Server.Codec == nil {
+		return ErrNoCodec
+	}
+	value := rememberValue(user, time.Now().Add(a.RememberMaxAge))
+	encoded, err := c.Server.Codec.Encode(a.RememberCookie, value)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(a.RememberCookie, encoded, int64(a.RememberMaxAge/time.Second))
+	return nil
+}
+
+// 根据remember-me cookie恢复登录
+func (a *Auth) recall(c *X.Context) (User, error) {
+	if c.Server.Codec == nil {
+		return nil, nil
+	}
+	encoded := c.GetCookie(a.RememberCookie)
+	if encoded == `` {
+		return nil, nil
+	}
+	var value string
+	if err := c.Server.Codec.Decode(a.RememberCookie, encoded, &value); err != nil {
+		c.SetCookie(a.RememberCookie, ``, -1)
+		return nil, nil
+	}
+	uid, token, ok := parseRemember(value)
+	if !ok {
+		c.SetCookie(a.RememberCookie, ``, -1)
+		return nil, nil
+	}
+	user, err := a.Loader.LoadUser(uid)
+	if err != nil || user == nil {
+		return nil, err
+	}
+	if !checkRememberToken(user, token) {
+		c.SetCookie(a.RememberCookie, ``, -1)
+		return nil, nil
+	}
+	return user, a.Login(c, user, false)
+}
+
+// remember-me cookie的值(签名加密前)：用户ID|过期时间|令牌哈希
+func rememberValue(user User, expire time.Time) string {
+	return user.UserID() + `|` + strconv.FormatInt(expire.Unix(), 10) + `|` + rememberToken(user)
+}
+
+// 解析remember-me cookie的值，格式无效或已过期时ok为false
+func parseRemember(value string) (uid string, token string, ok bool) {
+	parts := strings.SplitN(value, `|`, 3)
+	if len(parts) != 3 {
+		return
+	}
+	expire, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || expire < time.Now().Unix() {
+		return
+	}
+	return parts[0], parts[2], true
+}
+
+// 使用固定时间比较令牌，以免通过响应时间猜测令牌
+func checkRememberToken(user User, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(rememberToken(user)), []byte(token)) == 1
+}
+
+func rememberToken(user User) string {
+	if t, ok := user.(RememberTokener); ok {
+		return com.Hash(t.RememberToken())
+	}
+	return ``
+}
+
+// RequireLogin 返回要求登录的中间件。未登录时，html格式跳转到LoginURL，
+// 其它格式(json、xml等)使用SetNoAuth输出Message
+func (a *Auth) RequireLogin() echo.MiddlewareFunc {
+	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
+		return echo.HandlerFunc(func(ctx echo.Context) error {
+			c := X.X(ctx)
+			user, err := a.User(c)
+			if err != nil {
+				return err
+			}
+			if user != nil {
+				return h.Handle(c)
+			}
+			return a.Deny(c)
+		})
+	})
+}
+
+// Deny 输出未登录：html格式跳转到LoginURL，其它格式使用SetNoAuth输出Message
+func (a *Auth) Deny(c *X.Context) error {
+	if c.Format == `html` {
+		loginURL := a.LoginURL
+		if a.NextParam != `` {
+			sep := `?`
+			if strings.Contains(loginURL, `?`) {
+				sep = `&`
+			}
+			loginURL += sep + a.NextParam + `=` + url.QueryEscape(c.Request().URI())
+		}
+		return c.Redirect(http.StatusFound, loginURL)
+	}
+	//json、xml等格式不使用模板，使用DisplayError输出，以免在App初始化之前由Display生成模板名
+	msg := a.Message
+	if msg == `` {
+		msg = http.StatusText(http.StatusUnauthorized)
+	}
+	c.SetNoAuth(msg)
+	return c.DisplayError(msg, http.StatusUnauthorized)
+}
@@ -0,0 +1,109 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/com"
+)
+
+type testUser struct {
+	id       string
+	token    string
+	password string
+	salt     string
+}
+
+func (u *testUser) UserID() string             { return u.id }
+func (u *testUser) RememberToken() string      { return u.token }
+func (u *testUser) Password() (string, string) { return u.password, u.salt }
+
+func TestRemember(t *testing.T) {
+	user := &testUser{id: `1`, token: `secret`}
+	value := rememberValue(user, time.Now().Add(time.Hour))
+	uid, token, ok := parseRemember(value)
+	if !ok || uid != `1` {
+		t.Fatalf(`parseRemember(%q) = %q, %q, %v`, value, uid, token, ok)
+	}
+	if !checkRememberToken(user, token) {
+		t.Error(`token should match`)
+	}
+	//修改密码等信息后令牌失效
+	user.token = `changed`
+	if checkRememberToken(user, token) {
+		t.Error(`token should not match after it is changed`)
+	}
+
+	expired := `1|` + strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10) + `|` + token
+	for _, v := range []string{expired, `1|abc|` + token, `1`, ``} {
+		if _, _, ok := parseRemember(v); ok {
+			t.Errorf(`parseRemember(%q) should fail`, v)
+		}
+	}
+}
+
+type testContext struct {
+	echo.Context
+	form map[string]string
+}
+
+func (c *testContext) Form(name string) string {
+	return c.form[name]
+}
+
+func TestPasswordProvider(t *testing.T) {
+	user := &testUser{id: `1`, salt: `salt`}
+	user.password = com.MakePassword(`123456`, user.salt)
+	p := NewPasswordProvider(func(username string) (PasswordUser, error) {
+		if username == `admin` {
+			return user, nil
+		}
+		return nil, nil
+	})
+	tests := []struct {
+		username string
+		password string
+		ok       bool
+	}{
+		{`admin`, `123456`, true},
+		{`admin`, `654321`, false},
+		{`guest`, `123456`, false},
+		{`admin`, ``, false},
+	}
+	for _, test := range tests {
+		c := &X.Context{Context: &testContext{form: map[string]string{
+			`username`: test.username,
+			`password`: test.password,
+		}}}
+		u, err := p.Authenticate(c)
+		if test.ok {
+			if err != nil || u != user {
+				t.Errorf(`Authenticate(%v, %v) = %v, %v`, test.username, test.password, u, err)
+			}
+			continue
+		}
+		if err != ErrInvalidCredentials {
+			t.Errorf(`Authenticate(%v, %v) error = %v, want ErrInvalidCredentials`, test.username, test.password, err)
+		}
+	}
+}
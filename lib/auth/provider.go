@@ -0,0 +1,67 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package auth
+
+import (
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/com"
+)
+
+var _ Provider = &PasswordProvider{}
+
+// PasswordUser 是使用密码登录的用户，密码由com.MakePassword生成
+type PasswordUser interface {
+	User
+	Password() (hashed string, salt string)
+}
+
+// NewPasswordProvider 创建从表单获取用户名和密码的身份提供者
+func NewPasswordProvider(find func(username string) (PasswordUser, error)) *PasswordProvider {
+	return &PasswordProvider{
+		Find:          find,
+		UsernameField: `username`,
+		PasswordField: `password`,
+	}
+}
+
+// PasswordProvider 从表单获取用户名和密码，使用com.CheckPassword校验
+type PasswordProvider struct {
+	Find          func(username string) (PasswordUser, error) //用户不存在时返回nil
+	UsernameField string
+	PasswordField string
+}
+
+func (p *PasswordProvider) Authenticate(c *X.Context) (User, error) {
+	username := c.Form(p.UsernameField)
+	password := c.Form(p.PasswordField)
+	if username == `` || password == `` {
+		return nil, ErrInvalidCredentials
+	}
+	user, err := p.Find(username)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrInvalidCredentials
+	}
+	hashed, salt := user.Password()
+	if !com.CheckPassword(password, hashed, salt) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -2,16 +2,21 @@ package cachestore
 
 import (
 	"log"
+	"strconv"
+	"time"
 
 	bolt "github.com/boltdb/bolt"
 )
 
+var _ Cache = &Bolt{}
+
 // Bolt implements ds.Datastore
 // TODO: use buckets to represent the heirarchy of the ds.Keys
 type Bolt struct {
 	db         *bolt.DB
 	bucketName []byte
 	Debug      bool
+	LifeTime   int32 //Put使用的默认有效期(秒)，0表示永不过期
 }
 
 func NewBolt(dbFile, bucket string) (*Bolt, error) {
@@ -45,25 +50,34 @@ func (bd *Bolt) Del(key string) error {
 	})
 }
 
+// 获取未过期的数据(已复制)，不存在时返回nil
+func (bd *Bolt) get(buck *bolt.Bucket, key string) []byte {
+	mmval := buck.Get([]byte(key))
+	if mmval == nil {
+		return nil
+	}
+	data, _, ok := splitExpire(mmval)
+	if !ok {
+		return nil
+	}
+	out := make([]byte, len(data))
+	copy(out, data)
+	return out
+}
+
 func (bd *Bolt) Get(key string) (interface{}, error) {
 	var out []byte
 	err := bd.db.View(func(tx *bolt.Tx) error {
-		mmval := tx.Bucket(bd.bucketName).Get([]byte(key))
-		if mmval == nil {
-			return nil
-		}
-		out = make([]byte, len(mmval))
-		copy(out, mmval)
+		out = bd.get(tx.Bucket(bd.bucketName), key)
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
 	if out == nil {
-		return nil, err
+		return nil, ErrNotFound
 	}
-	var v interface{}
-	err = Decode(out, &v)
+	v, err := decodeValue(out)
 	if err != nil {
 		if bd.Debug {
 			log.Println("[Bolt]DecodeErr: ", err, "Key:", key)
@@ -73,13 +87,40 @@ func (bd *Bolt) Get(key string) (interface{}, error) {
 	return v, err
 }
 
+func (bd *Bolt) GetMulti(keys []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	err := bd.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket(bd.bucketName)
+		for _, key := range keys {
+			out := bd.get(buck, key)
+			if out == nil {
+				continue
+			}
+			v, err := decodeValue(out)
+			if err != nil {
+				if bd.Debug {
+					log.Println("[Bolt]DecodeErr: ", err, "Key:", key)
+				}
+				continue
+			}
+			values[key] = v
+		}
+		return nil
+	})
+	return values, err
+}
+
 func (bd *Bolt) ConsumeValue(key string, f func([]byte) error) error {
 	return bd.db.View(func(tx *bolt.Tx) error {
 		mmval := tx.Bucket(bd.bucketName).Get([]byte(key))
 		if mmval == nil {
 			return nil
 		}
-		return f(mmval)
+		data, _, ok := splitExpire(mmval)
+		if !ok {
+			return nil
+		}
+		return f(data)
 	})
 }
 
@@ -87,14 +128,20 @@ func (bd *Bolt) Has(key string) (bool, error) {
 	var found bool
 	err := bd.db.View(func(tx *bolt.Tx) error {
 		val := tx.Bucket(bd.bucketName).Get([]byte(key))
-		found = (val != nil)
+		if val != nil {
+			_, _, found = splitExpire(val)
+		}
 		return nil
 	})
 	return found, err
 }
 
 func (bd *Bolt) Put(key string, val interface{}) error {
-	bval, err := Encode(val)
+	return bd.Set(key, val, lifeTimeDuration(bd.LifeTime))
+}
+
+func (bd *Bolt) Set(key string, val interface{}, ttl time.Duration) error {
+	bval, err := encodeValue(val)
 	if err != nil {
 		if bd.Debug {
 			log.Println("[Bolt]EncodeErr: ", err, "Key:", key)
@@ -102,22 +149,26 @@ func (bd *Bolt) Put(key string, val interface{}) error {
 		return err
 	}
 	return bd.db.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket(bd.bucketName).Put([]byte(key), bval)
+		return tx.Bucket(bd.bucketName).Put([]byte(key), withExpire(bval, ttl))
 	})
 }
 
 func (bd *Bolt) PutMany(data map[string]interface{}) error {
+	return bd.SetMulti(data, lifeTimeDuration(bd.LifeTime))
+}
+
+func (bd *Bolt) SetMulti(data map[string]interface{}, ttl time.Duration) error {
 	return bd.db.Update(func(tx *bolt.Tx) error {
 		buck := tx.Bucket(bd.bucketName)
 		for k, v := range data {
-			bval, err := Encode(v)
+			bval, err := encodeValue(v)
 			if err != nil {
 				if bd.Debug {
 					log.Println("[Bolt]EncodeErr: ", err, "Key:", k)
 				}
 				return err
 			}
-			err = buck.Put([]byte(k), bval)
+			err = buck.Put([]byte(k), withExpire(bval, ttl))
 			if err != nil {
 				return err
 			}
@@ -125,3 +176,41 @@ func (bd *Bolt) PutMany(data map[string]interface{}) error {
 		return nil
 	})
 }
+
+func (bd *Bolt) Incr(key string, delta int64) (int64, error) {
+	var n int64
+	err := bd.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket(bd.bucketName)
+		var ttl time.Duration
+		if mmval := buck.Get([]byte(key)); mmval != nil {
+			if data, expire, ok := splitExpire(mmval); ok {
+				v, err := decodeValue(data)
+				if err != nil {
+					return err
+				}
+				if n, err = toInt64(v); err != nil {
+					return err
+				}
+				ttl = remainTTL(expire)
+			}
+		}
+		n += delta
+		return buck.Put([]byte(key), withExpire([]byte(strconv.FormatInt(n, 10)), ttl))
+	})
+	return n, err
+}
+
+func (bd *Bolt) Decr(key string, delta int64) (int64, error) {
+	return bd.Incr(key, -delta)
+}
+
+// 清空bucket
+func (bd *Bolt) Clear() error {
+	return bd.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bd.bucketName); err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		_, err := tx.CreateBucket(bd.bucketName)
+		return err
+	})
+}
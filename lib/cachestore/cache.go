@@ -0,0 +1,306 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrNotFound 缓存不存在或已过期
+var ErrNotFound = errors.New(`cachestore: key not found`)
+
+// Cache 是所有缓存后端实现的接口。
+// ttl为有效期，小于等于0时永不过期；Put使用缓存实例的默认有效期。
+// 通过Incr/Decr修改的计数器以十进制文本保存，Get时返回int64
+type Cache interface {
+	Get(key string) (interface{}, error) //不存在时返回ErrNotFound
+	Put(key string, value interface{}) error
+	Del(key string) error
+	Set(key string, value interface{}, ttl time.Duration) error
+	GetMulti(keys []string) (map[string]interface{}, error) //返回值中只包含存在的缓存
+	SetMulti(data map[string]interface{}, ttl time.Duration) error
+	Has(key string) (bool, error)
+	Incr(key string, delta int64) (int64, error) //不存在时从0开始计数
+	Decr(key string, delta int64) (int64, error)
+	Clear() error
+	Close() error
+}
+
+var (
+	stores      = make(map[string]func(config string) (Cache, error))
+	storesMutex = &sync.RWMutex{}
+)
+
+// Reg 注册缓存后端。config一般为JSON格式的配置，由各后端自行解析
+func Reg(name string, fn func(config string) (Cache, error)) {
+	storesMutex.Lock()
+	stores[name] = fn
+	storesMutex.Unlock()
+}
+
+// Del 取消注册缓存后端
+func Del(name string) {
+	storesMutex.Lock()
+	delete(stores, name)
+	storesMutex.Unlock()
+}
+
+// Create 根据名称创建缓存。内置的后端及其配置：
+//
+//	bolt     {"file":"cache.db","bucket":"cache","lifetime":"0"}
+//	leveldb  {"file":"cache","lifetime":"0"}
+//	memcache {"conn":"127.0.0.1:11211;127.0.0.1:11212","lifetime":"3600"}
+//	redis    {"conn":"127.0.0.1:6379","key":"WebxRedis","dbnum":"0","lifetime":"3600"}
+//	memory   {"shards":"16","maxentries":"10000","maxbytes":"67108864","cleaninterval":"60","lifetime":"0"}
+//	near     redis的配置加上{"channel":"WebxNearCache","localttl":"60","maxentries":"10000","maxbytes":"0"}
+//
+// 其中lifetime为Put使用的默认有效期(秒)，cleaninterval为定时清理过期缓存的间隔(秒)，
+// localttl为near中本机缓存的最长有效期(秒，默认为60)
+func Create(name string, config string) (Cache, error) {
+	storesMutex.RLock()
+	fn, ok := stores[name]
+	storesMutex.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf(`cachestore: unknown adapter %q (forgotten Reg?)`, name)
+	}
+	return fn(config)
+}
+
+// 解析JSON格式的配置
+func parseConfig(config string) (map[string]string, error) {
+	cf := make(map[string]string)
+	if config == `` {
+		return cf, nil
+	}
+	if err := json.Unmarshal([]byte(config), &cf); err != nil {
+		return nil, fmt.Errorf(`cachestore: invalid config %q: %v`, config, err)
+	}
+	return cf, nil
+}
+
+// 从配置中获取lifetime(秒)
+func configLifeTime(cf map[string]string) int32 {
+	n, _ := strconv.Atoi(cf[`lifetime`])
+	return int32(n)
+}
+
+func init() {
+	Reg(`memory`, func(config string) (Cache, error) {
+		cf, err := parseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts := &MemoryOptions{LifeTime: configLifeTime(cf)}
+		opts.Shards, _ = strconv.Atoi(cf[`shards`])
+		opts.MaxEntries, _ = strconv.Atoi(cf[`maxentries`])
+		opts.MaxBytes, _ = strconv.ParseInt(cf[`maxbytes`], 10, 64)
+		if seconds, _ := strconv.Atoi(cf[`cleaninterval`]); seconds > 0 {
+			opts.CleanInterval = time.Duration(seconds) * time.Second
+		}
+		return NewMemory(opts), nil
+	})
+	Reg(`bolt`, func(config string) (Cache, error) {
+		cf, err := parseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		if cf[`file`] == `` {
+			return nil, errors.New(`cachestore: config has no file key`)
+		}
+		if cf[`bucket`] == `` {
+			cf[`bucket`] = `cache`
+		}
+		c, err := NewBolt(cf[`file`], cf[`bucket`])
+		if err != nil {
+			return nil, err
+		}
+		c.LifeTime = configLifeTime(cf)
+		return c, nil
+	})
+	Reg(`leveldb`, func(config string) (Cache, error) {
+		cf, err := parseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		if cf[`file`] == `` {
+			return nil, errors.New(`cachestore: config has no file key`)
+		}
+		c, err := OpenLevelDB(cf[`file`])
+		if err != nil {
+			return nil, err
+		}
+		c.LifeTime = configLifeTime(cf)
+		return c, nil
+	})
+	Reg(`memcache`, func(config string) (Cache, error) {
+		cf, err := parseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		if cf[`conn`] == `` {
+			return nil, errors.New(`cachestore: config has no conn key`)
+		}
+		return NewMemcache(splitConn(cf[`conn`]), configLifeTime(cf)), nil
+	})
+	Reg(`redis`, func(config string) (Cache, error) {
+		rc := &Redis{}
+		if err := rc.Connect(config); err != nil {
+			return nil, err
+		}
+		return rc, nil
+	})
+	Reg(`near`, func(config string) (Cache, error) {
+		cf, err := parseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		rc := &Redis{}
+		if err := rc.Connect(config); err != nil {
+			return nil, err
+		}
+		opts := &MemoryOptions{}
+		opts.MaxEntries, _ = strconv.Atoi(cf[`maxentries`])
+		opts.MaxBytes, _ = strconv.ParseInt(cf[`maxbytes`], 10, 64)
+		localTTL, _ := strconv.Atoi(cf[`localttl`])
+		return NewNearCache(rc, NewMemory(opts), cf[`channel`], time.Duration(localTTL)*time.Second), nil
+	})
+}
+
+// 将秒数转换为time.Duration
+func lifeTimeDuration(seconds int32) time.Duration {
+	return time.Duration(seconds) * time.Second
+}
+
+// 将ttl转换为秒(不足1秒按1秒计)，ttl小于等于0时返回0
+func ttlSeconds(ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	n := int64(ttl / time.Second)
+	if ttl%time.Second > 0 {
+		n++
+	}
+	return n
+}
+
+// 为不支持过期时间的后端(Bolt、LevelDB)保存的数据添加过期时间：
+// 前8字节为过期时间(UnixNano，0表示永不过期)，之后为数据
+func withExpire(data []byte, ttl time.Duration) []byte {
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+	b := make([]byte, 8+len(data))
+	binary.BigEndian.PutUint64(b, uint64(expire))
+	copy(b[8:], data)
+	return b
+}
+
+// 拆分由withExpire生成的数据。数据格式无效或已过期时ok为false
+func splitExpire(b []byte) (data []byte, expire int64, ok bool) {
+	if len(b) < 8 {
+		return nil, 0, false
+	}
+	expire = int64(binary.BigEndian.Uint64(b))
+	if expire > 0 && expire <= time.Now().UnixNano() {
+		return nil, expire, false
+	}
+	return b[8:], expire, true
+}
+
+// 由过期时间计算剩余有效期，expire为0时返回0(永不过期)
+func remainTTL(expire int64) time.Duration {
+	if expire <= 0 {
+		return 0
+	}
+	ttl := time.Duration(expire - time.Now().UnixNano())
+	if ttl <= 0 {
+		ttl = time.Nanosecond
+	}
+	return ttl
+}
+
+// 编码后的缓存数据的第一个字节，用于与计数器(十进制整数文本)区分。
+// gob数据以消息长度开头，不会以0开头
+const valuePrefix byte = 0
+
+// 编码缓存数据：valuePrefix加上Encode的结果
+func encodeValue(value interface{}) ([]byte, error) {
+	b, err := Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{valuePrefix}, b...), nil
+}
+
+// 解码缓存数据。由encodeValue编码的数据使用Decode解码，计数器(十进制整数文本)解码为int64，
+// 其它数据(没有valuePrefix的旧数据)使用Decode解码
+func decodeValue(b []byte) (interface{}, error) {
+	var v interface{}
+	if len(b) > 0 && b[0] == valuePrefix {
+		err := Decode(b[1:], &v)
+		return v, err
+	}
+	if isInteger(b) {
+		return strconv.ParseInt(string(b), 10, 64)
+	}
+	err := Decode(b, &v)
+	return v, err
+}
+
+func isInteger(b []byte) bool {
+	if len(b) == 0 || len(b) > 20 {
+		return false
+	}
+	for i, c := range b {
+		if c == '-' && i == 0 && len(b) > 1 {
+			continue
+		}
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// 将Get获取到的计数器的值转换为int64
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	case int32:
+		return int64(n), nil
+	case uint64:
+		return int64(n), nil
+	case uint32:
+		return int64(n), nil
+	case uint:
+		return int64(n), nil
+	case string:
+		return strconv.ParseInt(n, 10, 64)
+	}
+	return 0, fmt.Errorf(`cachestore: value of type %T is not a counter`, v)
+}
@@ -0,0 +1,71 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDecodeValue(t *testing.T) {
+	//保存的字符串即使全部是数字，也应该原样返回
+	for _, value := range []interface{}{`12345`, `-1`, `abc`, int64(7), 3.5} {
+		b, err := encodeValue(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := decodeValue(b)
+		if err != nil || v != value {
+			t.Errorf(`decodeValue(encodeValue(%#v)) = %#v, %v`, value, v, err)
+		}
+	}
+	//计数器以十进制文本保存
+	v, err := decodeValue([]byte(`-42`))
+	if err != nil || v != int64(-42) {
+		t.Errorf(`decodeValue("-42") = %#v, %v`, v, err)
+	}
+	//没有前缀的旧数据
+	b, _ := Encode(`old`)
+	v, err = decodeValue(b)
+	if err != nil || v != `old` {
+		t.Errorf(`decodeValue(legacy) = %#v, %v`, v, err)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	b := withExpire([]byte(`data`), time.Hour)
+	data, expire, ok := splitExpire(b)
+	if !ok || string(data) != `data` || expire <= time.Now().UnixNano() {
+		t.Errorf(`splitExpire = %q, %d, %v`, data, expire, ok)
+	}
+	if ttl := remainTTL(expire); ttl <= 0 || ttl > time.Hour {
+		t.Errorf(`remainTTL = %v`, ttl)
+	}
+	b = withExpire([]byte(`data`), 0)
+	if _, expire, ok = splitExpire(b); !ok || expire != 0 {
+		t.Errorf(`splitExpire without ttl = %d, %v`, expire, ok)
+	}
+	b = withExpire([]byte(`data`), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, _, ok = splitExpire(b); ok {
+		t.Error(`expired data should not be ok`)
+	}
+	if n := ttlSeconds(1500 * time.Millisecond); n != 2 {
+		t.Errorf(`ttlSeconds(1.5s) = %d, want 2`, n)
+	}
+}
@@ -19,36 +19,53 @@ package cachestore
 
 import (
 	"log"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/syndtr/goleveldb/leveldb"
 	//"reflect"
 )
 
+var _ Cache = &LevelDB{}
+
 // LevelDB implements CacheStore provide local machine
 type LevelDB struct {
-	store *leveldb.DB
-	Debug bool
+	store    *leveldb.DB
+	Debug    bool
+	LifeTime int32      //Put使用的默认有效期(秒)，0表示永不过期
+	mutex    sync.Mutex //保证Incr/Decr的原子性
 }
 
 func NewLevelDB(dbfile string) *LevelDB {
-	db := &LevelDB{}
-	if h, err := leveldb.OpenFile(dbfile, nil); err != nil {
+	db, err := OpenLevelDB(dbfile)
+	if err != nil {
 		panic(err)
-	} else {
-		db.store = h
 	}
 	return db
 }
 
+func OpenLevelDB(dbfile string) (*LevelDB, error) {
+	h, err := leveldb.OpenFile(dbfile, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &LevelDB{store: h}, nil
+}
+
 func (s *LevelDB) Put(key string, value interface{}) error {
-	val, err := Encode(value)
+	return s.Set(key, value, lifeTimeDuration(s.LifeTime))
+}
+
+func (s *LevelDB) Set(key string, value interface{}, ttl time.Duration) error {
+	val, err := encodeValue(value)
 	if err != nil {
 		if s.Debug {
 			log.Println("[LevelDB]EncodeErr: ", err, "Key:", key)
 		}
 		return err
 	}
-	err = s.store.Put([]byte(key), val, nil)
+	err = s.store.Put([]byte(key), withExpire(val, ttl), nil)
 	if err != nil {
 		if s.Debug {
 			log.Println("[LevelDB]PutErr: ", err, "Key:", key)
@@ -61,16 +78,49 @@ func (s *LevelDB) Put(key string, value interface{}) error {
 	return err
 }
 
+func (s *LevelDB) SetMulti(data map[string]interface{}, ttl time.Duration) error {
+	batch := new(leveldb.Batch)
+	for key, value := range data {
+		val, err := encodeValue(value)
+		if err != nil {
+			if s.Debug {
+				log.Println("[LevelDB]EncodeErr: ", err, "Key:", key)
+			}
+			return err
+		}
+		batch.Put([]byte(key), withExpire(val, ttl))
+	}
+	return s.store.Write(batch, nil)
+}
+
+// 获取未过期的数据，不存在时返回ErrNotFound
+func (s *LevelDB) get(key string) ([]byte, int64, error) {
+	b, err := s.store.Get([]byte(key), nil)
+	if err != nil {
+		if err == leveldb.ErrNotFound {
+			err = ErrNotFound
+		}
+		return nil, 0, err
+	}
+	data, expire, ok := splitExpire(b)
+	if !ok {
+		if expire > 0 {
+			s.store.Delete([]byte(key), nil)
+		}
+		return nil, 0, ErrNotFound
+	}
+	return data, expire, nil
+}
+
 func (s *LevelDB) Get(key string) (interface{}, error) {
-	data, err := s.store.Get([]byte(key), nil)
+	data, _, err := s.get(key)
 	if err != nil {
 		if s.Debug {
 			log.Println("[LevelDB]GetErr: ", err, "Key:", key)
 		}
 		return nil, err
 	}
-	var v interface{}
-	err = Decode(data, &v)
+	v, err := decodeValue(data)
 	if err != nil {
 		if s.Debug {
 			log.Println("[LevelDB]DecodeErr: ", err, "Key:", key)
@@ -83,6 +133,60 @@ func (s *LevelDB) Get(key string) (interface{}, error) {
 	return v, err
 }
 
+func (s *LevelDB) GetMulti(keys []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	for _, key := range keys {
+		v, err := s.Get(key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return values, err
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+func (s *LevelDB) Has(key string) (bool, error) {
+	_, _, err := s.get(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LevelDB) Incr(key string, delta int64) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var (
+		n   int64
+		ttl time.Duration
+	)
+	data, expire, err := s.get(key)
+	switch err {
+	case nil:
+		v, err := decodeValue(data)
+		if err != nil {
+			return 0, err
+		}
+		if n, err = toInt64(v); err != nil {
+			return 0, err
+		}
+		ttl = remainTTL(expire)
+	case ErrNotFound:
+	default:
+		return 0, err
+	}
+	n += delta
+	err = s.store.Put([]byte(key), withExpire([]byte(strconv.FormatInt(n, 10)), ttl), nil)
+	return n, err
+}
+
+func (s *LevelDB) Decr(key string, delta int64) (int64, error) {
+	return s.Incr(key, -delta)
+}
+
 func (s *LevelDB) Del(key string) error {
 	err := s.store.Delete([]byte(key), nil)
 	if err != nil {
@@ -97,6 +201,22 @@ func (s *LevelDB) Del(key string) error {
 	return err
 }
 
+// 删除数据库中的所有数据
+func (s *LevelDB) Clear() error {
+	iter := s.store.NewIterator(nil, nil)
+	batch := new(leveldb.Batch)
+	for iter.Next() {
+		key := make([]byte, len(iter.Key()))
+		copy(key, iter.Key())
+		batch.Delete(key)
+	}
+	iter.Release()
+	if err := iter.Error(); err != nil {
+		return err
+	}
+	return s.store.Write(batch, nil)
+}
+
 func (s *LevelDB) Close() error {
 	return s.store.Close()
 }
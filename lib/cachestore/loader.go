@@ -0,0 +1,252 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+func init() {
+	gob.Register(StaleEntry{})
+}
+
+// ErrLockTimeout 等待其它节点计算缓存超时
+var ErrLockTimeout = errors.New(`cachestore: wait for lock timeout`)
+
+// Locker 是分布式锁，用于在多个节点之间避免重复计算同一个缓存
+type Locker interface {
+	//获取锁，ok为false表示锁已被其它节点持有。token用于释放锁
+	Lock(key string, ttl time.Duration) (token string, ok bool, err error)
+	Unlock(key string, token string) error
+}
+
+// StaleEntry 是开启stale-while-revalidate时保存的缓存数据
+type StaleEntry struct {
+	Value  interface{}
+	Expire int64 //UnixNano，超过此时间后数据过期，但在Loader.Stale时间内仍可返回并在后台刷新
+}
+
+// Loader 提供GetOrSet：缓存不存在时调用loader计算并保存，
+// 同一进程内对同一个键的并发计算会被合并为一次。
+// 合并依赖Loader中记录的计算，同一个Cache应共用一个Loader(通常由NewLoader创建并长期保存)
+type Loader struct {
+	Cache Cache
+
+	//过期后仍可返回旧数据的时间，期间在后台重新计算。为0时不返回旧数据
+	Stale time.Duration
+
+	//分布式锁(例如*Redis)，为nil时只在进程内合并计算
+	Lock        Locker
+	LockTTL     time.Duration //锁的有效期，默认为10秒
+	LockWait    time.Duration //未获得锁时等待其它节点计算完成的最长时间，默认为LockTTL
+	LockRetry   time.Duration //等待时检查缓存的间隔，默认为50毫秒
+	LockTimeout bool          //为true时等待超时返回ErrLockTimeout，否则自行计算
+
+	Debug  bool
+	flight flightGroup
+}
+
+// NewLoader 创建Loader。如果c实现了Locker(例如*Redis)，则默认使用它作为分布式锁
+func NewLoader(c Cache) *Loader {
+	l := &Loader{Cache: c}
+	if locker, ok := c.(Locker); ok {
+		l.Lock = locker
+	}
+	return l
+}
+
+// GetOrSet 获取缓存，不存在(或已过期)时调用loader计算并以有效期ttl保存
+func (l *Loader) GetOrSet(key string, ttl time.Duration, loader func() (interface{}, error)) (interface{}, error) {
+	v, stale, ok := l.get(key)
+	if ok {
+		if stale {
+			//使用不同于do的键，以免等待缓存的请求得到后台刷新的结果(未获得锁时为nil)
+			l.flight.start(key+"\x00refresh", func() (interface{}, error) {
+				return l.load(key, ttl, loader, false)
+			}, func(err error) {
+				if l.Debug {
+					log.Println("[Loader]RefreshErr: ", err, "Key:", key)
+				}
+			})
+		}
+		return v, nil
+	}
+	return l.flight.do(key, func() (interface{}, error) {
+		return l.load(key, ttl, loader, true)
+	})
+}
+
+// 获取缓存，stale为true表示数据已过期但仍在Stale时间内
+func (l *Loader) get(key string) (v interface{}, stale bool, ok bool) {
+	v, err := l.Cache.Get(key)
+	if err != nil {
+		return nil, false, false
+	}
+	entry, isEntry := v.(StaleEntry)
+	if !isEntry {
+		return v, false, true
+	}
+	if entry.Expire > 0 && entry.Expire <= time.Now().UnixNano() {
+		return entry.Value, true, true
+	}
+	return entry.Value, false, true
+}
+
+// 计算并保存缓存。check为true时在获得锁后再次检查缓存，以免重复计算
+func (l *Loader) load(key string, ttl time.Duration, loader func() (interface{}, error), check bool) (interface{}, error) {
+	if l.Lock != nil {
+		lockTTL := l.LockTTL
+		if lockTTL <= 0 {
+			lockTTL = 10 * time.Second
+		}
+		lockKey := key + `.lock`
+		token, ok, err := l.Lock.Lock(lockKey, lockTTL)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			if !check {
+				//后台刷新时其它节点正在计算，无需等待
+				return nil, nil
+			}
+			return l.wait(key, ttl, loader, lockTTL)
+		}
+		defer l.Lock.Unlock(lockKey, token)
+		if check {
+			if v, stale, ok := l.get(key); ok && !stale {
+				return v, nil
+			}
+		}
+	}
+	v, err := loader()
+	if err != nil {
+		return nil, err
+	}
+	return v, l.set(key, v, ttl)
+}
+
+// 等待持有锁的节点计算完成
+func (l *Loader) wait(key string, ttl time.Duration, loader func() (interface{}, error), lockTTL time.Duration) (interface{}, error) {
+	wait := l.LockWait
+	if wait <= 0 {
+		wait = lockTTL
+	}
+	retry := l.LockRetry
+	if retry <= 0 {
+		retry = 50 * time.Millisecond
+	}
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		time.Sleep(retry)
+		if v, stale, ok := l.get(key); ok && !stale {
+			return v, nil
+		}
+	}
+	if l.LockTimeout {
+		return nil, ErrLockTimeout
+	}
+	v, err := loader()
+	if err != nil {
+		return nil, err
+	}
+	return v, l.set(key, v, ttl)
+}
+
+func (l *Loader) set(key string, v interface{}, ttl time.Duration) error {
+	if l.Stale <= 0 {
+		return l.Cache.Set(key, v, ttl)
+	}
+	entry := StaleEntry{Value: v}
+	if ttl > 0 {
+		entry.Expire = time.Now().Add(ttl).UnixNano()
+		ttl += l.Stale
+	}
+	return l.Cache.Set(key, entry, ttl)
+}
+
+// flightCall 是正在进行的计算
+type flightCall struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// flightGroup 合并对同一个键的并发计算
+type flightGroup struct {
+	calls map[string]*flightCall
+	mutex sync.Mutex
+}
+
+func (g *flightGroup) begin(key string) (c *flightCall, running bool) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if c, ok := g.calls[key]; ok {
+		return c, true
+	}
+	c = &flightCall{}
+	c.wg.Add(1)
+	g.calls[key] = c
+	return c, false
+}
+
+// 执行fn并通知等待的调用者。fn中的panic会被转换为错误，以免等待者得到(nil, nil)
+func (g *flightGroup) run(key string, c *flightCall, fn func() (interface{}, error)) {
+	defer func() {
+		if e := recover(); e != nil {
+			c.val, c.err = nil, fmt.Errorf(`cachestore: loader panic: %v`, e)
+		}
+		c.wg.Done()
+		g.mutex.Lock()
+		delete(g.calls, key)
+		g.mutex.Unlock()
+	}()
+	c.val, c.err = fn()
+}
+
+// 执行fn，对同一个键正在进行的计算会等待其结果
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	c, running := g.begin(key)
+	if !running {
+		g.run(key, c, fn)
+	} else {
+		c.wg.Wait()
+	}
+	return c.val, c.err
+}
+
+// 在后台执行fn，对同一个键已有正在进行的计算时忽略。fn返回错误时调用onError
+func (g *flightGroup) start(key string, fn func() (interface{}, error), onError func(error)) {
+	c, running := g.begin(key)
+	if running {
+		return
+	}
+	go func() {
+		g.run(key, c, fn)
+		if c.err != nil && onError != nil {
+			onError(c.err)
+		}
+	}()
+}
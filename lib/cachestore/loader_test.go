@@ -0,0 +1,162 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGetOrSet(t *testing.T) {
+	l := NewLoader(NewMemory(nil))
+	var calls int32
+	loader := func() (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return `value`, nil
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := l.GetOrSet(`k`, time.Minute, loader)
+			if err != nil || v != `value` {
+				t.Errorf(`GetOrSet = %#v, %v`, v, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf(`loader was called %d times, want 1`, calls)
+	}
+	if v, err := l.Cache.Get(`k`); err != nil || v != `value` {
+		t.Errorf(`cached value = %#v, %v`, v, err)
+	}
+}
+
+func TestGetOrSetPanic(t *testing.T) {
+	l := NewLoader(NewMemory(nil))
+	started := make(chan struct{})
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 1 {
+				<-started
+			}
+			_, errs[i] = l.GetOrSet(`k`, time.Minute, func() (interface{}, error) {
+				close(started)
+				time.Sleep(20 * time.Millisecond)
+				panic(`boom`)
+			})
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf(`caller %d got no error from a panicking loader`, i)
+		}
+	}
+}
+
+func TestGetOrSetStale(t *testing.T) {
+	l := NewLoader(NewMemory(nil))
+	l.Stale = time.Minute
+	l.GetOrSet(`k`, 10*time.Millisecond, func() (interface{}, error) {
+		return 1, nil
+	})
+	time.Sleep(20 * time.Millisecond)
+	refreshed := make(chan struct{})
+	v, err := l.GetOrSet(`k`, time.Minute, func() (interface{}, error) {
+		defer close(refreshed)
+		return 2, nil
+	})
+	if err != nil || v != 1 {
+		t.Errorf(`stale GetOrSet = %#v, %v; want 1`, v, err)
+	}
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatal(`stale value was not refreshed`)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if v, _, _ := l.get(`k`); v != 2 {
+		t.Errorf(`refreshed value = %#v, want 2`, v)
+	}
+}
+
+// 在内存中模拟分布式锁的缓存
+type lockingMemory struct {
+	*Memory
+	locks map[string]string
+	mutex sync.Mutex
+}
+
+func (m *lockingMemory) Lock(key string, ttl time.Duration) (string, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if _, ok := m.locks[key]; ok {
+		return ``, false, nil
+	}
+	m.locks[key] = key
+	return key, true, nil
+}
+
+func (m *lockingMemory) Unlock(key string, token string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.locks[key] == token {
+		delete(m.locks, key)
+	}
+	return nil
+}
+
+func TestGetOrSetLock(t *testing.T) {
+	c := &lockingMemory{Memory: NewMemory(nil), locks: make(map[string]string)}
+	l := NewLoader(c)
+	if l.Lock == nil {
+		t.Fatal(`cache implementing Locker is not used as the lock`)
+	}
+	l.LockRetry = 5 * time.Millisecond
+	//其它节点持有锁时等待其计算结果
+	token, _, _ := c.Lock(`k.lock`, time.Second)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		time.Sleep(20 * time.Millisecond)
+		c.Set(`k`, `remote`, time.Minute)
+		c.Unlock(`k.lock`, token)
+	}()
+	v, err := l.GetOrSet(`k`, time.Minute, func() (interface{}, error) {
+		return `local`, nil
+	})
+	if err != nil || v != `remote` {
+		t.Errorf(`GetOrSet = %#v, %v; want remote`, v, err)
+	}
+	<-done
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.locks) != 0 {
+		t.Errorf(`locks are not released: %v`, c.locks)
+	}
+}
@@ -19,10 +19,15 @@ package cachestore
 
 import (
 	"log"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
 )
 
+var _ Cache = &Memcache{}
+
 // Memcache adapter.
 type Memcache struct {
 	c        *memcache.Client
@@ -38,18 +43,36 @@ func NewMemcache(conn []string, lifeTime int32) *Memcache {
 	return rc
 }
 
+// 拆分以分号或逗号分隔的多个服务器地址
+func splitConn(conn string) []string {
+	return strings.FieldsFunc(conn, func(r rune) bool {
+		return r == ';' || r == ','
+	})
+}
+
+// 将ttl转换为memcache的过期时间：超过30天时须使用unix时间戳
+func memcacheExpiration(ttl time.Duration) int32 {
+	seconds := ttlSeconds(ttl)
+	if seconds > 30*24*3600 {
+		return int32(time.Now().Unix() + seconds)
+	}
+	return int32(seconds)
+}
+
 // get value from memcache.
 func (rc *Memcache) Get(key string) (interface{}, error) {
 	val, err := rc.c.Get(Md5(key))
 	if err != nil || val == nil {
-		if err != nil && rc.Debug {
+		if err == memcache.ErrCacheMiss || err == nil {
+			return nil, ErrNotFound
+		}
+		if rc.Debug {
 			log.Println("[Memcache]GetErr: ", err, "Key:", key)
 		}
 		return nil, err
 	}
 
-	var v interface{}
-	err = Decode(val.Value, &v)
+	v, err := decodeValue(val.Value)
 	if err != nil {
 		if rc.Debug {
 			log.Println("[Memcache]DecodeErr: ", err, "Key:", key)
@@ -63,16 +86,47 @@ func (rc *Memcache) Get(key string) (interface{}, error) {
 	return v, err
 }
 
-// put value to memcache. only support string.
+// get values from memcache.
+func (rc *Memcache) GetMulti(keys []string) (map[string]interface{}, error) {
+	hashed := make(map[string]string, len(keys))
+	hashes := make([]string, len(keys))
+	for i, key := range keys {
+		hashes[i] = Md5(key)
+		hashed[hashes[i]] = key
+	}
+	items, err := rc.c.GetMulti(hashes)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]interface{}, len(items))
+	for hash, item := range items {
+		v, err := decodeValue(item.Value)
+		if err != nil {
+			if rc.Debug {
+				log.Println("[Memcache]DecodeErr: ", err, "Key:", hashed[hash])
+			}
+			continue
+		}
+		values[hashed[hash]] = v
+	}
+	return values, nil
+}
+
+// put value to memcache with the default lifetime.
 func (rc *Memcache) Put(key string, value interface{}) error {
-	val, err := Encode(value)
+	return rc.Set(key, value, lifeTimeDuration(rc.LifeTime))
+}
+
+// set value to memcache.
+func (rc *Memcache) Set(key string, value interface{}, ttl time.Duration) error {
+	val, err := encodeValue(value)
 	if err != nil {
 		if rc.Debug {
 			log.Println("[Memcache]EncodeErr: ", err, "Key:", key)
 		}
 		return err
 	}
-	item := &memcache.Item{Key: Md5(key), Value: val, Expiration: rc.LifeTime}
+	item := &memcache.Item{Key: Md5(key), Value: val, Expiration: memcacheExpiration(ttl)}
 	err = rc.c.Set(item)
 	if err != nil {
 		if rc.Debug {
@@ -86,10 +140,23 @@ func (rc *Memcache) Put(key string, value interface{}) error {
 	return err
 }
 
+// set values to memcache.
+func (rc *Memcache) SetMulti(data map[string]interface{}, ttl time.Duration) error {
+	for key, value := range data {
+		if err := rc.Set(key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // delete value in memcache.
 func (rc *Memcache) Del(key string) error {
 	err := rc.c.Delete(Md5(key))
 	if err != nil {
+		if err == memcache.ErrCacheMiss {
+			return nil
+		}
 		if rc.Debug {
 			log.Println("[Memcache]DelErr: ", err, "Key:", key)
 		}
@@ -101,30 +168,66 @@ func (rc *Memcache) Del(key string) error {
 	return err
 }
 
-// [Not Support]
 // increase counter.
-func (rc *Memcache) Incr(key string, delta uint64) error {
-	_, err := rc.c.Increment(key, delta)
-	return err
+// memcache中的计数器不能小于0，递减到小于0时结果为0
+func (rc *Memcache) Incr(key string, delta int64) (int64, error) {
+	hash := Md5(key)
+	var (
+		n   uint64
+		err error
+	)
+	if delta >= 0 {
+		n, err = rc.c.Increment(hash, uint64(delta))
+	} else {
+		n, err = rc.c.Decrement(hash, uint64(-delta))
+	}
+	if err != memcache.ErrCacheMiss {
+		return int64(n), err
+	}
+	var initial int64
+	if delta > 0 {
+		initial = delta
+	}
+	item := &memcache.Item{Key: hash, Value: []byte(strconv.FormatInt(initial, 10))}
+	err = rc.c.Add(item)
+	if err == memcache.ErrNotStored {
+		//被其它客户端抢先创建
+		return rc.Incr(key, delta)
+	}
+	return initial, err
 }
 
-// [Not Support]
 // decrease counter.
-func (rc *Memcache) Decr(key string, delta uint64) error {
-	_, err := rc.c.Decrement(key, delta)
-	return err
+func (rc *Memcache) Decr(key string, delta int64) (int64, error) {
+	return rc.Incr(key, -delta)
 }
 
 // check value exists in memcache.
-func (rc *Memcache) IsExist(key string) bool {
-	v, err := rc.c.Get(key)
-	if err != nil || v == nil {
-		return false
+func (rc *Memcache) Has(key string) (bool, error) {
+	_, err := rc.c.Get(Md5(key))
+	if err == memcache.ErrCacheMiss {
+		return false, nil
 	}
-	return true
+	return err == nil, err
+}
+
+// check value exists in memcache.
+func (rc *Memcache) IsExist(key string) bool {
+	ok, _ := rc.Has(key)
+	return ok
 }
 
 // clear all cached in memcache.
-func (rc *Memcache) ClearAll() error {
+func (rc *Memcache) Clear() error {
 	return rc.c.FlushAll()
 }
+
+// clear all cached in memcache.
+func (rc *Memcache) ClearAll() error {
+	return rc.Clear()
+}
+
+// memcache client has no connection to close.
+func (rc *Memcache) Close() error {
+	return nil
+}
@@ -0,0 +1,404 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"container/list"
+	"errors"
+	"hash/fnv"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var _ Cache = &Memory{}
+
+// ErrTooLarge 表示数据大于内存缓存每个分片能占用的字节数(MaxBytes/Shards)，不能保存
+var ErrTooLarge = errors.New(`cachestore: value is too large`)
+
+// MemoryOptions 是内存缓存的配置
+type MemoryOptions struct {
+	Shards        int           //分片数量，默认为16
+	MaxEntries    int           //最多缓存条数，0表示不限制
+	MaxBytes      int64         //最多占用的字节数(按编码后的数据计算)，0表示不限制。大于MaxBytes/Shards的数据不能保存
+	LifeTime      int32         //Put使用的默认有效期(秒)，0表示永不过期
+	CleanInterval time.Duration //定时清理过期缓存的间隔，0表示只在访问时清理
+
+	//缓存因超出容量或过期而被移除时调用(Del和Clear不会调用)，
+	//在释放锁之后调用，因此可以在其中访问缓存
+	OnEvict func(key string, value interface{})
+}
+
+// MemoryStats 是内存缓存的统计信息
+type MemoryStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64 //因超出容量被移除的条数
+	Expired   uint64 //因过期被移除的条数
+	Entries   int
+	Bytes     int64
+}
+
+// Memory 是分片的LRU内存缓存，数据使用Encode编码后保存
+type Memory struct {
+	shards    []*memoryShard
+	LifeTime  int32
+	Debug     bool
+	onEvict   func(key string, value interface{})
+	hits      uint64
+	misses    uint64
+	evictions uint64
+	expired   uint64
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+type memoryEntry struct {
+	key    string
+	data   []byte
+	expire int64 //UnixNano，0表示永不过期
+}
+
+func (e *memoryEntry) size() int64 {
+	return int64(len(e.key) + len(e.data))
+}
+
+func (e *memoryEntry) isExpired(now int64) bool {
+	return e.expire > 0 && e.expire <= now
+}
+
+type memoryShard struct {
+	items      map[string]*list.Element
+	lru        *list.List //最近使用的在前
+	bytes      int64
+	maxEntries int
+	maxBytes   int64
+	mutex      sync.Mutex
+}
+
+// NewMemory 创建内存缓存，opts为nil时使用默认配置
+func NewMemory(opts *MemoryOptions) *Memory {
+	if opts == nil {
+		opts = &MemoryOptions{}
+	}
+	n := opts.Shards
+	if n <= 0 {
+		n = 16
+	}
+	m := &Memory{
+		shards:   make([]*memoryShard, n),
+		LifeTime: opts.LifeTime,
+		onEvict:  opts.OnEvict,
+	}
+	for i := range m.shards {
+		s := &memoryShard{
+			items: make(map[string]*list.Element),
+			lru:   list.New(),
+		}
+		if opts.MaxEntries > 0 {
+			s.maxEntries = (opts.MaxEntries + n - 1) / n
+		}
+		if opts.MaxBytes > 0 {
+			s.maxBytes = (opts.MaxBytes + int64(n) - 1) / int64(n)
+		}
+		m.shards[i] = s
+	}
+	if opts.CleanInterval > 0 {
+		m.stop = make(chan struct{})
+		go m.janitor(opts.CleanInterval)
+	}
+	return m
+}
+
+func (m *Memory) shard(key string) *memoryShard {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return m.shards[h.Sum32()%uint32(len(m.shards))]
+}
+
+// 移除元素，须在持有锁时调用
+func (s *memoryShard) remove(el *list.Element) *memoryEntry {
+	e := s.lru.Remove(el).(*memoryEntry)
+	delete(s.items, e.key)
+	s.bytes -= e.size()
+	return e
+}
+
+// 获取未过期的数据，过期的数据会被移除并通过expired返回
+func (s *memoryShard) get(key string, now int64) (e *memoryEntry, expired *memoryEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	e = el.Value.(*memoryEntry)
+	if e.isExpired(now) {
+		return nil, s.remove(el)
+	}
+	s.lru.MoveToFront(el)
+	return e, nil
+}
+
+// 保存数据，返回因超出容量被移除的数据。setLocked须在持有锁时调用
+func (s *memoryShard) set(e *memoryEntry) []*memoryEntry {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.setLocked(e)
+}
+
+func (s *memoryShard) setLocked(e *memoryEntry) (evicted []*memoryEntry) {
+	if el, ok := s.items[e.key]; ok {
+		s.remove(el)
+	}
+	s.items[e.key] = s.lru.PushFront(e)
+	s.bytes += e.size()
+	for s.lru.Len() > 0 && ((s.maxEntries > 0 && s.lru.Len() > s.maxEntries) || (s.maxBytes > 0 && s.bytes > s.maxBytes)) {
+		evicted = append(evicted, s.remove(s.lru.Back()))
+	}
+	return
+}
+
+func (s *memoryShard) del(key string) {
+	s.mutex.Lock()
+	if el, ok := s.items[key]; ok {
+		s.remove(el)
+	}
+	s.mutex.Unlock()
+}
+
+// 移除所有过期的数据
+func (s *memoryShard) removeExpired(now int64) (expired []*memoryEntry) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for el := s.lru.Back(); el != nil; {
+		prev := el.Prev()
+		if el.Value.(*memoryEntry).isExpired(now) {
+			expired = append(expired, s.remove(el))
+		}
+		el = prev
+	}
+	return
+}
+
+// 调用OnEvict
+func (m *Memory) evict(entries []*memoryEntry, expired bool) {
+	if len(entries) == 0 {
+		return
+	}
+	if expired {
+		atomic.AddUint64(&m.expired, uint64(len(entries)))
+	} else {
+		atomic.AddUint64(&m.evictions, uint64(len(entries)))
+	}
+	if m.onEvict == nil {
+		return
+	}
+	for _, e := range entries {
+		v, err := decodeValue(e.data)
+		if err != nil {
+			if m.Debug {
+				log.Println("[Memory]DecodeErr: ", err, "Key:", e.key)
+			}
+			continue
+		}
+		m.onEvict(e.key, v)
+	}
+}
+
+func (m *Memory) getData(key string) ([]byte, bool) {
+	e, expired := m.shard(key).get(key, time.Now().UnixNano())
+	if expired != nil {
+		m.evict([]*memoryEntry{expired}, true)
+	}
+	if e == nil {
+		atomic.AddUint64(&m.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&m.hits, 1)
+	return e.data, true
+}
+
+func (m *Memory) setData(key string, data []byte, ttl time.Duration) error {
+	e := &memoryEntry{key: key, data: data}
+	if ttl > 0 {
+		e.expire = time.Now().Add(ttl).UnixNano()
+	}
+	s := m.shard(key)
+	if s.maxBytes > 0 && e.size() > s.maxBytes {
+		//同时删除旧数据，以免之后读到过期的内容
+		s.del(key)
+		return ErrTooLarge
+	}
+	m.evict(s.set(e), false)
+	return nil
+}
+
+func (m *Memory) Get(key string) (interface{}, error) {
+	data, ok := m.getData(key)
+	if !ok {
+		return nil, ErrNotFound
+	}
+	v, err := decodeValue(data)
+	if err != nil {
+		if m.Debug {
+			log.Println("[Memory]DecodeErr: ", err, "Key:", key)
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+func (m *Memory) GetMulti(keys []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(keys))
+	for _, key := range keys {
+		v, err := m.Get(key)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return values, err
+		}
+		values[key] = v
+	}
+	return values, nil
+}
+
+func (m *Memory) Put(key string, value interface{}) error {
+	return m.Set(key, value, lifeTimeDuration(m.LifeTime))
+}
+
+func (m *Memory) Set(key string, value interface{}, ttl time.Duration) error {
+	data, err := encodeValue(value)
+	if err != nil {
+		if m.Debug {
+			log.Println("[Memory]EncodeErr: ", err, "Key:", key)
+		}
+		return err
+	}
+	return m.setData(key, data, ttl)
+}
+
+func (m *Memory) SetMulti(data map[string]interface{}, ttl time.Duration) error {
+	for key, value := range data {
+		if err := m.Set(key, value, ttl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Del(key string) error {
+	m.shard(key).del(key)
+	return nil
+}
+
+func (m *Memory) Has(key string) (bool, error) {
+	e, expired := m.shard(key).get(key, time.Now().UnixNano())
+	if expired != nil {
+		m.evict([]*memoryEntry{expired}, true)
+	}
+	return e != nil, nil
+}
+
+func (m *Memory) Incr(key string, delta int64) (int64, error) {
+	s := m.shard(key)
+	s.mutex.Lock()
+	var n, expire int64
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*memoryEntry)
+		if !e.isExpired(time.Now().UnixNano()) {
+			v, err := decodeValue(e.data)
+			if err == nil {
+				n, err = toInt64(v)
+			}
+			if err != nil {
+				s.mutex.Unlock()
+				return 0, err
+			}
+			expire = e.expire
+		}
+	}
+	n += delta
+	evicted := s.setLocked(&memoryEntry{key: key, data: []byte(strconv.FormatInt(n, 10)), expire: expire})
+	s.mutex.Unlock()
+	m.evict(evicted, false)
+	return n, nil
+}
+
+func (m *Memory) Decr(key string, delta int64) (int64, error) {
+	return m.Incr(key, -delta)
+}
+
+// Clear 清空缓存，统计信息保持不变
+func (m *Memory) Clear() error {
+	for _, s := range m.shards {
+		s.mutex.Lock()
+		s.items = make(map[string]*list.Element)
+		s.lru.Init()
+		s.bytes = 0
+		s.mutex.Unlock()
+	}
+	return nil
+}
+
+// Close 停止定时清理
+func (m *Memory) Close() error {
+	m.closeOnce.Do(func() {
+		if m.stop != nil {
+			close(m.stop)
+		}
+	})
+	return nil
+}
+
+// Stats 返回统计信息
+func (m *Memory) Stats() MemoryStats {
+	st := MemoryStats{
+		Hits:      atomic.LoadUint64(&m.hits),
+		Misses:    atomic.LoadUint64(&m.misses),
+		Evictions: atomic.LoadUint64(&m.evictions),
+		Expired:   atomic.LoadUint64(&m.expired),
+	}
+	for _, s := range m.shards {
+		s.mutex.Lock()
+		st.Entries += s.lru.Len()
+		st.Bytes += s.bytes
+		s.mutex.Unlock()
+	}
+	return st
+}
+
+// 定时清理过期缓存
+func (m *Memory) janitor(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			now := time.Now().UnixNano()
+			for _, s := range m.shards {
+				m.evict(s.removeExpired(now), true)
+			}
+		case <-m.stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,136 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemory(t *testing.T) {
+	m := NewMemory(nil)
+	defer m.Close()
+	if _, err := m.Get(`a`); err != ErrNotFound {
+		t.Errorf(`Get(missing) error = %v`, err)
+	}
+	if err := m.Set(`a`, `123`, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := m.Get(`a`); err != nil || v != `123` {
+		t.Errorf(`Get(a) = %#v, %v`, v, err)
+	}
+	if err := m.SetMulti(map[string]interface{}{`b`: 1, `c`: true}, 0); err != nil {
+		t.Fatal(err)
+	}
+	values, err := m.GetMulti([]string{`a`, `b`, `c`, `d`})
+	if err != nil || len(values) != 3 {
+		t.Errorf(`GetMulti = %v, %v`, values, err)
+	}
+	m.Del(`a`)
+	if ok, _ := m.Has(`a`); ok {
+		t.Error(`a should be deleted`)
+	}
+	if n, err := m.Incr(`n`, 5); err != nil || n != 5 {
+		t.Errorf(`Incr = %d, %v`, n, err)
+	}
+	if n, err := m.Decr(`n`, 2); err != nil || n != 3 {
+		t.Errorf(`Decr = %d, %v`, n, err)
+	}
+	if v, err := m.Get(`n`); err != nil || v != int64(3) {
+		t.Errorf(`Get(n) = %#v, %v`, v, err)
+	}
+	if _, err := m.Incr(`c`, 1); err == nil {
+		t.Error(`Incr on a non-counter should fail`)
+	}
+	m.Clear()
+	if st := m.Stats(); st.Entries != 0 || st.Bytes != 0 {
+		t.Errorf(`Stats after Clear = %+v`, st)
+	}
+}
+
+func TestMemoryExpire(t *testing.T) {
+	m := NewMemory(nil)
+	m.Set(`a`, 1, 10*time.Millisecond)
+	if ok, _ := m.Has(`a`); !ok {
+		t.Fatal(`a should exist`)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if _, err := m.Get(`a`); err != ErrNotFound {
+		t.Errorf(`Get(expired) error = %v`, err)
+	}
+	if st := m.Stats(); st.Expired != 1 {
+		t.Errorf(`Expired = %d, want 1`, st.Expired)
+	}
+}
+
+func TestMemoryEvict(t *testing.T) {
+	var evicted []string
+	m := NewMemory(&MemoryOptions{
+		Shards:     1,
+		MaxEntries: 2,
+		OnEvict: func(key string, value interface{}) {
+			evicted = append(evicted, key)
+		},
+	})
+	m.Set(`a`, 1, 0)
+	m.Set(`b`, 2, 0)
+	m.Get(`a`) //a成为最近使用的
+	m.Set(`c`, 3, 0)
+	if len(evicted) != 1 || evicted[0] != `b` {
+		t.Errorf(`evicted = %v, want [b]`, evicted)
+	}
+	for key, want := range map[string]bool{`a`: true, `b`: false, `c`: true} {
+		if ok, _ := m.Has(key); ok != want {
+			t.Errorf(`Has(%v) = %v, want %v`, key, ok, want)
+		}
+	}
+	if st := m.Stats(); st.Entries != 2 || st.Evictions != 1 {
+		t.Errorf(`Stats = %+v`, st)
+	}
+}
+
+func TestMemoryTooLarge(t *testing.T) {
+	m := NewMemory(&MemoryOptions{Shards: 1, MaxBytes: 64})
+	m.Set(`a`, `small`, 0)
+	if err := m.Set(`a`, strings.Repeat(`large`, 20), 0); err != ErrTooLarge {
+		t.Errorf(`Set = %v, want ErrTooLarge`, err)
+	}
+	if ok, _ := m.Has(`a`); ok {
+		t.Error(`old value is kept after a rejected Set`)
+	}
+	if st := m.Stats(); st.Entries != 0 || st.Bytes != 0 {
+		t.Errorf(`Stats = %+v`, st)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	c, err := Create(`memory`, `{"maxentries":"10","lifetime":"60"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := c.(*Memory); !ok || m.LifeTime != 60 {
+		t.Errorf(`Create(memory) = %#v`, c)
+	}
+	if _, err = Create(`none`, ``); err == nil {
+		t.Error(`Create with an unknown adapter should fail`)
+	}
+	if _, err = Create(`memory`, `{`); err == nil {
+		t.Error(`Create with an invalid config should fail`)
+	}
+}
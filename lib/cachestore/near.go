@@ -0,0 +1,364 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webx-top/webx/lib/cachestore/redigo/redis"
+)
+
+var (
+	_ Cache  = &NearCache{}
+	_ Locker = &NearCache{}
+
+	// 默认的失效通知频道
+	DefaultNearChannel = `WebxNearCache`
+
+	// 默认的本机缓存最长有效期
+	DefaultNearLocalTTL = time.Minute
+)
+
+// 失效通知的操作类型
+const (
+	nearOpDel   = `del`
+	nearOpClear = `clear`
+)
+
+// NearCache 是两级缓存：本机内存缓存(Local)在前，Redis(Remote)在后。
+// 修改缓存时通过Redis频道通知其它节点删除本机缓存中对应的数据。
+// 本机缓存的有效期不超过Remote中的剩余有效期和LocalTTL
+type NearCache struct {
+	Local    *Memory
+	Remote   *Redis
+	LocalTTL time.Duration //本机缓存的最长有效期。为0时从Remote读取的数据会一直保存到收到失效通知或在Remote中过期
+	Debug    bool
+	channel  string
+	node     string //本节点ID，用于忽略自己发出的通知
+	conn     redis.Conn
+	closed   bool
+	mutex    sync.Mutex
+	stop     chan struct{}
+	done     chan struct{}
+	gen      uint64     //本机缓存的失效次数，从Remote读取期间有失效时不写入本机缓存
+	genMutex sync.Mutex //保护gen及与其相关的本机缓存写入
+}
+
+// NewNearCache 创建两级缓存并订阅失效通知频道channel(为空时使用DefaultNearChannel)。
+// localTTL为本机缓存的最长有效期，小于等于0时使用DefaultNearLocalTTL
+func NewNearCache(remote *Redis, local *Memory, channel string, localTTL time.Duration) *NearCache {
+	if channel == `` {
+		channel = DefaultNearChannel
+	}
+	if localTTL <= 0 {
+		localTTL = DefaultNearLocalTTL
+	}
+	if local == nil {
+		local = NewMemory(nil)
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	n := &NearCache{
+		Local:    local,
+		Remote:   remote,
+		LocalTTL: localTTL,
+		channel:  channel,
+		node:     hex.EncodeToString(b),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if remote.p == nil {
+		remote.connectInit()
+	}
+	go n.subscribe()
+	return n
+}
+
+// 订阅失效通知。连接断开后自动重连，重连前清空本机缓存以免错过通知导致数据不一致
+func (n *NearCache) subscribe() {
+	defer close(n.done)
+	wait := time.Second
+	for {
+		c, err := n.Remote.p.Dial()
+		if err == nil {
+			n.mutex.Lock()
+			if n.closed {
+				n.mutex.Unlock()
+				c.Close()
+				return
+			}
+			n.conn = c
+			n.mutex.Unlock()
+			err = n.receive(redis.PubSubConn{Conn: c})
+			wait = time.Second
+		}
+		n.mutex.Lock()
+		closed := n.closed
+		n.conn = nil
+		n.mutex.Unlock()
+		if closed {
+			return
+		}
+		if n.Debug {
+			log.Println("[NearCache]SubscribeErr: ", err)
+		}
+		n.invalidate(func() {
+			n.Local.Clear()
+		})
+		select {
+		case <-time.After(wait):
+		case <-n.stop:
+			return
+		}
+		if wait < time.Minute {
+			wait *= 2
+		}
+	}
+}
+
+func (n *NearCache) receive(psc redis.PubSubConn) error {
+	defer psc.Close()
+	if err := psc.Subscribe(n.channel); err != nil {
+		return err
+	}
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			n.handle(string(v.Data))
+		case error:
+			return v
+		}
+	}
+}
+
+// 处理失效通知，格式为：节点ID|操作|键名
+func (n *NearCache) handle(msg string) {
+	parts := strings.SplitN(msg, `|`, 3)
+	if len(parts) != 3 || parts[0] == n.node {
+		return
+	}
+	if n.Debug {
+		log.Println("[NearCache]Invalidate: ", parts[1], parts[2])
+	}
+	switch parts[1] {
+	case nearOpDel:
+		n.invalidate(func() {
+			n.Local.Del(parts[2])
+		})
+	case nearOpClear:
+		n.invalidate(func() {
+			n.Local.Clear()
+		})
+	}
+}
+
+// 修改本机缓存并增加失效次数，使此前开始的从Remote读取不再写入本机缓存
+func (n *NearCache) invalidate(fn func()) {
+	n.genMutex.Lock()
+	n.gen++
+	fn()
+	n.genMutex.Unlock()
+}
+
+// 当前的失效次数
+func (n *NearCache) generation() uint64 {
+	n.genMutex.Lock()
+	defer n.genMutex.Unlock()
+	return n.gen
+}
+
+// 将从Remote读取的数据写入本机缓存。读取期间(gen之后)有失效时数据可能已过时，不写入
+func (n *NearCache) fill(gen uint64, values map[string]interface{}, ttls map[string]time.Duration) {
+	n.genMutex.Lock()
+	defer n.genMutex.Unlock()
+	if n.gen != gen {
+		return
+	}
+	for key, v := range values {
+		n.Local.Set(key, v, n.localTTL(ttls[key]))
+	}
+}
+
+// 通知其它节点
+func (n *NearCache) publish(op string, keys ...string) error {
+	if len(keys) == 0 {
+		keys = []string{``}
+	}
+	c := n.Remote.p.Get()
+	defer c.Close()
+	for _, key := range keys {
+		if err := c.Send("PUBLISH", n.channel, n.node+`|`+op+`|`+key); err != nil {
+			return err
+		}
+	}
+	_, err := c.Do("")
+	return err
+}
+
+func (n *NearCache) localTTL(ttl time.Duration) time.Duration {
+	if n.LocalTTL > 0 && (ttl <= 0 || ttl > n.LocalTTL) {
+		return n.LocalTTL
+	}
+	return ttl
+}
+
+func (n *NearCache) Get(key string) (interface{}, error) {
+	if v, err := n.Local.Get(key); err == nil {
+		return v, nil
+	}
+	gen := n.generation()
+	values, ttls, err := n.Remote.getWithTTL([]string{key})
+	if err != nil {
+		return nil, err
+	}
+	v, ok := values[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	n.fill(gen, values, ttls)
+	return v, nil
+}
+
+func (n *NearCache) GetMulti(keys []string) (map[string]interface{}, error) {
+	values, err := n.Local.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, key := range keys {
+		if _, ok := values[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) == 0 {
+		return values, nil
+	}
+	gen := n.generation()
+	remote, ttls, err := n.Remote.getWithTTL(missing)
+	if err != nil {
+		return nil, err
+	}
+	for key, v := range remote {
+		values[key] = v
+	}
+	n.fill(gen, remote, ttls)
+	return values, nil
+}
+
+func (n *NearCache) Put(key string, value interface{}) error {
+	return n.Set(key, value, lifeTimeDuration(n.Remote.LifeTime))
+}
+
+func (n *NearCache) Set(key string, value interface{}, ttl time.Duration) error {
+	if err := n.Remote.Set(key, value, ttl); err != nil {
+		return err
+	}
+	n.invalidate(func() {
+		n.Local.Set(key, value, n.localTTL(ttl))
+	})
+	return n.publish(nearOpDel, key)
+}
+
+func (n *NearCache) SetMulti(data map[string]interface{}, ttl time.Duration) error {
+	if err := n.Remote.SetMulti(data, ttl); err != nil {
+		return err
+	}
+	n.invalidate(func() {
+		n.Local.SetMulti(data, n.localTTL(ttl))
+	})
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	return n.publish(nearOpDel, keys...)
+}
+
+func (n *NearCache) Del(key string) error {
+	if err := n.Remote.Del(key); err != nil {
+		return err
+	}
+	n.invalidate(func() {
+		n.Local.Del(key)
+	})
+	return n.publish(nearOpDel, key)
+}
+
+func (n *NearCache) Has(key string) (bool, error) {
+	if ok, _ := n.Local.Has(key); ok {
+		return true, nil
+	}
+	return n.Remote.Has(key)
+}
+
+// 计数器只保存在Remote中
+func (n *NearCache) Incr(key string, delta int64) (int64, error) {
+	v, err := n.Remote.Incr(key, delta)
+	if err != nil {
+		return v, err
+	}
+	n.invalidate(func() {
+		n.Local.Del(key)
+	})
+	return v, n.publish(nearOpDel, key)
+}
+
+func (n *NearCache) Decr(key string, delta int64) (int64, error) {
+	return n.Incr(key, -delta)
+}
+
+func (n *NearCache) Clear() error {
+	if err := n.Remote.Clear(); err != nil {
+		return err
+	}
+	n.invalidate(func() {
+		n.Local.Clear()
+	})
+	return n.publish(nearOpClear)
+}
+
+// Lock 使用Remote的分布式锁
+func (n *NearCache) Lock(key string, ttl time.Duration) (string, bool, error) {
+	return n.Remote.Lock(key, ttl)
+}
+
+func (n *NearCache) Unlock(key string, token string) error {
+	return n.Remote.Unlock(key, token)
+}
+
+// Close 停止订阅并关闭Local和Remote
+func (n *NearCache) Close() error {
+	n.mutex.Lock()
+	if n.closed {
+		n.mutex.Unlock()
+		return nil
+	}
+	n.closed = true
+	close(n.stop)
+	if n.conn != nil {
+		n.conn.Close()
+	}
+	n.mutex.Unlock()
+	<-n.done
+	n.Local.Close()
+	return n.Remote.Close()
+}
@@ -0,0 +1,85 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cachestore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNearCacheHandle(t *testing.T) {
+	n := &NearCache{Local: NewMemory(nil), node: `self`}
+	n.Local.Set(`a`, 1, 0)
+	n.Local.Set(`b`, 2, 0)
+	n.handle(`self|del|a`) //忽略自己发出的通知
+	if ok, _ := n.Local.Has(`a`); !ok {
+		t.Error(`own notification should be ignored`)
+	}
+	n.handle(`other|del|a`)
+	if ok, _ := n.Local.Has(`a`); ok {
+		t.Error(`a should be invalidated`)
+	}
+	n.handle(`invalid`)
+	if ok, _ := n.Local.Has(`b`); !ok {
+		t.Error(`invalid notification should be ignored`)
+	}
+	n.handle(`other|clear|`)
+	if ok, _ := n.Local.Has(`b`); ok {
+		t.Error(`local cache should be cleared`)
+	}
+}
+
+func TestNearCacheLocalTTL(t *testing.T) {
+	n := &NearCache{LocalTTL: time.Minute}
+	tests := map[time.Duration]time.Duration{
+		0:                time.Minute,
+		time.Second:      time.Second,
+		time.Hour:        time.Minute,
+		-1 * time.Second: time.Minute,
+	}
+	for ttl, want := range tests {
+		if got := n.localTTL(ttl); got != want {
+			t.Errorf(`localTTL(%v) = %v, want %v`, ttl, got, want)
+		}
+	}
+	n.LocalTTL = 0
+	if got := n.localTTL(time.Hour); got != time.Hour {
+		t.Errorf(`localTTL without LocalTTL = %v`, got)
+	}
+}
+
+func TestNearCacheFill(t *testing.T) {
+	n := &NearCache{Local: NewMemory(nil), LocalTTL: time.Minute, node: `self`}
+	//从Remote读取期间收到失效通知，读到的数据不写入本机缓存
+	gen := n.generation()
+	n.handle(`other|del|a`)
+	n.fill(gen, map[string]interface{}{`a`: 1}, nil)
+	if ok, _ := n.Local.Has(`a`); ok {
+		t.Error(`stale value should not be cached locally`)
+	}
+
+	//本机缓存的有效期不超过Remote中的剩余有效期
+	n.fill(n.generation(), map[string]interface{}{`a`: 1}, map[string]time.Duration{`a`: 10 * time.Millisecond})
+	if ok, _ := n.Local.Has(`a`); !ok {
+		t.Fatal(`a should be cached locally`)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if ok, _ := n.Local.Has(`a`); ok {
+		t.Error(`local value should expire with the remote one`)
+	}
+}
@@ -18,7 +18,8 @@
 package cachestore
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"log"
 	"strconv"
@@ -32,7 +33,14 @@ var (
 	DefaultKey string = "WebxRedis"
 )
 
+var (
+	_ Cache  = &Redis{}
+	_ Locker = &Redis{}
+)
+
 // Redis cache adapter.
+// 缓存的键记录在名为key的有序集合中(分值为过期时间的Unix秒数，永不过期时为+inf)，用于Clear。
+// 每次写入时移除其中已过期的键，因此集合的大小不会超过有效缓存的数量
 type Redis struct {
 	p        *redis.Pool // redis connection pool
 	conninfo string
@@ -68,15 +76,17 @@ func (rc *Redis) do(commandName string, args ...interface{}) (reply interface{},
 
 // Get cache from redis.
 func (rc *Redis) Get(key string) (interface{}, error) {
-	val, err := rc.do("GET", key)
+	val, err := redis.Bytes(rc.do("GET", key))
 	if err != nil {
+		if err == redis.ErrNil {
+			return nil, ErrNotFound
+		}
 		if rc.Debug {
 			log.Println("[Redis]GetErr: ", err, "Key:", key)
 		}
 		return nil, err
 	}
-	var v interface{}
-	err = Decode(val.([]byte), &v)
+	v, err := decodeValue(val)
 	if err != nil {
 		if rc.Debug {
 			log.Println("[Redis]DecodeErr: ", err, "Key:", key)
@@ -89,28 +99,155 @@ func (rc *Redis) Get(key string) (interface{}, error) {
 	return v, err
 }
 
-// put cache to redis.
-// timeout is ignored.
-func (rc *Redis) Put(key string, value interface{}) error {
-	val, err := Encode(value)
+// GetMulti get caches from redis.
+func (rc *Redis) GetMulti(keys []string) (map[string]interface{}, error) {
+	values := make(map[string]interface{}, len(keys))
+	if len(keys) == 0 {
+		return values, nil
+	}
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		args[i] = key
+	}
+	replies, err := redis.Values(rc.do("MGET", args...))
 	if err != nil {
-		if rc.Debug {
-			log.Println("[Redis]EncodeErr: ", err, "Key:", key)
+		return nil, err
+	}
+	for i, reply := range replies {
+		b, ok := reply.([]byte)
+		if !ok {
+			continue
+		}
+		v, err := decodeValue(b)
+		if err != nil {
+			if rc.Debug {
+				log.Println("[Redis]DecodeErr: ", err, "Key:", keys[i])
+			}
+			continue
+		}
+		values[keys[i]] = v
+	}
+	return values, nil
+}
+
+// 在一个事务中读取多个缓存及其剩余有效期，永不过期的缓存有效期为0
+func (rc *Redis) getWithTTL(keys []string) (map[string]interface{}, map[string]time.Duration, error) {
+	values := make(map[string]interface{}, len(keys))
+	ttls := make(map[string]time.Duration, len(keys))
+	if len(keys) == 0 {
+		return values, ttls, nil
+	}
+	if rc.p == nil {
+		rc.connectInit()
+	}
+	c := rc.p.Get()
+	defer c.Close()
+	if err := c.Send("MULTI"); err != nil {
+		return nil, nil, err
+	}
+	for _, key := range keys {
+		if err := c.Send("GET", key); err != nil {
+			return nil, nil, err
+		}
+		if err := c.Send("PTTL", key); err != nil {
+			return nil, nil, err
+		}
+	}
+	replies, err := redis.Values(c.Do("EXEC"))
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, key := range keys {
+		b, ok := replies[i*2].([]byte)
+		if !ok {
+			continue
+		}
+		v, err := decodeValue(b)
+		if err != nil {
+			if rc.Debug {
+				log.Println("[Redis]DecodeErr: ", err, "Key:", key)
+			}
+			continue
+		}
+		pttl, _ := redis.Int64(replies[i*2+1], nil)
+		if pttl == -2 || pttl == 0 {
+			//读取之后即已过期
+			continue
+		}
+		values[key] = v
+		if pttl > 0 {
+			ttls[key] = time.Duration(pttl) * time.Millisecond
 		}
+	}
+	return values, ttls, nil
+}
+
+// put cache to redis with the default lifetime.
+func (rc *Redis) Put(key string, value interface{}) error {
+	return rc.Set(key, value, lifeTimeDuration(rc.LifeTime))
+}
+
+// 在连接c上发送保存缓存的命令(未执行)
+func (rc *Redis) sendSet(c redis.Conn, key string, val []byte, ttl time.Duration) error {
+	var err error
+	score := "+inf"
+	if seconds := ttlSeconds(ttl); seconds > 0 {
+		err = c.Send("SETEX", key, seconds, val)
+		score = strconv.FormatInt(time.Now().Unix()+seconds, 10)
+	} else {
+		err = c.Send("SET", key, val)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Send("ZADD", rc.key, score, key)
+}
+
+// 在连接c上发送移除已过期键的命令(未执行)
+func (rc *Redis) sendPrune(c redis.Conn) error {
+	return c.Send("ZREMRANGEBYSCORE", rc.key, "-inf", "("+strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// Set cache to redis.
+func (rc *Redis) Set(key string, value interface{}, ttl time.Duration) error {
+	return rc.SetMulti(map[string]interface{}{key: value}, ttl)
+}
+
+// SetMulti set caches to redis in a transaction.
+func (rc *Redis) SetMulti(data map[string]interface{}, ttl time.Duration) error {
+	if rc.p == nil {
+		rc.connectInit()
+	}
+	c := rc.p.Get()
+	defer c.Close()
+	if err := c.Send("MULTI"); err != nil {
 		return err
 	}
-	if _, err = rc.do("SETEX", key, rc.LifeTime, val); err != nil {
+	if err := rc.sendPrune(c); err != nil {
 		return err
 	}
-	_, err = rc.do("HSET", rc.key, key, true)
+	for key, value := range data {
+		val, err := encodeValue(value)
+		if err != nil {
+			if rc.Debug {
+				log.Println("[Redis]EncodeErr: ", err, "Key:", key)
+			}
+			c.Do("DISCARD")
+			return err
+		}
+		if err = rc.sendSet(c, key, val, ttl); err != nil {
+			return err
+		}
+	}
+	_, err := c.Do("EXEC")
 	if err != nil {
 		if rc.Debug {
-			log.Println("[Redis]PutErr: ", err, "Key:", key)
+			log.Println("[Redis]PutErr: ", err)
 		}
 		return err
 	}
 	if rc.Debug {
-		log.Println("[Redis]Put: ", key)
+		log.Println("[Redis]Put: ", len(data))
 	}
 	return err
 }
@@ -121,7 +258,7 @@ func (rc *Redis) Del(key string) error {
 	if _, err = rc.do("DEL", key); err != nil {
 		return err
 	}
-	_, err = rc.do("HDEL", rc.key, key)
+	_, err = rc.do("ZREM", rc.key, key)
 	if err != nil {
 		if rc.Debug {
 			log.Println("[Redis]DelErr: ", err, "Key:", key)
@@ -135,34 +272,52 @@ func (rc *Redis) Del(key string) error {
 }
 
 // check cache exist in redis.
-func (rc *Redis) IsExist(key string) bool {
+func (rc *Redis) Has(key string) (bool, error) {
 	v, err := redis.Bool(rc.do("EXISTS", key))
 	if err != nil {
-		return false
+		return false, err
 	}
 	if v == false {
-		if _, err = rc.do("HDEL", rc.key, key); err != nil {
-			return false
+		if _, err = rc.do("ZREM", rc.key, key); err != nil {
+			return false, err
 		}
 	}
+	return v, nil
+}
+
+// check cache exist in redis.
+func (rc *Redis) IsExist(key string) bool {
+	v, _ := rc.Has(key)
 	return v
 }
 
+// 计数并在计数器被新建时记录键，分值为计数器的过期时间(没有有效期时为+inf)。
+// 不使用ZADD NX，以免沿用同名的已过期缓存记录的分值
+var incrScript = redis.NewScript(2, `local n = redis.call("INCRBY", KEYS[1], ARGV[1])
+if n == tonumber(ARGV[1]) then
+	local score = "+inf"
+	local ttl = redis.call("PTTL", KEYS[1])
+	if ttl > 0 then
+		score = tonumber(ARGV[2]) + math.ceil(ttl / 1000)
+	end
+	redis.call("ZADD", KEYS[2], score, KEYS[1])
+end
+return n`)
+
 // increase counter in redis.
-func (rc *Redis) Incr(key string, delta uint64) error {
-	_, err := redis.Bool(rc.do("INCRBY", key, delta))
-	return err
+// 只在计数器被新建时记录键，以免每次计数都写入集合；之后通过Set设置有效期时会更新记录的过期时间
+func (rc *Redis) Incr(key string, delta int64) (int64, error) {
+	return redis.Int64(rc.Eval(incrScript, key, rc.key, delta, time.Now().Unix()))
 }
 
 // decrease counter in redis.
-func (rc *Redis) Decr(key string, delta uint64) error {
-	_, err := redis.Bool(rc.do("INCRBY", key, delta))
-	return err
+func (rc *Redis) Decr(key string, delta int64) (int64, error) {
+	return rc.Incr(key, -delta)
 }
 
 // clean all cache in redis. delete this redis collection.
-func (rc *Redis) ClearAll() error {
-	cachedKeys, err := redis.Strings(rc.do("HKEYS", rc.key))
+func (rc *Redis) Clear() error {
+	cachedKeys, err := redis.Strings(rc.do("ZRANGE", rc.key, 0, -1))
 	if err != nil {
 		return err
 	}
@@ -175,13 +330,67 @@ func (rc *Redis) ClearAll() error {
 	return err
 }
 
+// clean all cache in redis. delete this redis collection.
+func (rc *Redis) ClearAll() error {
+	return rc.Clear()
+}
+
+var unlockScript = redis.NewScript(1, `if redis.call("GET", KEYS[1]) == ARGV[1] then return redis.call("DEL", KEYS[1]) else return 0 end`)
+
+// Lock acquire a distributed lock which expires after ttl.
+func (rc *Redis) Lock(key string, ttl time.Duration) (string, bool, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ``, false, err
+	}
+	token := hex.EncodeToString(b)
+	ms := int64(ttl / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+	_, err := redis.String(rc.do("SET", key, token, "NX", "PX", ms))
+	if err == redis.ErrNil {
+		return ``, false, nil
+	}
+	if err != nil {
+		return ``, false, err
+	}
+	return token, true, nil
+}
+
+// Unlock release the lock acquired by Lock.
+func (rc *Redis) Unlock(key string, token string) error {
+	_, err := rc.Eval(unlockScript, key, token)
+	return err
+}
+
+// Eval run the lua script. keys and args are passed in the order of keysAndArgs.
+func (rc *Redis) Eval(script *redis.Script, keysAndArgs ...interface{}) (interface{}, error) {
+	if rc.p == nil {
+		rc.connectInit()
+	}
+	c := rc.p.Get()
+	defer c.Close()
+	return script.Do(c, keysAndArgs...)
+}
+
+// close the connection pool.
+func (rc *Redis) Close() error {
+	if rc.p == nil {
+		return nil
+	}
+	return rc.p.Close()
+}
+
 // start redis cache adapter.
-// config is like {"key":"collection key","conn":"connection info","dbnum":"0"}
+// config is like {"key":"collection key","conn":"connection info","dbnum":"0","lifetime":"3600"}
 // the cache item in redis are stored forever,
 // so no gc operation.
 func (rc *Redis) Connect(config string) error {
-	var cf map[string]string
-	json.Unmarshal([]byte(config), &cf)
+	cf, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
 	if _, ok := cf["key"]; !ok {
 		cf["key"] = DefaultKey
 	}
@@ -194,6 +403,7 @@ func (rc *Redis) Connect(config string) error {
 	rc.key = cf["key"]
 	rc.conninfo = cf["conn"]
 	rc.dbnum, _ = strconv.Atoi(cf["dbnum"])
+	rc.LifeTime = configLifeTime(cf)
 	rc.connectInit()
 
 	c := rc.p.Get()
@@ -206,6 +416,9 @@ func (rc *Redis) Connect(config string) error {
 func (rc *Redis) connectInit() {
 	dialFunc := func() (c redis.Conn, err error) {
 		c, err = redis.Dial("tcp", rc.conninfo)
+		if err != nil {
+			return nil, err
+		}
 		_, selecterr := c.Do("SELECT", rc.dbnum)
 		if selecterr != nil {
 			c.Close()
@@ -18,62 +18,88 @@
 package htmlcache
 
 import (
+	"bytes"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/webx-top/echo"
 	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/cachestore"
 	"github.com/webx-top/webx/lib/com"
+	"github.com/webx-top/webx/lib/middleware/compress"
+	"github.com/webx-top/webx/lib/middleware/secure"
 )
 
+// noncePlaceholder 在保存的页面中代替生成页面时请求的CSP nonce
+const noncePlaceholder = "\x00webx:nonce\x00"
+
 type Config struct {
 	HtmlCacheDir   string
 	HtmlCacheOn    bool
 	HtmlCacheRules map[string]interface{}
 	HtmlCacheTime  interface{}
+	Store          cachestore.Cache   //保存页面和标签版本的缓存，为nil时以文件形式保存到HtmlCacheDir
+	KeyPrefix      string             //Store中缓存键的前缀，默认为"htmlcache:"
+	CacheControl   string             //缓存命中时的Cache-Control响应头，为空时为"no-cache"(客户端每次都须验证)
+	Compress       *compress.Compress //不为nil时同时保存页面的预压缩版本，缓存命中时直接输出
 	htmlCacheRules map[string]*Rule
+	once           sync.Once
 }
 
-func (c *Config) Read(ctx echo.Context) bool {
-	ct := X.X(ctx)
-	req := ctx.Request()
-	if !c.HtmlCacheOn || req.Method() != `GET` {
-		return false
+// MatchRule 查找当前请求适用的缓存规则，没有时返回nil。HtmlCacheRules的键依次匹配：
+// 路由规则(如"/article/:id")、网址路径(如"/about")、"控制器:动作"、"动作"和"控制器:"
+func (c *Config) MatchRule(ctx echo.Context) *Rule {
+	c.once.Do(func() {
+		c.htmlCacheRules = make(map[string]*Rule)
+		for key, rule := range c.HtmlCacheRules {
+			if r := c.Rule(rule); r != nil {
+				c.htmlCacheRules[key] = r
+			}
+		}
+	})
+	if v, ok := c.htmlCacheRules[ctx.Path()]; ok {
+		return v
+	}
+	if v, ok := c.htmlCacheRules[ctx.Request().URL().Path()]; ok {
+		return v
 	}
-	p := strings.Trim(req.URL().Path(), `/`)
+	p := strings.Trim(ctx.Request().URL().Path(), `/`)
 	if p == `` {
 		p = `index`
 	}
 	s := strings.SplitN(p, `/`, 3)
-
-	if c.htmlCacheRules == nil {
-		c.htmlCacheRules = make(map[string]*Rule)
-		for key, rule := range c.HtmlCacheRules {
-			c.htmlCacheRules[key] = c.Rule(rule)
-		}
-	}
-
-	var rule *Rule
 	switch len(s) {
 	case 2:
-		k := s[0] + `:` + s[1]
-		if v, ok := c.htmlCacheRules[k]; ok {
-			rule = v
-		} else if v, ok := c.htmlCacheRules[s[1]]; ok {
-			rule = v
-		} else {
-			k = s[0] + `:`
-			if v, ok := c.htmlCacheRules[k]; ok {
-				rule = v
-			}
+		if v, ok := c.htmlCacheRules[s[0]+`:`+s[1]]; ok {
+			return v
 		}
+		if v, ok := c.htmlCacheRules[s[1]]; ok {
+			return v
+		}
+		fallthrough
 	case 1:
-		k := s[0] + `:`
-		if v, ok := c.htmlCacheRules[k]; ok {
-			rule = v
+		if v, ok := c.htmlCacheRules[s[0]+`:`]; ok {
+			return v
 		}
 	}
+	return nil
+}
+
+func (c *Config) Read(ctx echo.Context) bool {
+	ct := X.X(ctx)
+	req := ctx.Request()
+	if !c.HtmlCacheOn || req.Method() != `GET` {
+		return false
+	}
+	rule := c.MatchRule(ctx)
+	if rule == nil {
+		return false
+	}
+	if c.Store != nil {
+		return c.readStore(rule, ctx)
+	}
 	var saveFile string = c.SaveFileName(rule, ctx)
 	if saveFile == "" {
 		return false
@@ -84,21 +110,76 @@ func (c *Config) Read(ctx echo.Context) bool {
 	mtime, expired := c.Expired(rule, ctx, saveFile)
 	if expired {
 		ctx.Set(`webx:saveHtmlFile`, saveFile)
+		if mtime > 0 {
+			setCacheStatus(ctx, `STALE`)
+		} else {
+			setCacheStatus(ctx, `MISS`)
+		}
+		return false
+	}
+	html, err := com.ReadFile(saveFile)
+	if err != nil {
+		ctx.Object().Echo().Logger().Error(err)
+		ctx.Set(`webx:saveHtmlFile`, saveFile)
+		setCacheStatus(ctx, `MISS`)
 		return false
 	}
-	//_ = mtime
-	//ctx.File(saveFile, ``, false)
+	var modTime time.Time
+	if mtime > 0 {
+		modTime = time.Unix(mtime, 0)
+	}
+	c.output(rule, ctx, html, X.ETag(html, false), modTime, func(encoding string) []byte {
+		b, _ := com.ReadFile(saveFile + compress.Ext(encoding))
+		return b
+	})
+	return true
+}
 
-	if !HttpCache(ctx, mtime, nil) {
-		html, err := com.ReadFile(saveFile)
-		if err != nil {
-			ctx.Object().Echo().Logger().Error(err)
+// 输出缓存的页面，客户端缓存仍然有效时输出304。
+// 设置了Compress时，variant返回与客户端协商的压缩方式对应的预压缩内容，不存在时返回nil
+func (c *Config) output(rule *Rule, ctx echo.Context, content []byte, etag string, modTime time.Time, variant func(encoding string) []byte) {
+	ct := X.X(ctx)
+	if bytes.Contains(content, []byte(noncePlaceholder)) {
+		//每次输出的nonce都不同，不能使用预压缩的内容，也不能让客户端以304复用之前的页面
+		setCacheStatus(ctx, `HIT`)
+		ctx.Response().Header().Set(`Cache-Control`, `no-store`)
+		Output(bytes.Replace(content, []byte(noncePlaceholder), []byte(secure.Nonce(ctx)), -1), ct)
+		ct.Exit = true
+		return
+	}
+	cacheControl := rule.CacheControl
+	if cacheControl == `` {
+		cacheControl = c.CacheControl
+	}
+	if cacheControl == `` {
+		cacheControl = `no-cache`
+	}
+	if c.Compress != nil && ctx.Query(`callback`) == `` {
+		header := ctx.Response().Header()
+		X.AddVary(header, `Accept-Encoding`)
+		encoding := compress.Negotiate(ctx.Request().Header().Get(`Accept-Encoding`), c.Compress.EncodingList())
+		if b := variant(encoding); encoding != `` && b != nil {
+			compress.Skip(ctx)
+			header.Set(`Content-Encoding`, encoding)
+			//同一页面的不同压缩版本须使用不同的强ETag
+			etag = strings.TrimSuffix(etag, `"`) + `-` + encoding + `"`
+			if !HttpCache(ctx, etag, modTime, cacheControl) {
+				ct.Code = http.StatusOK
+				ct.Blob(http.StatusOK, ContentType(ct), b)
+			}
+			ct.Exit = true
+			return
 		}
-		Output(html, ct)
 	}
-
+	if !HttpCache(ctx, etag, modTime, cacheControl) {
+		Output(content, ct)
+	}
 	ct.Exit = true
-	return true
+}
+
+// 设置X-Cache响应头：HIT(使用缓存)、MISS(没有缓存)或STALE(缓存已过期或标签已被清除，重新生成)
+func setCacheStatus(ctx echo.Context, status string) {
+	ctx.Response().Header().Set(`X-Cache`, status)
 }
 
 func (c *Config) Rule(rule interface{}) *Rule {
@@ -140,6 +221,14 @@ func (c *Config) Write(b []byte, ctx echo.Context) bool {
 	if !c.HtmlCacheOn || ctx.Request().Method() != `GET` || X.X(ctx).Code != http.StatusOK {
 		return false
 	}
+	//页面中本次请求的CSP nonce保存为占位符，输出时替换为当时请求的nonce，
+	//否则之后的请求会得到过期的nonce而被浏览器拦截。Secure中间件须在本中间件之前执行
+	if nonce := secure.Nonce(ctx); nonce != `` {
+		b = bytes.Replace(b, []byte(nonce), []byte(noncePlaceholder), -1)
+	}
+	if c.Store != nil {
+		return c.writeStore(b, ctx)
+	}
 	tmpl := X.MustString(ctx, `webx:saveHtmlFile`)
 	if tmpl == `` {
 		return false
@@ -147,9 +236,26 @@ func (c *Config) Write(b []byte, ctx echo.Context) bool {
 	if err := com.WriteFile(tmpl, b); err != nil {
 		ctx.Object().Echo().Logger().Debug(err)
 	}
+	for encoding, data := range c.variants(b, ctx) {
+		if err := com.WriteFile(tmpl+compress.Ext(encoding), data); err != nil {
+			ctx.Object().Echo().Logger().Debug(err)
+		}
+	}
 	return true
 }
 
+// 页面的预压缩内容。含有nonce占位符的页面在输出时才能确定内容，不预压缩
+func (c *Config) variants(b []byte, ctx echo.Context) map[string][]byte {
+	if c.Compress == nil || bytes.Contains(b, []byte(noncePlaceholder)) {
+		return nil
+	}
+	variants, err := c.Compress.Variants(ContentType(X.X(ctx)), b)
+	if err != nil {
+		ctx.Object().Echo().Logger().Debug(err)
+	}
+	return variants
+}
+
 func (c *Config) SaveFileName(rule *Rule, ctx echo.Context) string {
 	if rule == nil {
 		return ""
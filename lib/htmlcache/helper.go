@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"encoding/xml"
 	"net/http"
+	"strings"
 
 	X "github.com/webx-top/webx"
 )
@@ -79,14 +80,27 @@ func RenderHTML(ctx *X.Context) (b []byte, err error) {
 	return
 }
 
+// ContentType 返回当前输出格式在Server.Formats中注册的MIME类型，格式未注册时使用默认格式的类型
+func ContentType(ctx *X.Context) string {
+	formats := ctx.Server.Formats
+	mime := formats.MimeType(ctx.Format)
+	if mime == `` {
+		mime = formats.MimeType(formats.Default)
+	}
+	switch {
+	case mime == ``:
+		return `application/octet-stream`
+	case strings.HasPrefix(mime, `text/`), strings.HasSuffix(mime, `json`), strings.HasSuffix(mime, `xml`), strings.HasSuffix(mime, `javascript`):
+		return mime + `; charset=utf-8`
+	}
+	return mime
+}
+
+// Output 以ContentType返回的类型输出缓存的内容，JSON格式的请求带有callback参数时输出JSONP
 func Output(content []byte, ctx *X.Context) (err error) {
 	ctx.Code = http.StatusOK
-	switch ctx.Format {
-	case `xml`:
-		return OutputXML(content, ctx)
-	case `json`:
+	if ctx.Format == `json` && ctx.Query(`callback`) != `` {
 		return OutputJSON(content, ctx)
-	default:
-		return OutputHTML(content, ctx)
 	}
+	return ctx.Blob(ctx.Code, ContentType(ctx), content)
 }
@@ -0,0 +1,215 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package htmlcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/cachestore"
+	"github.com/webx-top/webx/lib/middleware/secure"
+	"github.com/webx-top/webx/testutil"
+)
+
+// 创建请求网址为http://host/path?query的Context
+func newTestContext(host string, path string, query string) *X.Context {
+	ctx, _ := newTestRequest(testutil.NewRequest(`GET`, `http://`+host+path+`?`+query, nil))
+	return ctx
+}
+
+// 用req创建Context，实际发送的响应记录在返回的ResponseRecorder中
+func newTestRequest(req *http.Request) (*X.Context, *httptest.ResponseRecorder) {
+	ctx, rec := testutil.NewContext(req)
+	return &X.Context{
+		Context: ctx,
+		Server:  &X.Server{Formats: X.NewFormats()},
+		Code:    http.StatusOK,
+	}, rec
+}
+
+func newTestConfig() *Config {
+	return &Config{HtmlCacheOn: true, Store: cachestore.NewMemory(nil)}
+}
+
+func cacheStatus(ctx *X.Context) string {
+	return ctx.Response().Header().Get(`X-Cache`)
+}
+
+func TestKey(t *testing.T) {
+	c := newTestConfig()
+	rule := &Rule{Query: []string{`page`}}
+	key := c.Key(rule, newTestContext(`a.example.com`, `/list`, `page=1&sort=id`))
+	if k := c.Key(rule, newTestContext(`a.example.com`, `/list`, `page=1&sort=name`)); k != key {
+		t.Error("parameters that are not in the rule change the key")
+	}
+	if k := c.Key(rule, newTestContext(`a.example.com`, `/list`, `page=2`)); k == key {
+		t.Error("parameters in the rule do not change the key")
+	}
+	if k := c.Key(rule, newTestContext(`b.example.com`, `/list`, `page=1`)); k == key {
+		t.Error("pages of different hosts share the key")
+	}
+	if k := c.Key(rule, newTestContext(`a.example.com:8080`, `/list`, `page=1`)); k == key {
+		t.Error("pages of different ports share the key")
+	}
+}
+
+func TestTagVersions(t *testing.T) {
+	c := newTestConfig()
+	rule := &Rule{Tags: []string{`article:{id}`}}
+	ctx := newTestContext(`example.com`, `/article`, `id=1`)
+	if c.readStore(rule, ctx) || cacheStatus(ctx) != `MISS` {
+		t.Fatalf("empty store: %q", cacheStatus(ctx))
+	}
+	AddTags(ctx, `list`)
+	//生成页面期间清除标签，页面须以清除之前的版本保存
+	if err := c.Purge(`article:1`); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Write([]byte(`<p>old</p>`), ctx) {
+		t.Fatal("page is not cached")
+	}
+	v, err := c.Store.Get(c.Key(rule, ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	page := v.(Page)
+	if len(page.Tags) != 2 || page.Tags[`article:1`] != 0 || page.Tags[`list`] != 0 {
+		t.Errorf("tags: %v", page.Tags)
+	}
+	ctx = newTestContext(`example.com`, `/article`, `id=1`)
+	if c.readStore(rule, ctx) || cacheStatus(ctx) != `STALE` {
+		t.Errorf("page rendered before the purge: %q", cacheStatus(ctx))
+	}
+}
+
+func TestPurge(t *testing.T) {
+	c := newTestConfig()
+	rule := &Rule{}
+	ctx := newTestContext(`example.com`, `/list`, ``)
+	c.readStore(rule, ctx)
+	if err := c.Purge(`list`); err != nil {
+		t.Fatal(err)
+	}
+	AddTags(ctx, `list`)
+	if !c.Write([]byte(`<p>list</p>`), ctx) {
+		t.Fatal("page is not cached")
+	}
+	key := c.Key(rule, ctx)
+	v, _ := c.Store.Get(key)
+	if v.(Page).Tags[`list`] == 0 {
+		t.Fatalf("tag version: %v", v.(Page).Tags)
+	}
+	//保存版本的缓存丢失后，页面须失效
+	c.Store.Del(c.tagKey(`list`))
+	ctx = newTestContext(`example.com`, `/list`, ``)
+	if c.readStore(rule, ctx) || cacheStatus(ctx) != `STALE` {
+		t.Errorf("tag version is lost: %q", cacheStatus(ctx))
+	}
+}
+
+func TestAddTagsWithoutCache(t *testing.T) {
+	ctx := newTestContext(`example.com`, `/list`, ``)
+	AddTags(ctx, `list`)
+	if ctx.Get(`webx:htmlCacheTags`) != nil {
+		t.Error("tags are recorded without a cache miss")
+	}
+	if newTestConfig().Write([]byte(`<p>list</p>`), ctx) {
+		t.Error("page is cached without a cache miss")
+	}
+}
+
+func TestReadStore(t *testing.T) {
+	c := newTestConfig()
+	expired := false
+	rule := &Rule{ExpireFunc: func(string, echo.Context) (int64, bool) {
+		return 0, expired
+	}}
+	ctx, _ := newTestRequest(testutil.NewRequest(`GET`, `http://example.com/list`, nil))
+	ctx.Format = `json`
+	if c.readStore(rule, ctx) || cacheStatus(ctx) != `MISS` {
+		t.Fatalf("empty store: %q", cacheStatus(ctx))
+	}
+	if !c.Write([]byte(`{"Status":1}`), ctx) {
+		t.Fatal("page is not cached")
+	}
+
+	ctx, rec := newTestRequest(testutil.NewRequest(`GET`, `http://example.com/list`, nil))
+	ctx.Format = `json`
+	if !c.readStore(rule, ctx) || cacheStatus(ctx) != `HIT` {
+		t.Fatalf("cached page is not used: %q", cacheStatus(ctx))
+	}
+	header := rec.Result().Header
+	if rec.Code != http.StatusOK || rec.Body.String() != `{"Status":1}` {
+		t.Errorf("response: %d %q", rec.Code, rec.Body.String())
+	}
+	if ct := header.Get(`Content-Type`); ct != `application/json; charset=utf-8` {
+		t.Errorf("Content-Type: %q", ct)
+	}
+	etag := header.Get(`ETag`)
+	if etag == `` || header.Get(`Cache-Control`) != `no-cache` {
+		t.Errorf("ETag: %q, Cache-Control: %q", etag, header.Get(`Cache-Control`))
+	}
+
+	req := testutil.NewRequest(`GET`, `http://example.com/list`, nil)
+	req.Header.Set(`If-None-Match`, etag)
+	ctx, rec = newTestRequest(req)
+	ctx.Format = `json`
+	if !c.readStore(rule, ctx) || rec.Code != http.StatusNotModified || rec.Body.Len() != 0 {
+		t.Errorf("conditional request: %d %q", rec.Code, rec.Body.String())
+	}
+
+	expired = true
+	ctx, _ = newTestRequest(testutil.NewRequest(`GET`, `http://example.com/list`, nil))
+	ctx.Format = `json`
+	if c.readStore(rule, ctx) || cacheStatus(ctx) != `STALE` {
+		t.Errorf("expired page: %q", cacheStatus(ctx))
+	}
+}
+
+func TestNonce(t *testing.T) {
+	c := newTestConfig()
+	rule := &Rule{}
+	ctx := newTestContext(`example.com`, `/`, ``)
+	c.readStore(rule, ctx)
+	ctx.Set(secure.NonceKey, `abc123`)
+	if !c.Write([]byte(`<script nonce="abc123"></script>`), ctx) {
+		t.Fatal("page with the nonce of the request is not cached")
+	}
+	v, _ := c.Store.Get(c.Key(rule, ctx))
+	if body := string(v.(Page).Body); strings.Contains(body, `abc123`) {
+		t.Errorf("nonce of the request is saved: %q", body)
+	}
+
+	req := testutil.NewRequest(`GET`, `http://example.com/?`, nil)
+	req.Header.Set(`If-None-Match`, v.(Page).ETag)
+	ctx, rec := newTestRequest(req)
+	ctx.Set(secure.NonceKey, `xyz789`)
+	if !c.readStore(rule, ctx) || cacheStatus(ctx) != `HIT` {
+		t.Fatalf("cached page is not used: %q", cacheStatus(ctx))
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != `<script nonce="xyz789"></script>` {
+		t.Errorf("response: %d %q", rec.Code, rec.Body.String())
+	}
+	if cc := rec.Result().Header.Get(`Cache-Control`); cc != `no-store` {
+		t.Errorf("Cache-Control: %q", cc)
+	}
+}
@@ -18,10 +18,10 @@
 package htmlcache
 
 import (
-	"fmt"
-	"github.com/webx-top/echo"
-	"net/http"
 	"time"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
 )
 
 type Rule struct {
@@ -29,34 +29,28 @@ type Rule struct {
 	SaveFunc   func(saveFile string, c echo.Context) string        //自定义保存名称
 	ExpireTime int                                                 //过期时间(秒)
 	ExpireFunc func(saveFile string, c echo.Context) (int64, bool) //判断缓存是否过期
+
+	CacheControl string //Cache-Control响应头，为空时使用Config.CacheControl
+
+	//以下设置仅在使用Config.Store时有效
+
+	Query   []string //缓存键包含的网址参数，"*"表示全部参数
+	Headers []string //缓存键包含的请求头，如：Accept-Language
+	Cookies []string //缓存键包含的cookie
+	Tags    []string //页面的标签，其中的{name}会被替换为路由参数或网址参数name的值，如：article:{id}
 }
 
-func HttpCache(ctx echo.Context, eTag interface{}, etagValidator func(oldEtag, newEtag string) bool) bool {
-	var etag string
-	if eTag == nil {
-		etag = fmt.Sprintf(`%v`, time.Now().UTC().Unix())
-	} else {
-		etag = fmt.Sprintf(`%v`, eTag)
+// HttpCache 为缓存命中的页面设置X-Cache、Cache-Control、ETag和Last-Modified响应头，并判断条件请求。
+// 返回true表示已输出304(或412)，不需要再输出内容
+func HttpCache(ctx echo.Context, etag string, modTime time.Time, cacheControl string) bool {
+	header := ctx.Response().Header()
+	header.Set(`X-Cache`, `HIT`)
+	if cacheControl != `` {
+		header.Set(`Cache-Control`, cacheControl)
 	}
-	resp := ctx.Response()
-	//resp.Header().Set(`Connection`, `keep-alive`)
-	resp.Header().Set(`X-Cache`, `HIT from Webx-Page-Cache`)
-	if inm := ctx.Request().Header().Get("If-None-Match"); inm != `` {
-		var valid bool
-		if etagValidator != nil {
-			valid = etagValidator(inm, etag)
-		} else {
-			valid = inm == etag
-		}
-		if valid {
-			resp.Header().Del(`Content-Type`)
-			resp.Header().Del(`Content-Length`)
-			resp.WriteHeader(http.StatusNotModified)
-			ctx.Object().Echo().Logger().Debugf(`%v is not modified.`, ctx.Path())
-			return true
-		}
+	if X.X(ctx).CheckConditional(etag, modTime) {
+		ctx.Object().Echo().Logger().Debugf(`%v is not modified.`, ctx.Path())
+		return true
 	}
-	resp.Header().Set(`Etag`, etag)
-	resp.Header().Set(`Cache-Control`, `public,max-age=1`)
 	return false
 }
@@ -0,0 +1,265 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package htmlcache
+
+import (
+	"encoding/gob"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/cachestore"
+)
+
+func init() {
+	gob.Register(Page{})
+}
+
+// Page 是保存在Config.Store中的页面
+type Page struct {
+	Body     []byte
+	ETag     string            //根据内容生成的强ETag
+	ModTime  int64             //生成时间(Unix时间戳)
+	Tags     map[string]int64  //开始生成时各标签的版本，标签被清除后版本改变，页面随之失效
+	Variants map[string][]byte //预压缩的内容，键为压缩方式
+}
+
+// AddTags 为当前请求生成的页面添加标签，可在控制器中根据页面内容调用。
+// 标签的版本在调用时读取，应在读取页面数据之前调用，否则读取数据之后清除的标签不能使页面失效。
+// 之后调用Config.Purge清除标签即可使所有带有该标签的页面失效
+func AddTags(ctx echo.Context, tags ...string) {
+	c, _ := ctx.Get(`webx:htmlCacheConfig`).(*Config)
+	versions, _ := ctx.Get(`webx:htmlCacheTags`).(map[string]int64)
+	if c == nil || versions == nil {
+		return
+	}
+	added, err := c.tagVersions(tags)
+	if err != nil {
+		ctx.Object().Echo().Logger().Debug(err)
+		ctx.Set(`webx:htmlCacheKey`, ``)
+		return
+	}
+	for tag, version := range added {
+		versions[tag] = version
+	}
+}
+
+// Purge 清除带有指定标签的所有页面。
+// 标签的版本更新为清除时的时间(纳秒)而不是递增，保存版本的缓存被淘汰或丢失时页面只会失效，
+// 不会因为计数器从0重新累加到原来的值而使已清除的页面重新生效
+func (c *Config) Purge(tags ...string) error {
+	version := time.Now().UnixNano()
+	for _, tag := range tags {
+		if err := c.Store.Set(c.tagKey(tag), version, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Key 返回页面在Store中的缓存键，包含网站的来源(协议、域名和端口)、网址路径、
+// 规则中指定的网址参数、请求头、cookie以及当前的语言和输出格式
+func (c *Config) Key(rule *Rule, ctx echo.Context) string {
+	ct := X.X(ctx)
+	req := ctx.Request()
+	key := ct.Forwarded().Origin() + req.URL().Path()
+	if rule.SaveFile != `` || rule.SaveFunc != nil {
+		saveFile := rule.SaveFile
+		if rule.SaveFunc != nil {
+			saveFile = rule.SaveFunc(saveFile, ctx)
+		}
+		key = saveFile + `|` + key
+	}
+	if len(rule.Query) > 0 {
+		query := req.URL().Query()
+		names := rule.Query
+		if len(names) == 1 && names[0] == `*` {
+			names = make([]string, 0, len(query))
+			for name := range query {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+		}
+		for _, name := range names {
+			if values, ok := query[name]; ok {
+				key += `|q:` + name + `=` + strings.Join(values, `,`)
+			}
+		}
+	}
+	for _, name := range rule.Headers {
+		key += `|h:` + name + `=` + req.Header().Get(name)
+	}
+	for _, name := range rule.Cookies {
+		key += `|c:` + name + `=` + ct.GetCookie(name)
+	}
+	if ct.Language != `` {
+		key += `|l:` + ct.Language
+	}
+	if ct.Format != `` {
+		key += `|f:` + ct.Format
+	}
+	return c.prefix() + `page:` + cachestore.Md5(key)
+}
+
+func (c *Config) prefix() string {
+	if c.KeyPrefix == `` {
+		return `htmlcache:`
+	}
+	return c.KeyPrefix
+}
+
+func (c *Config) tagKey(tag string) string {
+	return c.prefix() + `tag:` + tag
+}
+
+// 获取标签的当前版本
+func (c *Config) tagVersions(tags []string) (map[string]int64, error) {
+	versions := make(map[string]int64, len(tags))
+	if len(tags) == 0 {
+		return versions, nil
+	}
+	keys := make([]string, len(tags))
+	for i, tag := range tags {
+		keys[i] = c.tagKey(tag)
+	}
+	values, err := c.Store.GetMulti(keys)
+	if err != nil {
+		return nil, err
+	}
+	for i, tag := range tags {
+		versions[tag], _ = values[keys[i]].(int64)
+	}
+	return versions, nil
+}
+
+// 规则中的标签，{name}替换为路由参数或网址参数的值
+func (c *Config) ruleTags(rule *Rule, ctx echo.Context) []string {
+	tags := make([]string, 0, len(rule.Tags))
+	for _, tag := range rule.Tags {
+		var r string
+		for {
+			start := strings.Index(tag, `{`)
+			end := strings.Index(tag, `}`)
+			if start < 0 || end < start {
+				break
+			}
+			name := tag[start+1 : end]
+			value := ctx.Param(name)
+			if value == `` {
+				value = ctx.Query(name)
+			}
+			r += tag[:start] + value
+			tag = tag[end+1:]
+		}
+		tags = append(tags, r+tag)
+	}
+	return tags
+}
+
+func (c *Config) readStore(rule *Rule, ctx echo.Context) bool {
+	key := c.Key(rule, ctx)
+	v, err := c.Store.Get(key)
+	if err != nil {
+		if err != cachestore.ErrNotFound {
+			ctx.Object().Echo().Logger().Error(err)
+		}
+		return c.miss(rule, ctx, key, `MISS`)
+	}
+	page, ok := v.(Page)
+	if !ok {
+		return c.miss(rule, ctx, key, `MISS`)
+	}
+	if _, fn := c.lifeTime(rule); fn != nil {
+		if _, expired := fn(key, ctx); expired {
+			return c.miss(rule, ctx, key, `STALE`)
+		}
+	}
+	if len(page.Tags) > 0 {
+		tags := make([]string, 0, len(page.Tags))
+		for tag := range page.Tags {
+			tags = append(tags, tag)
+		}
+		versions, err := c.tagVersions(tags)
+		if err != nil {
+			ctx.Object().Echo().Logger().Error(err)
+			return c.miss(rule, ctx, key, `MISS`)
+		}
+		for tag, version := range page.Tags {
+			if versions[tag] != version {
+				return c.miss(rule, ctx, key, `STALE`)
+			}
+		}
+	}
+	c.output(rule, ctx, page.Body, page.ETag, time.Unix(page.ModTime, 0), func(encoding string) []byte {
+		return page.Variants[encoding]
+	})
+	return true
+}
+
+// 缓存未命中时读取规则中标签的当前版本，页面生成后以这些版本保存。
+// 版本须在生成页面之前读取，否则生成期间标签被清除时，过期的页面会以清除后的版本保存
+func (c *Config) miss(rule *Rule, ctx echo.Context, key string, status string) bool {
+	setCacheStatus(ctx, status)
+	versions, err := c.tagVersions(c.ruleTags(rule, ctx))
+	if err != nil {
+		ctx.Object().Echo().Logger().Error(err)
+		return false
+	}
+	ctx.Set(`webx:htmlCacheKey`, key)
+	ctx.Set(`webx:htmlCacheRule`, rule)
+	ctx.Set(`webx:htmlCacheConfig`, c)
+	ctx.Set(`webx:htmlCacheTags`, versions)
+	return false
+}
+
+func (c *Config) writeStore(b []byte, ctx echo.Context) bool {
+	key := X.MustString(ctx, `webx:htmlCacheKey`)
+	rule, _ := ctx.Get(`webx:htmlCacheRule`).(*Rule)
+	versions, _ := ctx.Get(`webx:htmlCacheTags`).(map[string]int64)
+	if key == `` || rule == nil || versions == nil {
+		return false
+	}
+	page := Page{Body: b, ETag: X.ETag(b, false), ModTime: time.Now().Unix(), Tags: versions, Variants: c.variants(b, ctx)}
+	seconds, _ := c.lifeTime(rule)
+	if err := c.Store.Set(key, page, time.Duration(seconds)*time.Second); err != nil {
+		ctx.Object().Echo().Logger().Debug(err)
+		return false
+	}
+	return true
+}
+
+// 规则的有效期(秒)或判断是否过期的函数
+func (c *Config) lifeTime(rule *Rule) (int64, func(string, echo.Context) (int64, bool)) {
+	if rule.ExpireTime > 0 {
+		return int64(rule.ExpireTime), nil
+	}
+	if rule.ExpireFunc != nil {
+		return 0, rule.ExpireFunc
+	}
+	switch v := c.HtmlCacheTime.(type) {
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case func(string, echo.Context) (int64, bool):
+		return 0, v
+	}
+	return 0, nil
+}
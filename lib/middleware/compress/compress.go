@@ -0,0 +1,223 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// EncoderFunc 创建压缩数据写入w的压缩器，level为0时使用默认压缩级别
+type EncoderFunc func(w io.Writer, level int) (io.WriteCloser, error)
+
+type encoder struct {
+	fn  EncoderFunc
+	ext string
+}
+
+var (
+	encoders = map[string]*encoder{
+		`gzip`: {fn: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = gzip.DefaultCompression
+			}
+			return gzip.NewWriterLevel(w, level)
+		}, ext: `.gz`},
+		`deflate`: {fn: func(w io.Writer, level int) (io.WriteCloser, error) {
+			if level == 0 {
+				level = zlib.DefaultCompression
+			}
+			return zlib.NewWriterLevel(w, level)
+		}, ext: `.zz`},
+	}
+	encodersMutex = &sync.RWMutex{}
+)
+
+// Register 注册压缩方式，ext为预压缩文件的扩展名。如注册brotli：
+//
+//	compress.Register(`br`, `.br`, func(w io.Writer, level int) (io.WriteCloser, error) {
+//		return brotli.NewWriterLevel(w, level), nil
+//	})
+func Register(name string, ext string, fn EncoderFunc) {
+	encodersMutex.Lock()
+	encoders[name] = &encoder{fn: fn, ext: ext}
+	encodersMutex.Unlock()
+}
+
+func getEncoder(name string) *encoder {
+	encodersMutex.RLock()
+	defer encodersMutex.RUnlock()
+	return encoders[name]
+}
+
+// Ext 返回压缩方式对应的预压缩文件扩展名，未注册时返回空字符串
+func Ext(name string) string {
+	if e := getEncoder(name); e != nil {
+		return e.ext
+	}
+	return ``
+}
+
+// Encode 使用压缩方式name压缩数据
+func Encode(name string, b []byte, level int) ([]byte, error) {
+	e := getEncoder(name)
+	if e == nil {
+		return nil, fmt.Errorf(`compress: unknown encoding %q`, name)
+	}
+	buf := &bytes.Buffer{}
+	w, err := e.fn(buf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Negotiate 根据Accept-Encoding请求头从encodings(按服务端的优先顺序排列)中选择压缩方式，
+// q值高者优先，q值相同时按encodings的顺序。返回空字符串表示不压缩
+func Negotiate(acceptEncoding string, encodings []string) string {
+	if acceptEncoding == `` {
+		return ``
+	}
+	qs := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, `,`) {
+		name, q := parseQ(part)
+		if name != `` {
+			qs[strings.ToLower(name)] = q
+		}
+	}
+	var best string
+	var bestQ float64
+	for _, name := range encodings {
+		q, ok := qs[name]
+		if !ok {
+			q = qs[`*`]
+		}
+		if q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// 解析"gzip;q=0.8"
+func parseQ(s string) (string, float64) {
+	parts := strings.Split(s, `;`)
+	name := strings.TrimSpace(parts[0])
+	q := 1.0
+	for _, param := range parts[1:] {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, `q=`) {
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				q = v
+			}
+		}
+	}
+	return name, q
+}
+
+// New 创建使用默认设置的压缩中间件
+func New() *Compress {
+	return &Compress{
+		MinSize: 1024,
+		ContentTypes: []string{
+			`text/`,
+			`application/json`,
+			`application/javascript`,
+			`application/x-javascript`,
+			`application/xml`,
+			`application/xhtml+xml`,
+			`application/rss+xml`,
+			`application/atom+xml`,
+			`image/svg+xml`,
+		},
+	}
+}
+
+// Compress 根据Accept-Encoding压缩响应内容，并可为htmlcache和tplfunc.Static生成预压缩的内容
+type Compress struct {
+	//按优先顺序排列的压缩方式，为空时依次使用br(已注册时)、gzip和deflate
+	Encodings []string
+	//压缩级别，0为默认级别
+	Level int
+	//内容小于此大小(字节)时不压缩。
+	//响应头中没有Content-Length时，中间件推迟发送响应头，缓存此大小的内容后再确定是否压缩
+	MinSize int
+	//允许压缩的内容类型(前缀匹配)
+	ContentTypes []string
+	//静态文件目录(网址前缀=>目录)。请求的文件存在预压缩版本(如：app.js.gz)时直接输出
+	StaticDirs map[string]string
+}
+
+// EncodingList 返回可用的压缩方式，按优先顺序排列
+func (z *Compress) EncodingList() []string {
+	names := z.Encodings
+	if len(names) == 0 {
+		names = []string{`br`, `gzip`, `deflate`}
+	}
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		if getEncoder(name) != nil {
+			list = append(list, name)
+		}
+	}
+	return list
+}
+
+// Ext 返回压缩方式对应的预压缩文件扩展名
+func (z *Compress) Ext(encoding string) string {
+	return Ext(encoding)
+}
+
+// AllowType 内容类型是否允许压缩
+func (z *Compress) AllowType(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, prefix := range z.ContentTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Variants 为内容生成各种压缩方式的预压缩版本。内容太小或类型不允许压缩时返回nil
+func (z *Compress) Variants(contentType string, b []byte) (map[string][]byte, error) {
+	if len(b) < z.MinSize || !z.AllowType(contentType) {
+		return nil, nil
+	}
+	variants := map[string][]byte{}
+	for _, name := range z.EncodingList() {
+		encoded, err := Encode(name, b, z.Level)
+		if err != nil {
+			return nil, err
+		}
+		variants[name] = encoded
+	}
+	return variants, nil
+}
@@ -0,0 +1,75 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	encodings := []string{`br`, `gzip`, `deflate`}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{``, ``},
+		{`gzip, deflate`, `gzip`},
+		{`deflate, gzip`, `gzip`},
+		{`gzip;q=0.5, deflate`, `deflate`},
+		{`br;q=1.0, gzip;q=0.8`, `br`},
+		{`gzip;q=0, *`, `br`},
+		{`identity`, ``},
+		{`*;q=0`, ``},
+		{`GZIP`, `gzip`},
+	}
+	for _, test := range tests {
+		if got := Negotiate(test.accept, encodings); got != test.want {
+			t.Errorf("Negotiate(%q) = %q; want %q", test.accept, got, test.want)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	z := New()
+	content := []byte(strings.Repeat(`<p>webx</p>`, 200))
+	variants, err := z.Variants(`text/html; charset=utf-8`, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(variants) != 2 || variants[`gzip`] == nil || variants[`deflate`] == nil {
+		t.Fatalf(`unexpected variants: %v`, len(variants))
+	}
+	r, err := gzip.NewReader(bytes.NewReader(variants[`gzip`]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil || !bytes.Equal(b, content) {
+		t.Errorf(`gzip variant does not decode to the original content: %v`, err)
+	}
+	if variants, _ := z.Variants(`image/png`, content); variants != nil {
+		t.Error(`image/png must not be compressed`)
+	}
+	if variants, _ := z.Variants(`text/html`, content[:100]); variants != nil {
+		t.Error(`content smaller than MinSize must not be compressed`)
+	}
+}
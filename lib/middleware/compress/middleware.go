@@ -0,0 +1,251 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package compress
+
+import (
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/webx-top/echo"
+	"github.com/webx-top/echo/engine"
+	X "github.com/webx-top/webx"
+)
+
+const writerKey = `webx:compressWriter`
+
+// 压缩响应内容的Writer。发送响应头时才决定是否压缩，第一次写入时才创建压缩器。
+// 响应头中没有Content-Length时推迟发送响应头，缓存的内容达到MinSize字节才压缩
+type writer struct {
+	z        *Compress
+	resp     engine.Response
+	urlPath  string
+	origin   io.Writer
+	encoding string //协商的压缩方式，为空时不压缩
+	w        io.WriteCloser
+	on       bool //是否压缩
+	skipped  bool
+	closed   bool
+	pending  bool   //正在缓存内容，尚未决定是否压缩
+	buf      []byte //决定是否压缩之前缓存的内容
+	release  func() //发送推迟的响应头
+}
+
+// 发送响应头之前根据状态码和处理函数最终设置的响应头决定是否压缩，不能确定时不压缩
+func (w *writer) decide(code int) {
+	if w.skipped || w.closed {
+		return
+	}
+	header := w.resp.Header()
+	contentType := header.Get(`Content-Type`)
+	if contentType == `` {
+		contentType = mime.TypeByExtension(path.Ext(w.urlPath))
+	}
+	if contentType == `` || !w.z.AllowType(contentType) {
+		return
+	}
+	X.AddVary(header, `Accept-Encoding`)
+	if w.encoding == `` || header.Get(`Content-Encoding`) != `` {
+		return
+	}
+	if code < http.StatusOK || code == http.StatusNoContent || code == http.StatusNotModified {
+		return
+	}
+	if size, err := strconv.ParseInt(header.Get(`Content-Length`), 10, 64); err == nil {
+		if size < int64(w.z.MinSize) {
+			return
+		}
+	} else if w.z.MinSize > 0 {
+		//不知道内容的长度，先缓存MinSize字节的内容再决定
+		if w.release = X.HoldWriteHeader(w.resp); w.release != nil {
+			w.pending = true
+			w.origin = w.resp.Writer()
+			w.resp.SetWriter(w)
+			return
+		}
+	}
+	w.start()
+	w.origin = w.resp.Writer()
+	w.resp.SetWriter(w)
+}
+
+// 设置压缩后的响应头
+func (w *writer) start() {
+	header := w.resp.Header()
+	header.Set(`Content-Encoding`, w.encoding)
+	header.Del(`Content-Length`)
+	if etag := header.Get(`ETag`); strings.HasPrefix(etag, `"`) {
+		//压缩后的内容与原内容不再逐字节相同，强ETag改为弱ETag
+		header.Set(`ETag`, `W/`+etag)
+	}
+	w.on = true
+}
+
+// 结束缓存，发送推迟的响应头和缓存的内容。on为false时不压缩
+func (w *writer) flush(on bool) error {
+	w.pending = false
+	if on {
+		w.start()
+	} else {
+		w.resp.Header().Set(`Content-Length`, strconv.Itoa(len(w.buf)))
+		w.resp.SetWriter(w.origin)
+	}
+	w.release()
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.resp.Writer().Write(buf)
+	return err
+}
+
+func (w *writer) Write(b []byte) (int, error) {
+	if w.pending {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.z.MinSize {
+			if err := w.flush(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.w == nil {
+		enc, err := getEncoder(w.encoding).fn(w.origin, w.z.Level)
+		if err != nil {
+			return 0, err
+		}
+		w.w = enc
+	}
+	return w.w.Write(b)
+}
+
+// 结束压缩。之后才发送的响应头(如处理函数返回错误后输出的错误页面)不再压缩
+func (w *writer) close() error {
+	w.closed = true
+	if w.pending {
+		//内容不足MinSize字节，不压缩
+		if err := w.flush(false); err != nil {
+			return err
+		}
+	}
+	if !w.on {
+		return nil
+	}
+	if w.w == nil {
+		//已发送Content-Encoding但没有输出内容，输出空的压缩数据
+		if _, err := w.Write(nil); err != nil {
+			return err
+		}
+	}
+	return w.w.Close()
+}
+
+// Skip 使当前请求的响应不再被中间件压缩，用于输出已经压缩好的内容。须在输出内容之前调用
+func Skip(c echo.Context) {
+	w, ok := c.Get(writerKey).(*writer)
+	if !ok || w.on {
+		return
+	}
+	w.skipped = true
+}
+
+func (z *Compress) Middleware() echo.MiddlewareFunc {
+	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			req := c.Request()
+			resp := c.Response()
+			if req.Method() == echo.HEAD || req.Header().Get(`Range`) != `` {
+				return h.Handle(c)
+			}
+			file, fi := z.staticFile(req.URL().Path())
+			if fi != nil && fi.Size() < int64(z.MinSize) {
+				return h.Handle(c)
+			}
+			encoding := Negotiate(req.Header().Get(`Accept-Encoding`), z.EncodingList())
+			if fi != nil && encoding != `` {
+				X.AddVary(resp.Header(), `Accept-Encoding`)
+				if served, err := z.servePrecompressed(c, file, fi, encoding); served {
+					return err
+				}
+			}
+			//在发送响应头时根据最终的响应头决定是否压缩，响应没有被webx.WrapResponse包装时不压缩
+			w := &writer{z: z, resp: resp, urlPath: req.URL().Path(), encoding: encoding}
+			if !X.BeforeWriteHeader(resp, w.decide) {
+				return h.Handle(c)
+			}
+			c.Set(writerKey, w)
+			err := h.Handle(c)
+			if e := w.close(); err == nil {
+				err = e
+			}
+			return err
+		})
+	})
+}
+
+// 查找网址对应的StaticDirs中的文件
+func (z *Compress) staticFile(urlPath string) (string, os.FileInfo) {
+	for prefix, dir := range z.StaticDirs {
+		if !strings.HasPrefix(urlPath, prefix) {
+			continue
+		}
+		file := filepath.Join(dir, filepath.FromSlash(path.Clean(`/`+strings.TrimPrefix(urlPath, prefix))))
+		fi, err := os.Stat(file)
+		if err != nil || fi.IsDir() {
+			return ``, nil
+		}
+		return file, fi
+	}
+	return ``, nil
+}
+
+// 输出静态文件的预压缩版本(如：app.js.gz)，预压缩文件不存在或比原文件旧时返回false
+func (z *Compress) servePrecompressed(c echo.Context, file string, fi os.FileInfo, encoding string) (bool, error) {
+	ext := Ext(encoding)
+	if ext == `` {
+		return false, nil
+	}
+	pfi, err := os.Stat(file + ext)
+	if err != nil || pfi.ModTime().Before(fi.ModTime()) {
+		return false, nil
+	}
+	ctx := X.X(c)
+	header := c.Response().Header()
+	header.Set(`Content-Encoding`, encoding)
+	etag := `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 36) + `-` + strconv.FormatInt(fi.Size(), 36) + `-` + encoding + `"`
+	if ctx.CheckConditional(etag, fi.ModTime()) {
+		return true, nil
+	}
+	b, err := ioutil.ReadFile(file + ext)
+	if err != nil {
+		header.Del(`Content-Encoding`)
+		return false, nil
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(file))
+	if contentType == `` {
+		contentType = `application/octet-stream`
+	}
+	return true, ctx.Blob(http.StatusOK, contentType, b)
+}
@@ -0,0 +1,205 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/webx-top/echo"
+	"github.com/webx-top/echo/engine"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/testutil"
+)
+
+// 使用压缩中间件处理请求，返回记录实际发送的响应的ResponseRecorder
+func serve(path string, acceptEncoding string, wrap bool, h echo.HandlerFunc) (*httptest.ResponseRecorder, error) {
+	req := testutil.NewRequest(`GET`, path, nil)
+	if acceptEncoding != `` {
+		req.Header.Set(`Accept-Encoding`, acceptEncoding)
+	}
+	c, rec := testutil.NewContext(req)
+	if wrap {
+		c.Reset(c.Request(), X.WrapResponse(c.Response()))
+	}
+	err := New().Middleware()(h).Handle(c)
+	return rec, err
+}
+
+// 输出指定响应头、状态码和内容的处理函数
+func output(header map[string]string, code int, body []byte) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		for k, v := range header {
+			c.Response().Header().Set(k, v)
+		}
+		c.Response().WriteHeader(code)
+		if body != nil {
+			c.Response().Write(body)
+		}
+		return nil
+	}
+}
+
+func gunzip(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+var page = []byte(strings.Repeat(`<p>webx</p>`, 200))
+
+func TestMiddleware(t *testing.T) {
+	header := map[string]string{
+		`Content-Type`:   `text/html; charset=utf-8`,
+		`Content-Length`: strconv.Itoa(len(page)),
+		`ETag`:           `"abc"`,
+	}
+	rec, err := serve(`/`, `gzip`, true, output(header, http.StatusOK, page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Result().Header.Get(`Content-Encoding`) != `gzip` || rec.Result().Header.Get(`Content-Length`) != `` {
+		t.Fatalf("headers: %v", rec.Result().Header)
+	}
+	if rec.Result().Header.Get(`ETag`) != `W/"abc"` {
+		t.Errorf("strong ETag of compressed content: %q", rec.Result().Header.Get(`ETag`))
+	}
+	if rec.Result().Header.Get(`Vary`) != `Accept-Encoding` {
+		t.Errorf("Vary: %q", rec.Result().Header.Get(`Vary`))
+	}
+	if b, err := gunzip(rec.Body.Bytes()); err != nil || !bytes.Equal(b, page) {
+		t.Errorf("body does not decode to the page: %v", err)
+	}
+}
+
+func TestMiddlewarePassThrough(t *testing.T) {
+	html := `text/html; charset=utf-8`
+	tests := []struct {
+		name   string
+		path   string
+		accept string
+		wrap   bool
+		header map[string]string
+		code   int
+		vary   bool
+	}{
+		{`compressed type`, `/`, `gzip`, true, map[string]string{`Content-Type`: `image/png`}, http.StatusOK, false},
+		{`unknown type`, `/data`, `gzip`, true, nil, http.StatusOK, false},
+		{`small content`, `/`, `gzip`, true, map[string]string{`Content-Type`: html, `Content-Length`: `100`}, http.StatusOK, true},
+		{`encoded by handler`, `/`, `gzip`, true, map[string]string{`Content-Type`: html, `Content-Encoding`: `br`}, http.StatusOK, true},
+		{`no accept-encoding`, `/`, ``, true, map[string]string{`Content-Type`: html}, http.StatusOK, true},
+		{`no content`, `/`, `gzip`, true, map[string]string{`Content-Type`: html}, http.StatusNoContent, true},
+		{`not modified`, `/app.js`, `gzip`, true, nil, http.StatusNotModified, true},
+		{`unwrapped response`, `/`, `gzip`, false, map[string]string{`Content-Type`: html}, http.StatusOK, false},
+	}
+	for _, test := range tests {
+		var body []byte
+		if test.code == http.StatusOK {
+			body = page
+		}
+		rec, err := serve(test.path, test.accept, test.wrap, output(test.header, test.code, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enc := rec.Result().Header.Get(`Content-Encoding`); enc != test.header[`Content-Encoding`] {
+			t.Errorf("%v: Content-Encoding = %q", test.name, enc)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), body) {
+			t.Errorf("%v: body is changed", test.name)
+		}
+		if vary := rec.Result().Header.Get(`Vary`) == `Accept-Encoding`; vary != test.vary {
+			t.Errorf("%v: Vary = %q", test.name, rec.Result().Header.Get(`Vary`))
+		}
+	}
+}
+
+func TestMiddlewareEmptyBody(t *testing.T) {
+	header := map[string]string{`Content-Type`: `text/html`, `Content-Length`: strconv.Itoa(len(page))}
+	rec, err := serve(`/`, `gzip`, true, output(header, http.StatusOK, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Result().Header.Get(`Content-Encoding`) != `gzip` {
+		t.Fatalf("headers: %v", rec.Result().Header)
+	}
+	if b, err := gunzip(rec.Body.Bytes()); err != nil || len(b) != 0 {
+		t.Errorf("empty body is not a valid gzip stream: %v", err)
+	}
+}
+
+// 没有Content-Length时缓存MinSize字节的内容再决定是否压缩
+func TestMiddlewareBuffer(t *testing.T) {
+	header := map[string]string{`Content-Type`: `text/html`}
+	for _, body := range [][]byte{nil, []byte(`<p>webx</p>`)} {
+		rec, err := serve(`/`, `gzip`, true, output(header, http.StatusCreated, body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := rec.Result().Header
+		if h.Get(`Content-Encoding`) != `` || h.Get(`Content-Length`) != strconv.Itoa(len(body)) {
+			t.Errorf("small content is compressed: %v", h)
+		}
+		if rec.Code != http.StatusCreated || !bytes.Equal(rec.Body.Bytes(), body) {
+			t.Errorf("small content: %d %q", rec.Code, rec.Body.String())
+		}
+	}
+	rec, err := serve(`/`, `gzip`, true, func(c echo.Context) error {
+		c.Response().Header().Set(`Content-Type`, `text/html`)
+		c.Response().WriteHeader(http.StatusCreated)
+		for i := 0; i < len(page); i += 100 {
+			c.Response().Write(page[i : i+100])
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusCreated || rec.Result().Header.Get(`Content-Encoding`) != `gzip` {
+		t.Fatalf("large content: %d %v", rec.Code, rec.Result().Header)
+	}
+	if b, err := gunzip(rec.Body.Bytes()); err != nil || !bytes.Equal(b, page) {
+		t.Errorf("body does not decode to the page: %v", err)
+	}
+}
+
+func TestMiddlewareError(t *testing.T) {
+	var res engine.Response
+	rec, err := serve(`/`, `gzip`, true, func(c echo.Context) error {
+		res = c.Response()
+		return errors.New(`failed`)
+	})
+	if err == nil {
+		t.Fatal("error is lost")
+	}
+	//中间件返回之后才输出的错误页面不压缩
+	res.Header().Set(`Content-Type`, `text/html`)
+	res.WriteHeader(http.StatusInternalServerError)
+	res.Write(page)
+	if rec.Result().Header.Get(`Content-Encoding`) != `` || !bytes.Equal(rec.Body.Bytes(), page) {
+		t.Errorf("error page is compressed: %v", rec.Result().Header)
+	}
+}
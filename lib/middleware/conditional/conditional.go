@@ -0,0 +1,58 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package conditional
+
+import (
+	"time"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+)
+
+// New 创建条件请求中间件。validator在处理请求之前返回资源当前的ETag和修改时间，
+// 可由数据库中的版本号或更新时间得到，不必生成响应内容
+func New(validator func(c *X.Context) (etag string, modTime time.Time, err error)) *Conditional {
+	return &Conditional{Validator: validator}
+}
+
+// Conditional 在处理请求之前判断条件请求：客户端缓存仍然有效时直接输出304，
+// If-Match或If-Unmodified-Since不成立时输出412(可避免并发修改时覆盖他人的修改)。
+// 已生成内容的处理函数也可以直接调用Context.CheckConditional
+type Conditional struct {
+	Validator    func(c *X.Context) (etag string, modTime time.Time, err error)
+	CacheControl string //Cache-Control响应头，如：private, no-cache。为空时不设置
+}
+
+func (d *Conditional) Middleware() echo.MiddlewareFunc {
+	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			ctx := X.X(c)
+			etag, modTime, err := d.Validator(ctx)
+			if err != nil {
+				return err
+			}
+			if d.CacheControl != `` {
+				c.Response().Header().Set(`Cache-Control`, d.CacheControl)
+			}
+			if (etag != `` || !modTime.IsZero()) && ctx.CheckConditional(etag, modTime) {
+				return nil
+			}
+			return h.Handle(c)
+		})
+	})
+}
@@ -0,0 +1,177 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cors
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+)
+
+// Policy 是跨域资源共享策略
+type Policy struct {
+	//允许的来源：完整的来源(https://app.example.com)、"*"(任意来源)或带通配符的子域名(https://*.example.com)
+	AllowOrigins []string
+	//允许的来源(正则表达式)
+	AllowOriginRegexps []*regexp.Regexp
+	//允许的请求方式，为空时使用路由已注册的请求方式
+	AllowMethods []string
+	//允许的请求头，为空时允许预检请求中的Access-Control-Request-Headers
+	AllowHeaders []string
+	//允许客户端读取的响应头
+	ExposeHeaders []string
+	//是否允许携带cookie等凭据，不能与AllowOrigins中的"*"同时使用
+	AllowCredentials bool
+	//预检结果的缓存时间(秒)，0表示不设置
+	MaxAge int
+}
+
+// AllowOrigin 是否允许来源origin
+func (p *Policy) AllowOrigin(origin string) bool {
+	for _, allowed := range p.AllowOrigins {
+		if allowed == `*` || strings.EqualFold(allowed, origin) {
+			return true
+		}
+		if i := strings.Index(allowed, `://*.`); i > 0 {
+			//https://*.example.com匹配https://a.example.com和https://a.b.example.com
+			scheme, domain := allowed[:i+3], allowed[i+4:]
+			if strings.HasPrefix(origin, scheme) && strings.HasSuffix(strings.ToLower(origin), strings.ToLower(domain)) && len(origin) > len(scheme)+len(domain) {
+				return true
+			}
+		}
+	}
+	for _, re := range p.AllowOriginRegexps {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *Policy) allowAny() bool {
+	for _, allowed := range p.AllowOrigins {
+		if allowed == `*` {
+			return true
+		}
+	}
+	return false
+}
+
+// 允许任意来源的策略同时允许凭据时，任何网站都可以携带用户的cookie读取响应
+func (p *Policy) check() {
+	if p != nil && p.AllowCredentials && p.allowAny() {
+		panic(`cors: AllowOrigins "*" can not be used with AllowCredentials`)
+	}
+}
+
+// New 创建App的CORS中间件，policy为默认策略，为nil时只有声明了策略的路由允许跨域。
+// 策略同时允许任意来源和凭据时panic
+func New(app *X.App, policy *Policy) *CORS {
+	policy.check()
+	return &CORS{
+		App:      app,
+		Default:  policy,
+		Policies: make(map[string]*Policy),
+	}
+}
+
+// CORS 根据路由声明的策略(cors标签或WrapperRoute.CORS)或默认策略处理跨域请求，
+// 并根据路由已注册的请求方式自动应答预检请求
+type CORS struct {
+	App      *X.App
+	Default  *Policy
+	Policies map[string]*Policy
+}
+
+// Add 添加命名策略，供路由声明使用。策略同时允许任意来源和凭据时panic
+func (c *CORS) Add(name string, policy *Policy) *CORS {
+	policy.check()
+	c.Policies[name] = policy
+	return c
+}
+
+// Policy 返回请求网址path使用的策略及路由已注册的请求方式，不允许跨域时策略为nil
+func (c *CORS) Policy(path string) (*Policy, []string) {
+	pattern, methods := c.App.MatchRoute(path)
+	if pattern == `` {
+		return c.Default, nil
+	}
+	if u := c.App.RouteUrl(pattern); u != nil && u.CORS != `` {
+		return c.Policies[u.CORS], methods
+	}
+	return c.Default, methods
+}
+
+// Middleware 返回CORS中间件，应在App中使用：app.Use(cors.New(app, policy).Middleware())
+func (c *CORS) Middleware() echo.MiddlewareFunc {
+	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
+		return echo.HandlerFunc(func(ctx echo.Context) error {
+			origin := ctx.Request().Header().Get(`Origin`)
+			if origin == `` {
+				return h.Handle(ctx)
+			}
+			header := ctx.Response().Header()
+			X.AddVary(header, `Origin`)
+			policy, methods := c.Policy(ctx.Request().URL().Path())
+			preflight := ctx.Request().Method() == echo.OPTIONS && ctx.Request().Header().Get(`Access-Control-Request-Method`) != ``
+			if policy == nil || !policy.AllowOrigin(origin) {
+				if preflight {
+					return ctx.NoContent(http.StatusForbidden)
+				}
+				return h.Handle(ctx)
+			}
+			if policy.allowAny() {
+				//允许任意来源时不反射请求的来源，也不允许凭据
+				header.Set(`Access-Control-Allow-Origin`, `*`)
+			} else {
+				header.Set(`Access-Control-Allow-Origin`, origin)
+				if policy.AllowCredentials {
+					header.Set(`Access-Control-Allow-Credentials`, `true`)
+				}
+			}
+			if !preflight {
+				if len(policy.ExposeHeaders) > 0 {
+					header.Set(`Access-Control-Expose-Headers`, strings.Join(policy.ExposeHeaders, `, `))
+				}
+				return h.Handle(ctx)
+			}
+			if len(methods) == 0 && len(policy.AllowMethods) == 0 {
+				//路径没有注册任何路由
+				return h.Handle(ctx)
+			}
+			X.AddVary(header, `Access-Control-Request-Method`, `Access-Control-Request-Headers`)
+			if len(policy.AllowMethods) > 0 {
+				methods = policy.AllowMethods
+			}
+			header.Set(`Access-Control-Allow-Methods`, strings.Join(methods, `, `))
+			if len(policy.AllowHeaders) > 0 {
+				header.Set(`Access-Control-Allow-Headers`, strings.Join(policy.AllowHeaders, `, `))
+			} else if requested := ctx.Request().Header().Get(`Access-Control-Request-Headers`); requested != `` {
+				header.Set(`Access-Control-Allow-Headers`, requested)
+			}
+			if policy.MaxAge > 0 {
+				header.Set(`Access-Control-Max-Age`, strconv.Itoa(policy.MaxAge))
+			}
+			return ctx.NoContent(http.StatusNoContent)
+		})
+	})
+}
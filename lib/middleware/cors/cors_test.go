@@ -0,0 +1,123 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/testutil"
+)
+
+func TestAllowOrigin(t *testing.T) {
+	p := &Policy{AllowOrigins: []string{`https://app.example.com`, `https://*.example.org`}}
+	for origin, want := range map[string]bool{
+		`https://app.example.com`:   true,
+		`HTTPS://APP.EXAMPLE.COM`:   true,
+		`http://app.example.com`:    false,
+		`https://a.example.org`:     true,
+		`https://a.b.example.org`:   true,
+		`https://example.org`:       false,
+		`https://evil-example.org`:  false,
+		`https://app.example.com.a`: false,
+	} {
+		if got := p.AllowOrigin(origin); got != want {
+			t.Errorf("AllowOrigin(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func mustPanic(t *testing.T, name string, fn func()) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%v: no panic", name)
+		}
+	}()
+	fn()
+}
+
+func TestAnyOriginWithCredentials(t *testing.T) {
+	policy := &Policy{AllowOrigins: []string{`*`}, AllowCredentials: true}
+	mustPanic(t, `New`, func() { New(nil, policy) })
+	mustPanic(t, `Add`, func() { New(nil, nil).Add(`api`, policy) })
+	New(nil, &Policy{AllowOrigins: []string{`*`}}).Add(`api`, &Policy{AllowOrigins: []string{`https://*.example.com`}, AllowCredentials: true})
+}
+
+// 发送预检请求，返回记录响应的ResponseRecorder及是否调用了后续处理函数
+func preflight(c *CORS, path string, origin string) (*httptest.ResponseRecorder, bool) {
+	req := testutil.NewRequest(echo.OPTIONS, path, nil)
+	req.Header.Set(`Origin`, origin)
+	req.Header.Set(`Access-Control-Request-Method`, echo.PUT)
+	ctx, rec := testutil.NewContext(req)
+	var called bool
+	c.Middleware()(echo.HandlerFunc(func(echo.Context) error {
+		called = true
+		return nil
+	})).Handle(&X.Context{Context: ctx, Server: c.App.Server, App: c.App})
+	return rec, called
+}
+
+func TestPreflight(t *testing.T) {
+	s := X.NewServer(`cors_test`)
+	app := s.NewApp(`api`)
+	h := func(*X.Context) error { return nil }
+	app.Route(`/user/:id`, h, echo.GET, echo.PUT).CORS(`public`)
+	app.R(`/private`, h, echo.POST)
+	c := New(app, nil).Add(`public`, &Policy{AllowOrigins: []string{`https://app.example.com`}, MaxAge: 600})
+
+	rec, called := preflight(c, `/api/user/1`, `https://app.example.com`)
+	if called || rec.Code != http.StatusNoContent {
+		t.Fatalf("allowed preflight: called=%v code=%d", called, rec.Code)
+	}
+	header := rec.Result().Header
+	if v := header.Get(`Access-Control-Allow-Methods`); v != `GET, PUT` {
+		t.Errorf("Access-Control-Allow-Methods: %q", v)
+	}
+	if v := header.Get(`Access-Control-Allow-Origin`); v != `https://app.example.com` {
+		t.Errorf("Access-Control-Allow-Origin: %q", v)
+	}
+	if v := header.Get(`Access-Control-Max-Age`); v != `600` {
+		t.Errorf("Access-Control-Max-Age: %q", v)
+	}
+	if v := header[`Vary`]; len(v) == 0 || v[0] != `Origin, Access-Control-Request-Method, Access-Control-Request-Headers` {
+		t.Errorf("Vary: %q", v)
+	}
+
+	for _, test := range []struct {
+		name   string
+		path   string
+		origin string
+	}{
+		{`disallowed origin`, `/api/user/1`, `https://evil.example.com`},
+		{`route without policy`, `/api/private`, `https://app.example.com`},
+	} {
+		rec, called := preflight(c, test.path, test.origin)
+		if called || rec.Code != http.StatusForbidden {
+			t.Errorf("%v: called=%v code=%d", test.name, called, rec.Code)
+		}
+		if rec.Result().Header.Get(`Access-Control-Allow-Origin`) != `` {
+			t.Errorf("%v: origin is allowed", test.name)
+		}
+		if rec.Result().Header.Get(`Vary`) != `Origin` {
+			t.Errorf("%v: Vary = %q", test.name, rec.Result().Header.Get(`Vary`))
+		}
+	}
+}
@@ -0,0 +1,211 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package jwt
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/webx-top/webx/lib/cachestore"
+)
+
+// ErrNoTokenID 令牌中没有jti，无法单独吊销
+var ErrNoTokenID = errors.New(`jwt: token has no jti`)
+
+// 令牌类型
+const (
+	AccessToken  = `access`
+	RefreshToken = `refresh`
+)
+
+// Claims 是令牌中的声明。Extra中是其它自定义声明
+type Claims struct {
+	ID        string                 `json:"jti,omitempty"`
+	Subject   string                 `json:"sub,omitempty"`
+	Issuer    string                 `json:"iss,omitempty"`
+	Audience  Audience               `json:"aud,omitempty"`
+	ExpiresAt int64                  `json:"exp,omitempty"`
+	NotBefore int64                  `json:"nbf,omitempty"`
+	IssuedAt  int64                  `json:"iat,omitempty"`
+	Type      string                 `json:"token_type,omitempty"` //access或refresh
+	Extra     map[string]interface{} `json:"-"`
+}
+
+// Map 转换为jwt-go使用的map，标准声明优先于Extra中的同名声明
+func (c *Claims) Map() (map[string]interface{}, error) {
+	m := make(map[string]interface{}, len(c.Extra)+8)
+	for k, v := range c.Extra {
+		m[k] = v
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var std map[string]interface{}
+	if err := json.Unmarshal(b, &std); err != nil {
+		return nil, err
+	}
+	for k, v := range std {
+		m[k] = v
+	}
+	return m, nil
+}
+
+var registeredClaims = map[string]bool{
+	`jti`: true, `sub`: true, `iss`: true, `aud`: true,
+	`exp`: true, `nbf`: true, `iat`: true, `token_type`: true,
+}
+
+// ClaimsFromMap 从jwt-go解析得到的map生成Claims
+func ClaimsFromMap(m map[string]interface{}) (*Claims, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	c := &Claims{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, err
+	}
+	for k, v := range m {
+		if registeredClaims[k] {
+			continue
+		}
+		if c.Extra == nil {
+			c.Extra = make(map[string]interface{})
+		}
+		c.Extra[k] = v
+	}
+	return c, nil
+}
+
+// Audience 是aud声明，JSON中可以是字符串或字符串数组
+type Audience []string
+
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	*a = Audience(list)
+	return nil
+}
+
+// Contains 是否包含aud
+func (a Audience) Contains(aud string) bool {
+	for _, v := range a {
+		if v == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// NewRevocation 创建使用cachestore保存的令牌吊销列表
+func NewRevocation(c cachestore.Cache) *Revocation {
+	return &Revocation{Cache: c, Prefix: `jwt_revoked_`}
+}
+
+// Revocation 是令牌吊销列表。
+// 吊销单个令牌时按jti保存到令牌过期为止；吊销用户的全部令牌时记录吊销时间，此前签发的令牌均无效
+type Revocation struct {
+	Cache  cachestore.Cache
+	Prefix string
+}
+
+// Revoke 吊销令牌，令牌中没有jti时返回ErrNoTokenID
+func (r *Revocation) Revoke(c *Claims) error {
+	if c.ID == `` {
+		return ErrNoTokenID
+	}
+	ttl, ok := r.ttl(c)
+	if !ok {
+		return nil
+	}
+	return r.Cache.Set(r.Prefix+c.ID, int64(1), ttl)
+}
+
+// Consume 原子地吊销令牌，返回的ok为true表示令牌此前未被吊销(由本次调用吊销)。
+// 用于保证刷新令牌在并发请求中也只能使用一次
+func (r *Revocation) Consume(c *Claims) (ok bool, err error) {
+	if c.ID == `` {
+		return false, ErrNoTokenID
+	}
+	ttl, valid := r.ttl(c)
+	if !valid {
+		return false, nil
+	}
+	key := r.Prefix + c.ID
+	n, err := r.Cache.Incr(key, 1)
+	if err != nil || n != 1 {
+		return false, err
+	}
+	//Incr不设置有效期，保存到令牌过期为止
+	return true, r.Cache.Set(key, n, ttl)
+}
+
+// 吊销记录的有效期：令牌过期后1秒。令牌已过期时ok为false
+func (r *Revocation) ttl(c *Claims) (ttl time.Duration, ok bool) {
+	if c.ExpiresAt > 0 {
+		ttl = time.Unix(c.ExpiresAt, 0).Sub(time.Now()) + time.Second
+		if ttl <= 0 {
+			return 0, false
+		}
+	}
+	return ttl, true
+}
+
+// RevokeSubject 吊销用户(sub)在此之前(含同一秒内)签发的全部令牌，ttl应不小于令牌的最长有效期
+func (r *Revocation) RevokeSubject(sub string, ttl time.Duration) error {
+	return r.Cache.Set(r.Prefix+`sub_`+sub, time.Now().Unix(), ttl)
+}
+
+// IsRevoked 令牌是否已被吊销
+func (r *Revocation) IsRevoked(c *Claims) (bool, error) {
+	if c.ID != `` {
+		ok, err := r.Cache.Has(r.Prefix + c.ID)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	if c.Subject == `` {
+		return false, nil
+	}
+	v, err := r.Cache.Get(r.Prefix + `sub_` + c.Subject)
+	if err == cachestore.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	//iat精确到秒，与吊销同一秒内签发的令牌也视为已吊销
+	revokedAt, _ := v.(int64)
+	return c.IssuedAt <= revokedAt, nil
+}
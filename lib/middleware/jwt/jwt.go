@@ -18,7 +18,11 @@
 package jwt
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -27,6 +31,16 @@ import (
 	"github.com/webx-top/echo/engine"
 )
 
+var (
+	ErrInvalidIssuer    = errors.New(`jwt: invalid issuer`)
+	ErrInvalidAudience  = errors.New(`jwt: invalid audience`)
+	ErrInvalidTokenType = errors.New(`jwt: invalid token type`)
+	ErrTokenExpired     = errors.New(`jwt: token is expired`)
+	ErrTokenNotValidYet = errors.New(`jwt: token is not valid yet`)
+	ErrTokenRevoked     = errors.New(`jwt: token has been revoked`)
+)
+
+// New 创建使用secret以HS256签名的JWT。需要非对称算法或密钥轮换时设置Keys
 func New(secret string) *JWT {
 	return &JWT{
 		Secret: secret,
@@ -34,12 +48,22 @@ func New(secret string) *JWT {
 			ignore, _ := c.Get(`webx:ignoreJwt`).(bool)
 			return !ignore
 		},
+		Expire:        72 * time.Hour,
+		RefreshExpire: 30 * 24 * time.Hour,
 	}
 }
 
 type JWT struct {
-	Secret string
+	Secret string //Keys为nil时用于HS256签名
 	CondFn func(echo.Context) bool
+
+	Keys          *KeySet       //签名和验证使用的密钥集，根据令牌头中的kid选择验证密钥
+	Issuer        string        //签发时写入iss，验证时要求iss与之相同
+	Audience      []string      //签发时写入aud，验证时要求aud包含Audience中的一个
+	Expire        time.Duration //访问令牌有效期
+	RefreshExpire time.Duration //刷新令牌有效期
+	Leeway        time.Duration //验证exp、nbf时允许的时钟误差
+	Revocation    *Revocation   //令牌吊销列表，为nil时不检查
 }
 
 func (j *JWT) Validate() echo.MiddlewareFunc {
@@ -48,23 +72,20 @@ func (j *JWT) Validate() echo.MiddlewareFunc {
 			if j.CondFn != nil && j.CondFn(c) == false {
 				return h.Handle(c)
 			}
-			/*//Test
-			tokenString, err := j.Response(map[string]interface{}{"uid": "1", "username": "admin"})
-			if err == nil {
-				println("jwt token:", tokenString)
+			tokenString := TokenFromRequest(c.Request())
+			if tokenString == `` {
+				return echo.NewHTTPError(http.StatusUnauthorized, jwt.ErrNoTokenInRequest.Error())
 			}
-			//*/
-			token, err := ParseFromRequest(c.Request(), func(token *jwt.Token) (interface{}, error) {
-				b := ([]byte(j.Secret))
-				return b, nil
-			})
+			claims, err := j.Parse(tokenString, AccessToken)
 			if err != nil {
-				return err
+				return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 			}
-			if !token.Valid {
-				return errors.New(`Incorrect signature.`)
+			m, err := claims.Map()
+			if err != nil {
+				return err
 			}
-			c.Set(`webx:jwtClaims`, token.Claims)
+			c.Set(`webx:jwtClaims`, m)
+			c.Set(`webx:jwtTypedClaims`, claims)
 			return h.Handle(c)
 		})
 	})
@@ -75,6 +96,12 @@ func (j *JWT) Claims(c echo.Context) map[string]interface{} {
 	return r
 }
 
+// TypedClaims 返回Validate验证通过的令牌声明
+func (j *JWT) TypedClaims(c echo.Context) *Claims {
+	r, _ := c.Get(`webx:jwtTypedClaims`).(*Claims)
+	return r
+}
+
 func (j *JWT) Ignore(on bool, c echo.Context) {
 	c.Set(`webx:ignoreJwt`, on)
 }
@@ -85,32 +112,223 @@ func (j *JWT) Ignore(on bool, c echo.Context) {
 用法二：发送post或get参数“access_token”，值设为：tokenString的值
 */
 func (j *JWT) Response(values map[string]interface{}) (tokenString string, err error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	// Headers
-	token.Header["alg"] = "HS256"
-	token.Header["typ"] = "JWT"
-	// Claims
-	token.Claims = values
-	token.Claims["exp"] = time.Now().Add(time.Hour * 72).Unix()
-	tokenString, err = token.SignedString([]byte(j.Secret))
+	claims, err := ClaimsFromMap(values)
+	if err != nil {
+		return
+	}
+	return j.Issue(claims)
+}
+
+// Issue 签发访问令牌。claims中未设置的jti、iss、aud、iat、exp使用JWT的配置
+func (j *JWT) Issue(claims *Claims) (string, error) {
+	return j.sign(claims, AccessToken, j.Expire)
+}
+
+// IssuePair 签发访问令牌和刷新令牌
+func (j *JWT) IssuePair(claims *Claims) (access string, refresh string, err error) {
+	access, err = j.sign(claims, AccessToken, j.Expire)
+	if err != nil {
+		return
+	}
+	rc := &Claims{
+		Subject:  claims.Subject,
+		Issuer:   claims.Issuer,
+		Audience: claims.Audience,
+		Extra:    claims.Extra,
+	}
+	refresh, err = j.sign(rc, RefreshToken, j.RefreshExpire)
 	return
 }
 
-func ParseFromRequest(req engine.Request, keyFunc jwt.Keyfunc) (token *jwt.Token, err error) {
+// Refresh 使用刷新令牌换取新的访问令牌和刷新令牌。
+// 设置了Revocation时，旧的刷新令牌会被原子地吊销，并发请求中也只有一个能够成功
+func (j *JWT) Refresh(refreshToken string) (access string, refresh string, err error) {
+	claims, err := j.Parse(refreshToken, RefreshToken)
+	if err != nil {
+		return
+	}
+	if j.Revocation != nil {
+		var ok bool
+		ok, err = j.Revocation.Consume(claims)
+		if err != nil {
+			return
+		}
+		if !ok {
+			err = ErrTokenRevoked
+			return
+		}
+	}
+	next := &Claims{
+		Subject:  claims.Subject,
+		Issuer:   claims.Issuer,
+		Audience: claims.Audience,
+		Extra:    claims.Extra,
+	}
+	return j.IssuePair(next)
+}
+
+// Revoke 吊销令牌(须设置Revocation)
+func (j *JWT) Revoke(tokenString string) error {
+	if j.Revocation == nil {
+		return errors.New(`jwt: Revocation is not set`)
+	}
+	claims, err := j.parse(tokenString)
+	if err != nil {
+		return err
+	}
+	return j.Revocation.Revoke(claims)
+}
+
+func (j *JWT) sign(claims *Claims, typ string, expire time.Duration) (string, error) {
+	key, err := j.signingKey()
+	if err != nil {
+		return ``, err
+	}
+	c := *claims
+	now := time.Now()
+	c.Type = typ
+	if c.ID == `` || typ == RefreshToken {
+		c.ID = newID()
+	}
+	if c.Issuer == `` {
+		c.Issuer = j.Issuer
+	}
+	if len(c.Audience) == 0 {
+		c.Audience = j.Audience
+	}
+	if c.IssuedAt == 0 {
+		c.IssuedAt = now.Unix()
+	}
+	if c.ExpiresAt == 0 && expire > 0 {
+		c.ExpiresAt = now.Add(expire).Unix()
+	}
+	m, err := c.Map()
+	if err != nil {
+		return ``, err
+	}
+	token := jwt.New(key.Method)
+	if key.ID != `` {
+		token.Header["kid"] = key.ID
+	}
+	token.Claims = m
+	return token.SignedString(key.Private)
+}
 
+func (j *JWT) signingKey() (*Key, error) {
+	if j.Keys != nil {
+		return j.Keys.Current()
+	}
+	if j.Secret == `` {
+		return nil, ErrNoSigningKey
+	}
+	return NewHMACKey(``, []byte(j.Secret)), nil
+}
+
+// 根据令牌头中的kid选择验证密钥，并要求alg与密钥的算法一致，以免算法混淆攻击
+func (j *JWT) keyFunc(token *jwt.Token) (interface{}, error) {
+	var key *Key
+	if j.Keys != nil {
+		kid, _ := token.Header["kid"].(string)
+		var err error
+		key, err = j.Keys.Get(kid)
+		if err != nil {
+			return nil, err
+		}
+	} else if j.Secret != `` {
+		key = NewHMACKey(``, []byte(j.Secret))
+	} else {
+		return nil, ErrNoSigningKey
+	}
+	if token.Method == nil || token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf(`jwt: unexpected signing method %v`, token.Header["alg"])
+	}
+	return key.Public, nil
+}
+
+// Parse 验证令牌签名、有效期、iss、aud、令牌类型(typ为空时不检查)及是否已被吊销
+func (j *JWT) Parse(tokenString string, typ string) (*Claims, error) {
+	claims, err := j.parse(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if claims.ExpiresAt > 0 && now.After(time.Unix(claims.ExpiresAt, 0).Add(j.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore > 0 && now.Add(j.Leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, ErrTokenNotValidYet
+	}
+	if j.Issuer != `` && claims.Issuer != j.Issuer {
+		return nil, ErrInvalidIssuer
+	}
+	if len(j.Audience) > 0 {
+		valid := false
+		for _, aud := range j.Audience {
+			if claims.Audience.Contains(aud) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, ErrInvalidAudience
+		}
+	}
+	if typ != `` {
+		tokenType := claims.Type
+		if tokenType == `` {
+			tokenType = AccessToken
+		}
+		if tokenType != typ {
+			return nil, ErrInvalidTokenType
+		}
+	}
+	if j.Revocation != nil {
+		revoked, err := j.Revocation.IsRevoked(claims)
+		if err != nil {
+			return nil, err
+		}
+		if revoked {
+			return nil, ErrTokenRevoked
+		}
+	}
+	return claims, nil
+}
+
+// 验证签名并解析声明。exp、nbf由Parse按Leeway检查，这里忽略jwt-go的时间检查结果
+func (j *JWT) parse(tokenString string) (*Claims, error) {
+	token, err := jwt.Parse(tokenString, j.keyFunc)
+	if err != nil {
+		ve, ok := err.(*jwt.ValidationError)
+		if !ok || token == nil || ve.Errors&^(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
+			return nil, err
+		}
+	}
+	return ClaimsFromMap(token.Claims)
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// TokenFromRequest 从请求头Authorization(Bearer)或参数access_token中获取令牌
+func TokenFromRequest(req engine.Request) string {
 	// Look for an Authorization header
 	if ah := req.Header().Get("Authorization"); ah != "" {
 		// Should be a bearer token
 		if len(ah) > 6 && strings.ToUpper(ah[0:6]) == "BEARER" {
-			return jwt.Parse(ah[7:], keyFunc)
+			return strings.TrimSpace(ah[6:])
 		}
 	}
 
 	// Look for "access_token" parameter
-	if tokStr := req.FormValue("access_token"); tokStr != "" {
+	return req.FormValue("access_token")
+}
+
+func ParseFromRequest(req engine.Request, keyFunc jwt.Keyfunc) (token *jwt.Token, err error) {
+	if tokStr := TokenFromRequest(req); tokStr != "" {
 		return jwt.Parse(tokStr, keyFunc)
 	}
-
 	return nil, jwt.ErrNoTokenInRequest
-
 }
@@ -0,0 +1,180 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package jwt
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webx-top/webx/lib/cachestore"
+)
+
+func TestIssueParse(t *testing.T) {
+	j := New(`secret`)
+	j.Issuer = `webx`
+	j.Audience = []string{`api`}
+	token, err := j.Issue(&Claims{Subject: `1`, Extra: map[string]interface{}{`name`: `admin`}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := j.Parse(token, AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != `1` || claims.Issuer != `webx` || !claims.Audience.Contains(`api`) || claims.Extra[`name`] != `admin` {
+		t.Errorf(`claims = %+v`, claims)
+	}
+	if claims.ID == `` || claims.ExpiresAt == 0 {
+		t.Errorf(`jti or exp is not set: %+v`, claims)
+	}
+	if _, err = j.Parse(token, RefreshToken); err != ErrInvalidTokenType {
+		t.Errorf(`Parse as refresh token error = %v`, err)
+	}
+	if _, err = New(`other`).Parse(token, ``); err == nil {
+		t.Error(`token signed with another secret should be invalid`)
+	}
+
+	other := New(`secret`)
+	other.Issuer = `other`
+	if _, err = other.Parse(token, ``); err != ErrInvalidIssuer {
+		t.Errorf(`Parse with another issuer error = %v`, err)
+	}
+	other = New(`secret`)
+	other.Audience = []string{`web`}
+	if _, err = other.Parse(token, ``); err != ErrInvalidAudience {
+		t.Errorf(`Parse with another audience error = %v`, err)
+	}
+}
+
+func TestParseExpired(t *testing.T) {
+	j := New(`secret`)
+	token, err := j.Issue(&Claims{Subject: `1`, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = j.Parse(token, ``); err != ErrTokenExpired {
+		t.Errorf(`Parse(expired) error = %v`, err)
+	}
+	j.Leeway = 2 * time.Minute
+	if _, err = j.Parse(token, ``); err != nil {
+		t.Errorf(`Parse(expired) within leeway error = %v`, err)
+	}
+	token, _ = j.Issue(&Claims{Subject: `1`, NotBefore: time.Now().Add(time.Hour).Unix()})
+	if _, err = j.Parse(token, ``); err != ErrTokenNotValidYet {
+		t.Errorf(`Parse(not valid yet) error = %v`, err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	j := New(`secret`)
+	j.Revocation = NewRevocation(cachestore.NewMemory(nil))
+	_, refresh, err := j.IssuePair(&Claims{Subject: `1`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//并发刷新时只有一个请求成功
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		success int
+		next    string
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			access, r, err := j.Refresh(refresh)
+			if err != nil {
+				if err != ErrTokenRevoked {
+					t.Errorf(`Refresh error = %v`, err)
+				}
+				return
+			}
+			if _, err = j.Parse(access, AccessToken); err != nil {
+				t.Errorf(`refreshed access token is invalid: %v`, err)
+			}
+			mutex.Lock()
+			success++
+			next = r
+			mutex.Unlock()
+		}()
+	}
+	wg.Wait()
+	if success != 1 {
+		t.Fatalf(`%d concurrent refreshes succeeded, want 1`, success)
+	}
+	if _, _, err = j.Refresh(refresh); err != ErrTokenRevoked {
+		t.Errorf(`reused refresh token error = %v`, err)
+	}
+	if _, _, err = j.Refresh(next); err != nil {
+		t.Errorf(`new refresh token error = %v`, err)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	j := New(`secret`)
+	if err := j.Revoke(`token`); err == nil {
+		t.Error(`Revoke without Revocation should fail`)
+	}
+	j.Revocation = NewRevocation(cachestore.NewMemory(nil))
+	token, _ := j.Issue(&Claims{Subject: `1`})
+	if err := j.Revoke(token); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Parse(token, ``); err != ErrTokenRevoked {
+		t.Errorf(`Parse(revoked) error = %v`, err)
+	}
+	if err := j.Revocation.Revoke(&Claims{Subject: `1`}); err != ErrNoTokenID {
+		t.Errorf(`Revoke without jti error = %v`, err)
+	}
+
+	//吊销用户之前签发的全部令牌
+	old, _ := j.Issue(&Claims{Subject: `2`, IssuedAt: time.Now().Add(-time.Minute).Unix()})
+	if err := j.Revocation.RevokeSubject(`2`, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Parse(old, ``); err != ErrTokenRevoked {
+		t.Errorf(`Parse(token issued before RevokeSubject) error = %v`, err)
+	}
+	same, _ := j.Issue(&Claims{Subject: `2`, IssuedAt: time.Now().Unix()})
+	if _, err := j.Parse(same, ``); err != ErrTokenRevoked {
+		t.Errorf(`Parse(token issued in the second of RevokeSubject) error = %v`, err)
+	}
+	other, _ := j.Issue(&Claims{Subject: `3`})
+	if _, err := j.Parse(other, ``); err != nil {
+		t.Errorf(`token of another subject error = %v`, err)
+	}
+}
+
+func TestParseWithoutKey(t *testing.T) {
+	//使用空密钥签名的令牌
+	signer := New(``)
+	signer.Keys = NewKeySet(NewHMACKey(``, nil))
+	token, err := signer.Issue(&Claims{Subject: `1`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := New(``)
+	if _, err := j.Parse(token, ``); err == nil {
+		t.Error(`token signed with an empty secret is accepted`)
+	}
+	if _, err := j.Issue(&Claims{Subject: `1`}); err != ErrNoSigningKey {
+		t.Errorf(`Issue without a signing key error = %v`, err)
+	}
+}
@@ -0,0 +1,222 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/webx-top/echo"
+)
+
+var (
+	ErrNoSigningKey = errors.New(`jwt: no signing key`)
+	ErrUnknownKey   = errors.New(`jwt: unknown key id`)
+)
+
+// Key 是签名或验证使用的密钥。Private为nil时只能用于验证
+type Key struct {
+	ID      string //kid
+	Method  jwt.SigningMethod
+	Private interface{} //HS256为[]byte，RS256为*rsa.PrivateKey，ES256为*ecdsa.PrivateKey，EdDSA为ed25519.PrivateKey
+	Public  interface{} //HS256为[]byte，RS256为*rsa.PublicKey，ES256为*ecdsa.PublicKey，EdDSA为ed25519.PublicKey
+}
+
+// NewHMACKey 创建HS256密钥，不会出现在JWKS中
+func NewHMACKey(kid string, secret []byte) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodHS256, Private: secret, Public: secret}
+}
+
+// NewRSAKey 创建RS256密钥
+func NewRSAKey(kid string, priv *rsa.PrivateKey) *Key {
+	return &Key{ID: kid, Method: jwt.SigningMethodRS256, Private: priv, Public: &priv.PublicKey}
+}
+
+// NewECDSAKey 创建ES256密钥，须使用P-256曲线
+func NewECDSAKey(kid string, priv *ecdsa.PrivateKey) (*Key, error) {
+	if priv.Curve != elliptic.P256() {
+		return nil, fmt.Errorf(`jwt: ES256 requires a P-256 key, got %s`, priv.Curve.Params().Name)
+	}
+	return &Key{ID: kid, Method: jwt.SigningMethodES256, Private: priv, Public: &priv.PublicKey}, nil
+}
+
+// NewEdDSAKey 创建EdDSA(Ed25519)密钥
+func NewEdDSAKey(kid string, priv ed25519.PrivateKey) *Key {
+	return &Key{ID: kid, Method: SigningMethodEdDSA, Private: priv, Public: priv.Public()}
+}
+
+// NewPublicKey 创建只用于验证的密钥，例如其它服务的公钥。
+// pub可以是*rsa.PublicKey(RS256)、*ecdsa.PublicKey(ES256)或ed25519.PublicKey(EdDSA)
+func NewPublicKey(kid string, pub interface{}) (*Key, error) {
+	key := &Key{ID: kid, Public: pub}
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		key.Method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf(`jwt: ES256 requires a P-256 key, got %s`, k.Curve.Params().Name)
+		}
+		key.Method = jwt.SigningMethodES256
+	case ed25519.PublicKey:
+		key.Method = SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf(`jwt: unsupported public key type %T`, pub)
+	}
+	return key, nil
+}
+
+// JWK 返回公钥的JWK表示(RFC 7517)。HS256等对称密钥返回nil
+func (k *Key) JWK() map[string]interface{} {
+	jwk := map[string]interface{}{
+		`kid`: k.ID,
+		`use`: `sig`,
+		`alg`: k.Method.Alg(),
+	}
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk[`kty`] = `RSA`
+		jwk[`n`] = encodeBase64(pub.N.Bytes())
+		jwk[`e`] = encodeBase64(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		jwk[`kty`] = `EC`
+		jwk[`crv`] = pub.Curve.Params().Name
+		jwk[`x`] = encodeBase64(padBytes(pub.X.Bytes(), size))
+		jwk[`y`] = encodeBase64(padBytes(pub.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		jwk[`kty`] = `OKP`
+		jwk[`crv`] = `Ed25519`
+		jwk[`x`] = encodeBase64(pub)
+	default:
+		return nil
+	}
+	return jwk
+}
+
+func encodeBase64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
+
+// NewKeySet 创建密钥集，第一个密钥用于签名
+func NewKeySet(keys ...*Key) *KeySet {
+	s := &KeySet{keys: make(map[string]*Key)}
+	for i, key := range keys {
+		s.Add(key, i == 0)
+	}
+	return s
+}
+
+// KeySet 是按kid索引的密钥集。
+// 轮换密钥时添加新密钥作为签名密钥，旧密钥保留到其签发的令牌全部过期后再移除
+type KeySet struct {
+	keys    map[string]*Key
+	order   []string
+	current string
+	mutex   sync.RWMutex
+}
+
+// Add 添加密钥，current为true时作为签名密钥
+func (s *KeySet) Add(key *Key, current bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.keys[key.ID]; !ok {
+		s.order = append(s.order, key.ID)
+	}
+	s.keys[key.ID] = key
+	if current {
+		s.current = key.ID
+	}
+}
+
+// Remove 移除密钥。不能移除当前签名密钥
+func (s *KeySet) Remove(kid string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if kid == s.current {
+		return fmt.Errorf(`jwt: can not remove the signing key %q`, kid)
+	}
+	delete(s.keys, kid)
+	for i, id := range s.order {
+		if id == kid {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// Current 返回签名密钥
+func (s *KeySet) Current() (*Key, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	key, ok := s.keys[s.current]
+	if !ok || key.Private == nil {
+		return nil, ErrNoSigningKey
+	}
+	return key, nil
+}
+
+// Get 根据kid获取密钥
+func (s *KeySet) Get(kid string) (*Key, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf(`%v: %q`, ErrUnknownKey, kid)
+	}
+	return key, nil
+}
+
+// JWKS 返回全部非对称密钥的公钥(RFC 7517 JWK Set)
+func (s *KeySet) JWKS() map[string]interface{} {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := []interface{}{}
+	for _, kid := range s.order {
+		if jwk := s.keys[kid].JWK(); jwk != nil {
+			keys = append(keys, jwk)
+		}
+	}
+	return map[string]interface{}{`keys`: keys}
+}
+
+// Handler 输出JWKS的处理函数，例如：e.Get(`/.well-known/jwks.json`, keySet.Handler())
+func (s *KeySet) Handler() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(`Cache-Control`, `public, max-age=300`)
+		return c.JSON(http.StatusOK, s.JWKS())
+	}
+}
@@ -1,9 +1,16 @@
 package remoteaddr
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/webx-top/webx"
+)
 
 // RemoteAddr http handler
 type RemoteAddr struct {
+	// Proxies are the trusted reverse proxies. Forwarding headers are only
+	// honored when the request comes directly from one of them.
+	Proxies *webx.Proxies
 }
 
 const (
@@ -13,9 +20,9 @@ const (
 	XOriginatingIP = "X-Originating-IP" // Defacto email header
 )
 
-// New creates a new RemoteAddr handler
-func New() *RemoteAddr {
-	r := &RemoteAddr{}
+// New creates a new RemoteAddr handler which trusts the given proxies
+func New(proxies *webx.Proxies) *RemoteAddr {
+	r := &RemoteAddr{Proxies: proxies}
 	return r
 }
 
@@ -38,28 +45,19 @@ func (ra *RemoteAddr) ServeHTTP(w http.ResponseWriter, r *http.Request, next htt
 	next(w, r)
 }
 
-// handleActualRequest applies a filterr function that attempts to source the
-// address of a remote client through potential network manipulation. Once
-// attached, it replaces the `RemoteAddr` so it can be treated as trusted.
+// handleActualRequest resolves the address of the remote client from the
+// Forwarded, X-Forwarded-For and X-Real-IP headers when the request comes from
+// a trusted proxy. Once attached, it replaces the `RemoteAddr` so it can be
+// treated as trusted.
 func (ra *RemoteAddr) handleActualRequest(w http.ResponseWriter, r *http.Request) {
-	var ipAddress string
-	var ipSources = []string{
-		r.Header.Get(TrueClientIP),
-		r.Header.Get(TrueRealIP),
-		r.Header.Get(XForwardedFor),
-		r.Header.Get(XOriginatingIP),
+	if ra.Proxies == nil {
+		return
 	}
-
-	// Iterate the ipSources to determine the valid address
-	for _, ip := range ipSources {
-		if ip != "" {
-			ipAddress = ip
-			break
-		}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
 	}
-
-	// Set the RemoteAddr to the determine IP address, if detected
-	if ipAddress != "" {
-		r.RemoteAddr = ipAddress
+	if ip := ra.Proxies.Resolve(r.RemoteAddr, r.Host, scheme, r.Header.Get).IP; ip != "" {
+		r.RemoteAddr = ip
 	}
 }
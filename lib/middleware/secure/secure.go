@@ -0,0 +1,131 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package secure
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+)
+
+// NonceKey 是当前请求的CSP nonce在Context中的键名
+const NonceKey = `webx:cspNonce`
+
+// New 创建使用常用安全设置的安全响应头中间件
+func New() *Secure {
+	return &Secure{
+		HSTSMaxAge:            365 * 24 * 3600,
+		HSTSIncludeSubdomains: true,
+		ContentTypeNosniff:    true,
+		ReferrerPolicy:        `strict-origin-when-cross-origin`,
+		FrameOptions:          `SAMEORIGIN`,
+		ContentSecurityPolicy: `default-src 'self'; script-src 'self' 'nonce-{nonce}'; style-src 'self' 'nonce-{nonce}'; object-src 'none'; base-uri 'self'; frame-ancestors 'self'`,
+	}
+}
+
+// Secure 为响应设置HSTS、X-Content-Type-Options、Referrer-Policy、X-Frame-Options和Content-Security-Policy。
+// 空值表示不设置相应的响应头
+type Secure struct {
+	//Strict-Transport-Security的max-age(秒)，仅在HTTPS请求时设置，0表示不设置
+	HSTSMaxAge            int
+	HSTSIncludeSubdomains bool
+	HSTSPreload           bool
+	//是否设置X-Content-Type-Options: nosniff
+	ContentTypeNosniff bool
+	//Referrer-Policy，如：no-referrer、same-origin、strict-origin-when-cross-origin
+	ReferrerPolicy string
+	//X-Frame-Options，如：DENY、SAMEORIGIN
+	FrameOptions string
+	//Content-Security-Policy。其中的{nonce}会被替换为每个请求随机生成的nonce
+	ContentSecurityPolicy string
+	//为true时使用Content-Security-Policy-Report-Only，只报告不拦截
+	ReportOnly bool
+	//每个请求生成nonce后调用，返回的函数会通过Context.SetFunc注册为模板函数。
+	//Server.Static创建的静态资源管理器的JsTag和CssTag已自动注册，此处用于其它tplfunc.Static或模板函数
+	NonceFuncs []func(nonce string) map[string]interface{}
+}
+
+// Nonce 返回当前请求的CSP nonce，未使用Secure中间件时返回空字符串
+func Nonce(c echo.Context) string {
+	nonce, _ := c.Get(NonceKey).(string)
+	return nonce
+}
+
+func (s *Secure) Middleware() echo.MiddlewareFunc {
+	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			header := c.Response().Header()
+			if s.HSTSMaxAge > 0 && X.X(c).Scheme() == `https` {
+				value := `max-age=` + strconv.Itoa(s.HSTSMaxAge)
+				if s.HSTSIncludeSubdomains {
+					value += `; includeSubDomains`
+				}
+				if s.HSTSPreload {
+					value += `; preload`
+				}
+				header.Set(`Strict-Transport-Security`, value)
+			}
+			if s.ContentTypeNosniff {
+				header.Set(`X-Content-Type-Options`, `nosniff`)
+			}
+			if s.ReferrerPolicy != `` {
+				header.Set(`Referrer-Policy`, s.ReferrerPolicy)
+			}
+			if s.FrameOptions != `` {
+				header.Set(`X-Frame-Options`, s.FrameOptions)
+			}
+			nonce, err := newNonce()
+			if err != nil {
+				return err
+			}
+			c.Set(NonceKey, nonce)
+			c.SetFunc(`CspNonce`, func() string {
+				return nonce
+			})
+			for name, fn := range X.X(c).Server.NonceFuncs(nonce) {
+				c.SetFunc(name, fn)
+			}
+			for _, funcs := range s.NonceFuncs {
+				for name, fn := range funcs(nonce) {
+					c.SetFunc(name, fn)
+				}
+			}
+			if s.ContentSecurityPolicy != `` {
+				name := `Content-Security-Policy`
+				if s.ReportOnly {
+					name += `-Report-Only`
+				}
+				header.Set(name, strings.Replace(s.ContentSecurityPolicy, `{nonce}`, nonce, -1))
+			}
+			return h.Handle(c)
+		})
+	})
+}
+
+// 生成128位随机nonce
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ``, err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
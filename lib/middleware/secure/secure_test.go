@@ -0,0 +1,84 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package secure
+
+import (
+	"html/template"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/testutil"
+)
+
+func serve(s *Secure, server *X.Server) (*X.Context, *httptest.ResponseRecorder) {
+	ctx, rec := testutil.Get(`https://example.com/`)
+	c := &X.Context{Context: ctx, Server: server}
+	s.Middleware()(echo.HandlerFunc(func(echo.Context) error {
+		return nil
+	})).Handle(c)
+	return c, rec
+}
+
+func TestMiddleware(t *testing.T) {
+	c, rec := serve(New(), &X.Server{})
+	header := rec.Header()
+	if v := header.Get(`Strict-Transport-Security`); v != `max-age=31536000; includeSubDomains` {
+		t.Errorf("Strict-Transport-Security: %q", v)
+	}
+	if header.Get(`X-Content-Type-Options`) != `nosniff` || header.Get(`X-Frame-Options`) != `SAMEORIGIN` {
+		t.Errorf("headers: %v", header)
+	}
+	nonce := Nonce(c)
+	if nonce == `` {
+		t.Fatal("nonce is not set")
+	}
+	if csp := header.Get(`Content-Security-Policy`); !strings.Contains(csp, `'nonce-`+nonce+`'`) || strings.Contains(csp, `{nonce}`) {
+		t.Errorf("Content-Security-Policy: %q", csp)
+	}
+	if fn, ok := c.GetFunc(`CspNonce`).(func() string); !ok || fn() != nonce {
+		t.Error("CspNonce is not registered")
+	}
+	c2, _ := serve(New(), &X.Server{})
+	if Nonce(c2) == nonce {
+		t.Error("nonce is reused")
+	}
+}
+
+func TestStaticNonceFuncs(t *testing.T) {
+	server := &X.Server{}
+	server.Static(`/static`, `public`)
+	c, _ := serve(New(), server)
+	nonce := Nonce(c)
+	jsTag, ok := c.GetFunc(`JsTag`).(func(...string) template.HTML)
+	if !ok {
+		t.Fatal("JsTag is not registered")
+	}
+	if tag := string(jsTag(`app.js`)); !strings.Contains(tag, `nonce="`+nonce+`"`) {
+		t.Errorf("JsTag: %v", tag)
+	}
+	cssTag, ok := c.GetFunc(`CssTag`).(func(...string) template.HTML)
+	if !ok {
+		t.Fatal("CssTag is not registered")
+	}
+	if tag := string(cssTag(`app.css`)); !strings.Contains(tag, `nonce="`+nonce+`"`) {
+		t.Errorf("CssTag: %v", tag)
+	}
+}
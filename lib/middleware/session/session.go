@@ -30,7 +30,11 @@ func Sessions(name string, store ss.Store) echo.MiddlewareFunc {
 	})
 }
 
+// Middleware 根据options.Engine创建Store，配置有误时panic
 func Middleware(options *ssi.Options, setting interface{}) echo.MiddlewareFunc {
-	store := ss.StoreEngine(options, setting)
+	store, err := ss.NewStore(options, setting)
+	if err != nil {
+		panic(err)
+	}
 	return Sessions(ssi.DefaultName, store)
 }
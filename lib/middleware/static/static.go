@@ -0,0 +1,203 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package static
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/middleware/compress"
+	"github.com/webx-top/webx/lib/tplfunc"
+)
+
+// New 创建输出tplfunc.Static中文件的静态文件服务
+func New(s *tplfunc.Static) *Handler {
+	return &Handler{Static: s}
+}
+
+// Handler 输出Static.RootPath中的文件。网址中的内容指纹与文件内容相符时使用长期缓存(immutable)，
+// 否则每次都须验证。支持条件请求和Range请求，设置了Compress时优先输出预压缩版本
+type Handler struct {
+	*tplfunc.Static
+	Compress *compress.Compress //不为nil时输出预压缩版本(如：app.js.gz)
+	MaxAge   int                //不带指纹的网址的缓存时间(秒)，0表示每次都须验证
+}
+
+// Register 在app中注册静态文件路由，prefix为Static.Path相对于app的路径，如："/assets"
+func (h *Handler) Register(app *X.App, prefix string) {
+	app.R(prefix+`/*`, h.Serve, `GET`, `HEAD`)
+}
+
+func (h *Handler) Serve(c *X.Context) error {
+	return h.serve(c, c.P(0))
+}
+
+// 输出Static.RootPath中的文件，name为文件相对于RootPath的路径，可以带有内容指纹
+func (h *Handler) serve(c *X.Context, name string) error {
+	name = path.Clean(`/` + name)[1:]
+	orig, hash := tplfunc.StripFingerprint(name)
+	immutable := hash != `` && h.FingerprintPath(orig) == name
+	file := filepath.Join(h.RootPath, filepath.FromSlash(orig))
+	fi, err := os.Stat(file)
+	if hash != `` && (err != nil || fi.IsDir()) {
+		//文件名中恰好含有类似指纹的部分
+		file = filepath.Join(h.RootPath, filepath.FromSlash(name))
+		fi, err = os.Stat(file)
+	}
+	if err != nil || fi.IsDir() {
+		return X.NewError(http.StatusNotFound, ``)
+	}
+	header := c.Response().Header()
+	switch {
+	case immutable:
+		header.Set(`Cache-Control`, `public, max-age=31536000, immutable`)
+	case h.MaxAge > 0:
+		header.Set(`Cache-Control`, `public, max-age=`+strconv.Itoa(h.MaxAge))
+	default:
+		header.Set(`Cache-Control`, `no-cache`)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(file))
+	if contentType == `` {
+		contentType = `application/octet-stream`
+	}
+	etag := `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 36) + `-` + strconv.FormatInt(fi.Size(), 36) + `"`
+	size := fi.Size()
+	req := c.Request()
+	rangeHeader := req.Header().Get(`Range`)
+	if rangeHeader != `` && !ifRange(req.Header().Get(`If-Range`), etag, fi.ModTime()) {
+		rangeHeader = ``
+	}
+	var precompressed bool
+	if rangeHeader == `` && h.Compress != nil && h.Compress.AllowType(contentType) {
+		X.AddVary(header, `Accept-Encoding`)
+		encoding := compress.Negotiate(req.Header().Get(`Accept-Encoding`), h.Compress.EncodingList())
+		if pfi, err := os.Stat(file + compress.Ext(encoding)); encoding != `` && err == nil && !pfi.ModTime().Before(fi.ModTime()) {
+			compress.Skip(c)
+			header.Set(`Content-Encoding`, encoding)
+			etag = strings.TrimSuffix(etag, `"`) + `-` + encoding + `"`
+			file += compress.Ext(encoding)
+			size = pfi.Size()
+			precompressed = true
+		}
+	}
+	header.Set(`Accept-Ranges`, `bytes`)
+	if c.CheckConditional(etag, fi.ModTime()) {
+		return nil
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header.Set(`Content-Type`, contentType)
+	code := http.StatusOK
+	var reader io.Reader = f
+	if rangeHeader != `` {
+		start, length, status := ParseRange(rangeHeader, size)
+		switch status {
+		case http.StatusRequestedRangeNotSatisfiable:
+			header.Set(`Content-Range`, `bytes */`+strconv.FormatInt(size, 10))
+			return c.NoContent(status)
+		case http.StatusPartialContent:
+			header.Set(`Content-Range`, `bytes `+strconv.FormatInt(start, 10)+`-`+strconv.FormatInt(start+length-1, 10)+`/`+strconv.FormatInt(size, 10))
+			reader = io.NewSectionReader(f, start, length)
+			size = length
+			code = status
+		}
+	}
+	if precompressed || header.Get(`Content-Encoding`) == `` {
+		//由compress中间件压缩时内容长度未知
+		header.Set(`Content-Length`, strconv.FormatInt(size, 10))
+	}
+	c.Response().WriteHeader(code)
+	c.Exit = true
+	if req.Method() == `HEAD` {
+		return nil
+	}
+	_, err = io.Copy(c.Response(), reader)
+	return err
+}
+
+// 判断If-Range条件是否成立，成立时才按Range输出部分内容
+func ifRange(value string, etag string, modTime time.Time) bool {
+	if value == `` {
+		return true
+	}
+	if strings.HasPrefix(value, `"`) {
+		return value == etag
+	}
+	t, err := http.ParseTime(value)
+	return err == nil && t.Equal(modTime.UTC().Truncate(time.Second))
+}
+
+// ParseRange 解析Range请求头。返回http.StatusPartialContent及范围，
+// http.StatusRequestedRangeNotSatisfiable表示范围超出内容大小，
+// http.StatusOK表示忽略Range输出全部内容(格式无效或请求多个范围)
+func ParseRange(value string, size int64) (start int64, length int64, status int) {
+	if !strings.HasPrefix(value, `bytes=`) {
+		return 0, size, http.StatusOK
+	}
+	spec := strings.TrimSpace(value[6:])
+	if strings.Contains(spec, `,`) {
+		return 0, size, http.StatusOK
+	}
+	i := strings.Index(spec, `-`)
+	if i < 0 {
+		return 0, size, http.StatusOK
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == `` {
+		//最后n个字节
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, size, http.StatusOK
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, http.StatusRequestedRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, http.StatusPartialContent
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, http.StatusOK
+	}
+	if start >= size {
+		return 0, 0, http.StatusRequestedRangeNotSatisfiable
+	}
+	end := size - 1
+	if last != `` {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, size, http.StatusOK
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, http.StatusPartialContent
+}
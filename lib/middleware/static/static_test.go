@@ -0,0 +1,206 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package static
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/middleware/compress"
+	"github.com/webx-top/webx/lib/tplfunc"
+	"github.com/webx-top/webx/testutil"
+)
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		value  string
+		start  int64
+		length int64
+		status int
+	}{
+		{`bytes=0-99`, 0, 100, http.StatusPartialContent},
+		{`bytes=100-`, 100, 900, http.StatusPartialContent},
+		{`bytes=900-2000`, 900, 100, http.StatusPartialContent},
+		{`bytes=-100`, 900, 100, http.StatusPartialContent},
+		{`bytes=-2000`, 0, 1000, http.StatusPartialContent},
+		{`bytes=1000-`, 0, 0, http.StatusRequestedRangeNotSatisfiable},
+		{`bytes=-0`, 0, 0, http.StatusRequestedRangeNotSatisfiable},
+		{`bytes=0-1,5-9`, 0, 1000, http.StatusOK},
+		{`bytes=9-1`, 0, 1000, http.StatusOK},
+		{`items=0-1`, 0, 1000, http.StatusOK},
+	}
+	for _, test := range tests {
+		start, length, status := ParseRange(test.value, 1000)
+		if start != test.start || length != test.length || status != test.status {
+			t.Errorf("ParseRange(%q) = %d, %d, %d; want %d, %d, %d", test.value, start, length, status, test.start, test.length, test.status)
+		}
+	}
+}
+
+var css = []byte(strings.Repeat(`p{color:red}`, 100))
+
+// 创建含有css/app.css及其预压缩版本的静态文件目录
+func newTestHandler(t *testing.T) (*Handler, func()) {
+	dir, err := ioutil.TempDir(``, `static`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, `css`, `app.css`)
+	os.MkdirAll(filepath.Dir(file), 0755)
+	if err := ioutil.WriteFile(file, css, 0644); err != nil {
+		t.Fatal(err)
+	}
+	gz, err := compress.Encode(`gzip`, css, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file+`.gz`, gz, 0644); err != nil {
+		t.Fatal(err)
+	}
+	//预压缩版本不能比原文件旧
+	mtime := time.Now().Add(-time.Hour)
+	os.Chtimes(file, mtime, mtime)
+	return New(tplfunc.NewStatic(`/assets`, dir)), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+// 请求name文件，header为请求头
+func serve(t *testing.T, h *Handler, method string, name string, header map[string]string) *httptest.ResponseRecorder {
+	req := testutil.NewRequest(method, `/assets/`+name, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	ctx, rec := testutil.NewContext(req)
+	if err := h.serve(&X.Context{Context: ctx}, name); err != nil {
+		t.Fatalf("%v %v: %v", method, name, err)
+	}
+	return rec
+}
+
+func TestServeCacheControl(t *testing.T) {
+	h, clean := newTestHandler(t)
+	defer clean()
+	tests := []struct {
+		name         string
+		cacheControl string
+	}{
+		{h.FingerprintPath(`css/app.css`), `public, max-age=31536000, immutable`},
+		{tplfunc.FingerprintName(`css/app.css`, []byte(`old`)), `no-cache`},
+		{`css/app.css`, `no-cache`},
+	}
+	for _, test := range tests {
+		rec := serve(t, h, `GET`, test.name, nil)
+		if cc := rec.Result().Header.Get(`Cache-Control`); cc != test.cacheControl {
+			t.Errorf("%v: Cache-Control = %q, want %q", test.name, cc, test.cacheControl)
+		}
+		if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), css) {
+			t.Errorf("%v: %d, %d bytes", test.name, rec.Code, rec.Body.Len())
+		}
+	}
+	h.MaxAge = 60
+	if cc := serve(t, h, `GET`, `css/app.css`, nil).Result().Header.Get(`Cache-Control`); cc != `public, max-age=60` {
+		t.Errorf("MaxAge: Cache-Control = %q", cc)
+	}
+	req := testutil.NewRequest(`GET`, `/assets/css/none.css`, nil)
+	ctx, _ := testutil.NewContext(req)
+	if err := h.serve(&X.Context{Context: ctx}, `css/none.css`); err == nil {
+		t.Error("missing file is served")
+	}
+}
+
+func TestServePrecompressed(t *testing.T) {
+	h, clean := newTestHandler(t)
+	defer clean()
+	h.Compress = compress.New()
+	rec := serve(t, h, `GET`, `css/app.css`, map[string]string{`Accept-Encoding`: `gzip`})
+	header := rec.Result().Header
+	if header.Get(`Content-Encoding`) != `gzip` || !strings.HasSuffix(header.Get(`ETag`), `-gzip"`) {
+		t.Fatalf("headers: %v", header)
+	}
+	if header.Get(`Vary`) != `Accept-Encoding` || header.Get(`Content-Length`) != strconv.Itoa(rec.Body.Len()) {
+		t.Errorf("headers: %v", header)
+	}
+	r, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(r); err != nil || !bytes.Equal(b, css) {
+		t.Errorf("body does not decode to the file: %v", err)
+	}
+	rec = serve(t, h, `GET`, `css/app.css`, map[string]string{`Accept-Encoding`: `gzip`, `If-None-Match`: header.Get(`ETag`)})
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("conditional request: %d", rec.Code)
+	}
+	//Range请求输出原文件的部分内容
+	rec = serve(t, h, `GET`, `css/app.css`, map[string]string{`Accept-Encoding`: `gzip`, `Range`: `bytes=0-9`})
+	if rec.Code != http.StatusPartialContent || rec.Result().Header.Get(`Content-Encoding`) != `` || !bytes.Equal(rec.Body.Bytes(), css[:10]) {
+		t.Errorf("range of precompressed file: %d %v", rec.Code, rec.Result().Header)
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	h, clean := newTestHandler(t)
+	defer clean()
+	etag := serve(t, h, `GET`, `css/app.css`, nil).Result().Header.Get(`ETag`)
+	size := strconv.Itoa(len(css))
+	tests := []struct {
+		header       map[string]string
+		code         int
+		contentRange string
+		body         []byte
+	}{
+		{map[string]string{`Range`: `bytes=0-9`}, http.StatusPartialContent, `bytes 0-9/` + size, css[:10]},
+		{map[string]string{`Range`: `bytes=-10`}, http.StatusPartialContent, `bytes 1190-1199/` + size, css[len(css)-10:]},
+		{map[string]string{`Range`: `bytes=0-9`, `If-Range`: etag}, http.StatusPartialContent, `bytes 0-9/` + size, css[:10]},
+		{map[string]string{`Range`: `bytes=0-9`, `If-Range`: `"old"`}, http.StatusOK, ``, css},
+		{map[string]string{`Range`: `bytes=5000-`}, http.StatusRequestedRangeNotSatisfiable, `bytes */` + size, nil},
+	}
+	for _, test := range tests {
+		rec := serve(t, h, `GET`, `css/app.css`, test.header)
+		if rec.Code != test.code || rec.Result().Header.Get(`Content-Range`) != test.contentRange {
+			t.Errorf("%v: %d, Content-Range %q", test.header, rec.Code, rec.Result().Header.Get(`Content-Range`))
+		}
+		if !bytes.Equal(rec.Body.Bytes(), test.body) {
+			t.Errorf("%v: body %q", test.header, rec.Body.String())
+		}
+	}
+}
+
+func TestServeHead(t *testing.T) {
+	h, clean := newTestHandler(t)
+	defer clean()
+	rec := serve(t, h, `HEAD`, `css/app.css`, nil)
+	header := rec.Result().Header
+	if rec.Code != http.StatusOK || rec.Body.Len() != 0 {
+		t.Errorf("HEAD: %d, %d bytes", rec.Code, rec.Body.Len())
+	}
+	if header.Get(`Content-Length`) != strconv.Itoa(len(css)) || header.Get(`Accept-Ranges`) != `bytes` || header.Get(`ETag`) == `` {
+		t.Errorf("HEAD headers: %v", header)
+	}
+}
@@ -0,0 +1,55 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package oauth2
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+)
+
+var (
+	errInvalidClient      = errorf(http.StatusUnauthorized, `invalid_client`, `client authentication failed`)
+	errUnauthorizedClient = errorf(http.StatusBadRequest, `unauthorized_client`, `grant type is not allowed for this client`)
+	errAccessDenied       = errorf(http.StatusForbidden, `access_denied`, `the user denied the request`)
+)
+
+// Error 是OAuth2错误响应(RFC 6749 5.2)
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+	Status      int    `json:"-"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == `` {
+		return `oauth2: ` + e.Code
+	}
+	return `oauth2: ` + e.Code + `: ` + e.Description
+}
+
+func errorf(status int, code string, description string) *Error {
+	return &Error{Code: code, Description: description, Status: status}
+}
+
+// 生成授权码
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,668 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package oauth2
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/auth"
+	"github.com/webx-top/webx/lib/cachestore"
+	"github.com/webx-top/webx/lib/middleware/jwt"
+	"github.com/webx-top/webx/lib/session/ssi"
+)
+
+// 授权方式
+const (
+	GrantAuthorizationCode = `authorization_code`
+	GrantClientCredentials = `client_credentials`
+	GrantRefreshToken      = `refresh_token`
+)
+
+// 保存授权确认页面随机数的session键名
+const consentKey = `oauth2_consent`
+
+// PKCE的code_challenge_method
+const (
+	PKCEPlain = `plain`
+	PKCES256  = `S256`
+)
+
+// New 创建OAuth2授权服务。
+// j用于签发和验证令牌，j.Revocation为nil时使用cache保存吊销列表；
+// cache用于保存授权码；a用于在授权页面识别当前用户
+func New(store Store, j *jwt.JWT, cache cachestore.Cache, a *auth.Auth) *Server {
+	if j.Revocation == nil {
+		j.Revocation = jwt.NewRevocation(cache)
+	}
+	return &Server{
+		Store:           store,
+		JWT:             j,
+		Cache:           cache,
+		Auth:            a,
+		CodeExpire:      10 * time.Minute,
+		CodePrefix:      `oauth2_code_`,
+		ConsentTemplate: `oauth2/consent`,
+	}
+}
+
+// Server 是OAuth2授权服务，支持授权码(PKCE)、客户端凭据和刷新令牌授权方式，以及令牌内省(RFC 7662)和吊销(RFC 7009)。
+// 访问令牌和刷新令牌由JWT签发，声明client_id和scope分别为客户端ID和空格分隔的权限范围
+type Server struct {
+	Store           Store
+	JWT             *jwt.JWT
+	Cache           cachestore.Cache
+	Auth            *auth.Auth
+	CodeExpire      time.Duration //授权码有效期
+	CodePrefix      string        //授权码的缓存键名前缀
+	ConsentTemplate string        //授权确认页面模板
+	RequirePKCE     bool          //是否要求机密客户端也使用PKCE(公开客户端总是要求)
+}
+
+// Register 在app中注册授权(authorize)、令牌(token)、内省(introspect)和吊销(revoke)网址
+func (s *Server) Register(app *X.App, prefix string) {
+	app.R(prefix+`/authorize`, s.Authorize, `GET`, `POST`)
+	app.R(prefix+`/token`, s.Token, `POST`)
+	app.R(prefix+`/introspect`, s.Introspect, `POST`)
+	app.R(prefix+`/revoke`, s.Revoke, `POST`)
+}
+
+// Token 是令牌响应
+type Token struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// AuthorizeRequest 是授权请求的参数
+type AuthorizeRequest struct {
+	Client              *Client
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// 保存在缓存中的授权码数据
+type authorizationCode struct {
+	ClientID            string
+	RedirectURI         string
+	UserID              string
+	Scopes              []string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+// Authorize 是授权端点。GET显示授权确认页面(用户已授予全部权限时直接跳转)，
+// POST提交确认结果：表单字段approve非空表示同意，consent_nonce须与确认页面中的相同，以免跨站请求伪造
+func (s *Server) Authorize(c *X.Context) error {
+	req, err := s.ParseAuthorizeRequest(c.Form)
+	if err != nil {
+		if req == nil {
+			//客户端或回调网址无效时不能跳转
+			return c.DisplayError(err.Error(), http.StatusBadRequest)
+		}
+		return c.Redirect(http.StatusFound, redirectURL(req.RedirectURI, errorParams(err, req.State)))
+	}
+	user, err := s.Auth.User(c)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return s.Auth.Deny(c)
+	}
+	uid := user.UserID()
+	if c.IsPost() {
+		if !checkConsentNonce(c.Session(), req.Client.ID, c.Form(`consent_nonce`)) {
+			return c.DisplayError(`invalid consent_nonce`, http.StatusForbidden)
+		}
+		if c.Form(`approve`) == `` {
+			return c.Redirect(http.StatusFound, redirectURL(req.RedirectURI, errorParams(errAccessDenied, req.State)))
+		}
+		if err := s.Store.SaveConsent(uid, req.Client.ID, req.Scopes); err != nil {
+			return err
+		}
+		return s.redirectWithCode(c, req, uid)
+	}
+	consented, err := s.Store.Consented(uid, req.Client.ID)
+	if err != nil {
+		return err
+	}
+	if containsAll(consented, req.Scopes) {
+		return s.redirectWithCode(c, req, uid)
+	}
+	c.Assign(`client`, req.Client)
+	c.Assign(`scopes`, req.Scopes)
+	c.Assign(`params`, map[string]string{
+		`response_type`:         `code`,
+		`client_id`:             req.Client.ID,
+		`redirect_uri`:          req.RedirectURI,
+		`scope`:                 strings.Join(req.Scopes, ` `),
+		`state`:                 req.State,
+		`code_challenge`:        req.CodeChallenge,
+		`code_challenge_method`: req.CodeChallengeMethod,
+		`consent_nonce`:         newConsentNonce(c.Session(), req.Client.ID),
+	})
+	return c.Display(s.ConsentTemplate)
+}
+
+// 为授权确认页面生成随机数并保存在session中，与客户端ID绑定
+func newConsentNonce(sess ssi.Session, clientID string) string {
+	nonce := randomString()
+	sess.Set(consentKey, clientID+` `+nonce)
+	sess.Save()
+	return nonce
+}
+
+// 校验授权确认页面提交的随机数，随机数只能使用一次
+func checkConsentNonce(sess ssi.Session, clientID string, nonce string) bool {
+	stored, _ := sess.Get(consentKey).(string)
+	if stored == `` {
+		return false
+	}
+	sess.Delete(consentKey)
+	sess.Save()
+	return nonce != `` && subtle.ConstantTimeCompare([]byte(stored), []byte(clientID+` `+nonce)) == 1
+}
+
+func (s *Server) redirectWithCode(c *X.Context, req *AuthorizeRequest, uid string) error {
+	code, err := s.NewCode(req, uid)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Set(`code`, code)
+	if req.State != `` {
+		params.Set(`state`, req.State)
+	}
+	return c.Redirect(http.StatusFound, redirectURL(req.RedirectURI, params))
+}
+
+// ParseAuthorizeRequest 解析并校验授权请求。
+// 客户端或回调网址无效时返回的*AuthorizeRequest为nil，其它错误应跳转到回调网址告知客户端
+func (s *Server) ParseAuthorizeRequest(form func(string) string) (*AuthorizeRequest, error) {
+	client, err := s.Store.GetClient(form(`client_id`))
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		return nil, errorf(http.StatusBadRequest, `invalid_client`, `unknown client`)
+	}
+	redirectURI := form(`redirect_uri`)
+	if redirectURI == `` && len(client.RedirectURIs) == 1 {
+		redirectURI = client.RedirectURIs[0]
+	}
+	if !client.AllowRedirectURI(redirectURI) {
+		return nil, errorf(http.StatusBadRequest, `invalid_request`, `redirect_uri is not registered`)
+	}
+	req := &AuthorizeRequest{
+		Client:              client,
+		RedirectURI:         redirectURI,
+		State:               form(`state`),
+		CodeChallenge:       form(`code_challenge`),
+		CodeChallengeMethod: form(`code_challenge_method`),
+	}
+	if form(`response_type`) != `code` {
+		return req, errorf(http.StatusBadRequest, `unsupported_response_type`, `response_type must be code`)
+	}
+	if !client.AllowGrant(GrantAuthorizationCode) {
+		return req, errorf(http.StatusBadRequest, `unauthorized_client`, `authorization_code grant is not allowed`)
+	}
+	req.Scopes, err = s.scopes(client, form(`scope`))
+	if err != nil {
+		return req, err
+	}
+	if req.CodeChallenge == `` {
+		if client.Public || s.RequirePKCE {
+			return req, errorf(http.StatusBadRequest, `invalid_request`, `code_challenge is required`)
+		}
+		return req, nil
+	}
+	if req.CodeChallengeMethod == `` {
+		req.CodeChallengeMethod = PKCEPlain
+	}
+	if req.CodeChallengeMethod != PKCEPlain && req.CodeChallengeMethod != PKCES256 {
+		return req, errorf(http.StatusBadRequest, `invalid_request`, `unsupported code_challenge_method`)
+	}
+	return req, nil
+}
+
+// 解析申请的权限范围，为空时授予客户端的全部权限
+func (s *Server) scopes(client *Client, scope string) ([]string, error) {
+	scopes := strings.Fields(scope)
+	if len(scopes) == 0 {
+		return client.Scopes, nil
+	}
+	if !client.AllowScopes(scopes) {
+		return nil, errorf(http.StatusBadRequest, `invalid_scope`, `requested scope is not allowed`)
+	}
+	return scopes, nil
+}
+
+// NewCode 为用户uid生成授权码，授权码只能使用一次
+func (s *Server) NewCode(req *AuthorizeRequest, uid string) (string, error) {
+	data, err := json.Marshal(&authorizationCode{
+		ClientID:            req.Client.ID,
+		RedirectURI:         req.RedirectURI,
+		UserID:              uid,
+		Scopes:              req.Scopes,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+	})
+	if err != nil {
+		return ``, err
+	}
+	code := randomString()
+	return code, s.Cache.Set(s.CodePrefix+code, data, s.CodeExpire)
+}
+
+// 取出授权码并原子地标记为已使用。授权码被重复使用时reused为true，
+// 授权码保留到过期为止，以便识别重复使用
+func (s *Server) takeCode(code string) (ac *authorizationCode, reused bool, err error) {
+	if code == `` {
+		return nil, false, nil
+	}
+	key := s.CodePrefix + code
+	v, err := s.Cache.Get(key)
+	if err == cachestore.ErrNotFound {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	n, err := s.Cache.Incr(key+`_used`, 1)
+	if err != nil {
+		return nil, false, err
+	}
+	if n != 1 {
+		return nil, true, nil
+	}
+	//Incr不设置有效期，保存到授权码过期为止
+	if err := s.Cache.Set(key+`_used`, n, s.CodeExpire); err != nil {
+		return nil, false, err
+	}
+	b, _ := v.([]byte)
+	ac = &authorizationCode{}
+	if err := json.Unmarshal(b, ac); err != nil {
+		return nil, false, err
+	}
+	return ac, false, nil
+}
+
+// 记录授权码签发的令牌。记录之前授权码已被重复使用时立即吊销
+func (s *Server) saveCodeTokens(code string, token *Token) error {
+	key := s.CodePrefix + code
+	data, err := json.Marshal([]string{token.AccessToken, token.RefreshToken})
+	if err != nil {
+		return err
+	}
+	if err := s.Cache.Set(key+`_tokens`, data, s.CodeExpire); err != nil {
+		return err
+	}
+	n, err := s.Cache.Incr(key+`_used`, 0)
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		if err := s.revokeCodeTokens(code); err != nil {
+			return err
+		}
+		return errorf(http.StatusBadRequest, `invalid_grant`, `authorization code has been used`)
+	}
+	return nil
+}
+
+// 吊销授权码签发的全部令牌
+func (s *Server) revokeCodeTokens(code string) error {
+	v, err := s.Cache.Get(s.CodePrefix + code + `_tokens`)
+	if err == cachestore.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	b, _ := v.([]byte)
+	var tokens []string
+	if err := json.Unmarshal(b, &tokens); err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token == `` {
+			continue
+		}
+		if err := s.JWT.Revoke(token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Token 是令牌端点，支持authorization_code、client_credentials和refresh_token授权方式
+func (s *Server) Token(c *X.Context) error {
+	client, err := s.authenticateClient(c)
+	if err != nil {
+		return s.writeError(c, err)
+	}
+	var token *Token
+	switch grantType := c.Form(`grant_type`); grantType {
+	case GrantAuthorizationCode:
+		token, err = s.ExchangeCode(client, c.Form(`code`), c.Form(`redirect_uri`), c.Form(`code_verifier`))
+	case GrantClientCredentials:
+		token, err = s.ClientCredentials(client, c.Form(`scope`))
+	case GrantRefreshToken:
+		token, err = s.RefreshToken(client, c.Form(`refresh_token`), c.Form(`scope`))
+	default:
+		err = errorf(http.StatusBadRequest, `unsupported_grant_type`, `unsupported grant_type: `+grantType)
+	}
+	if err != nil {
+		return s.writeError(c, err)
+	}
+	noStore(c)
+	return c.JSON(http.StatusOK, token)
+}
+
+// ExchangeCode 使用授权码换取令牌
+func (s *Server) ExchangeCode(client *Client, code string, redirectURI string, verifier string) (*Token, error) {
+	if !client.AllowGrant(GrantAuthorizationCode) {
+		return nil, errUnauthorizedClient
+	}
+	ac, reused, err := s.takeCode(code)
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		//授权码被重复使用时吊销已用它签发的令牌
+		if err := s.revokeCodeTokens(code); err != nil {
+			return nil, err
+		}
+		return nil, errorf(http.StatusBadRequest, `invalid_grant`, `authorization code has been used`)
+	}
+	if ac == nil || ac.ClientID != client.ID {
+		return nil, errorf(http.StatusBadRequest, `invalid_grant`, `invalid authorization code`)
+	}
+	if ac.RedirectURI != redirectURI && !(redirectURI == `` && len(client.RedirectURIs) == 1) {
+		return nil, errorf(http.StatusBadRequest, `invalid_grant`, `redirect_uri does not match`)
+	}
+	if ac.CodeChallenge != `` || verifier != `` {
+		if !verifyPKCE(ac.CodeChallenge, ac.CodeChallengeMethod, verifier) {
+			return nil, errorf(http.StatusBadRequest, `invalid_grant`, `invalid code_verifier`)
+		}
+	}
+	token, err := s.issue(client, ac.UserID, ac.Scopes, client.AllowGrant(GrantRefreshToken))
+	if err != nil {
+		return nil, err
+	}
+	if err := s.saveCodeTokens(code, token); err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// ClientCredentials 为客户端自身签发访问令牌，令牌的sub为客户端ID
+func (s *Server) ClientCredentials(client *Client, scope string) (*Token, error) {
+	if client.Public || !client.AllowGrant(GrantClientCredentials) {
+		return nil, errUnauthorizedClient
+	}
+	scopes, err := s.scopes(client, scope)
+	if err != nil {
+		return nil, err
+	}
+	return s.issue(client, client.ID, scopes, false)
+}
+
+// RefreshToken 使用刷新令牌换取新的令牌，旧的刷新令牌随即吊销。
+// scope只能缩小原权限范围；用户撤销授权后刷新令牌失效
+func (s *Server) RefreshToken(client *Client, refreshToken string, scope string) (*Token, error) {
+	if !client.AllowGrant(GrantRefreshToken) {
+		return nil, errUnauthorizedClient
+	}
+	claims, err := s.JWT.Parse(refreshToken, jwt.RefreshToken)
+	if err != nil || claimString(claims, `client_id`) != client.ID {
+		return nil, errorf(http.StatusBadRequest, `invalid_grant`, `invalid refresh token`)
+	}
+	granted := strings.Fields(claimString(claims, `scope`))
+	scopes := granted
+	if requested := strings.Fields(scope); len(requested) > 0 {
+		if !containsAll(granted, requested) {
+			return nil, errorf(http.StatusBadRequest, `invalid_scope`, `requested scope exceeds the original grant`)
+		}
+		scopes = requested
+	}
+	consented, err := s.Store.Consented(claims.Subject, client.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !containsAll(consented, scopes) {
+		return nil, errorf(http.StatusBadRequest, `invalid_grant`, `consent has been revoked`)
+	}
+	//并发请求中刷新令牌也只能使用一次
+	ok, err := s.JWT.Revocation.Consume(claims)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, `invalid_grant`, `refresh token has been used`)
+	}
+	return s.issue(client, claims.Subject, scopes, true)
+}
+
+func (s *Server) issue(client *Client, sub string, scopes []string, refresh bool) (*Token, error) {
+	claims := &jwt.Claims{
+		Subject: sub,
+		Extra: map[string]interface{}{
+			`client_id`: client.ID,
+			`scope`:     strings.Join(scopes, ` `),
+		},
+	}
+	token := &Token{
+		TokenType: `Bearer`,
+		ExpiresIn: int64(s.JWT.Expire / time.Second),
+		Scope:     strings.Join(scopes, ` `),
+	}
+	var err error
+	if refresh {
+		token.AccessToken, token.RefreshToken, err = s.JWT.IssuePair(claims)
+	} else {
+		token.AccessToken, err = s.JWT.Issue(claims)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Introspect 是令牌内省端点(RFC 7662)，供资源服务器查询令牌是否有效。
+// 公开客户端没有密钥，无法证明自己是资源服务器，因此不允许调用
+func (s *Server) Introspect(c *X.Context) error {
+	client, err := s.authenticateClient(c)
+	if err == nil && client.Public {
+		err = errorf(http.StatusUnauthorized, `invalid_client`, `public clients can not introspect tokens`)
+	}
+	if err != nil {
+		return s.writeError(c, err)
+	}
+	noStore(c)
+	return c.JSON(http.StatusOK, s.IntrospectToken(c.Form(`token`)))
+}
+
+// IntrospectToken 返回令牌的内省结果，令牌无效时只有active:false
+func (s *Server) IntrospectToken(token string) map[string]interface{} {
+	claims, err := s.JWT.Parse(token, ``)
+	if err != nil {
+		return map[string]interface{}{`active`: false}
+	}
+	r := map[string]interface{}{
+		`active`:     true,
+		`client_id`:  claimString(claims, `client_id`),
+		`scope`:      claimString(claims, `scope`),
+		`sub`:        claims.Subject,
+		`exp`:        claims.ExpiresAt,
+		`iat`:        claims.IssuedAt,
+		`jti`:        claims.ID,
+		`token_type`: claims.Type,
+	}
+	if claims.Issuer != `` {
+		r[`iss`] = claims.Issuer
+	}
+	if len(claims.Audience) > 0 {
+		r[`aud`] = claims.Audience
+	}
+	return r
+}
+
+// Revoke 是令牌吊销端点(RFC 7009)，令牌无效时也返回成功
+func (s *Server) Revoke(c *X.Context) error {
+	client, err := s.authenticateClient(c)
+	if err != nil {
+		return s.writeError(c, err)
+	}
+	if err := s.RevokeToken(client, c.Form(`token`)); err != nil {
+		return s.writeError(c, err)
+	}
+	noStore(c)
+	return c.NoContent(http.StatusOK)
+}
+
+// RevokeToken 吊销发给client的令牌
+func (s *Server) RevokeToken(client *Client, token string) error {
+	claims, err := s.JWT.Parse(token, ``)
+	if err != nil {
+		return nil
+	}
+	if claimString(claims, `client_id`) != client.ID {
+		return errorf(http.StatusBadRequest, `unauthorized_client`, `token was not issued to this client`)
+	}
+	return s.JWT.Revocation.Revoke(claims)
+}
+
+// 认证客户端：使用HTTP Basic或表单字段client_id、client_secret。公开客户端只需client_id
+func (s *Server) authenticateClient(c *X.Context) (*Client, error) {
+	id, secret, basic := basicAuth(c.Header(`Authorization`))
+	if !basic {
+		id, secret = c.Form(`client_id`), c.Form(`client_secret`)
+	}
+	if id == `` {
+		return nil, errInvalidClient
+	}
+	client, err := s.Store.GetClient(id)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil || !client.CheckSecret(secret) {
+		return nil, errInvalidClient
+	}
+	return client, nil
+}
+
+// 解析HTTP Basic认证，用户名和密码按RFC 6749 2.3.1进行了URL编码
+func basicAuth(header string) (id string, secret string, ok bool) {
+	const prefix = `Basic `
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return
+	}
+	b, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return
+	}
+	parts := strings.SplitN(string(b), `:`, 2)
+	if len(parts) != 2 {
+		return
+	}
+	id, err = url.QueryUnescape(parts[0])
+	if err != nil {
+		return
+	}
+	secret, err = url.QueryUnescape(parts[1])
+	if err != nil {
+		return
+	}
+	ok = true
+	return
+}
+
+// 校验PKCE的code_verifier
+func verifyPKCE(challenge string, method string, verifier string) bool {
+	if challenge == `` || len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	if method == PKCES256 {
+		sum := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(challenge), []byte(verifier)) == 1
+}
+
+func claimString(claims *jwt.Claims, name string) string {
+	v, _ := claims.Extra[name].(string)
+	return v
+}
+
+func noStore(c *X.Context) {
+	c.Response().Header().Set(`Cache-Control`, `no-store`)
+	c.Response().Header().Set(`Pragma`, `no-cache`)
+}
+
+func (s *Server) writeError(c *X.Context, err error) error {
+	e, ok := err.(*Error)
+	if !ok {
+		return err
+	}
+	noStore(c)
+	if e.Status == http.StatusUnauthorized {
+		c.Response().Header().Set(`WWW-Authenticate`, `Basic realm="oauth2"`)
+	}
+	return c.JSON(e.Status, e)
+}
+
+// 在回调网址中附加参数
+func redirectURL(uri string, params url.Values) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func errorParams(err error, state string) url.Values {
+	params := url.Values{}
+	if e, ok := err.(*Error); ok {
+		params.Set(`error`, e.Code)
+		params.Set(`error_description`, e.Description)
+	} else {
+		params.Set(`error`, `server_error`)
+	}
+	if state != `` {
+		params.Set(`state`, state)
+	}
+	return params
+}
@@ -0,0 +1,300 @@
+package oauth2
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/cachestore"
+	"github.com/webx-top/webx/lib/middleware/jwt"
+	"github.com/webx-top/webx/lib/session/ssi"
+	"github.com/webx-top/webx/testutil"
+)
+
+const testVerifier = `dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk`
+
+func newTestServer() *Server {
+	store := NewMemoryStore(
+		&Client{
+			ID:           `spa`,
+			RedirectURIs: []string{`https://spa.example.com/callback`},
+			Scopes:       []string{`read`, `write`},
+			Public:       true,
+		},
+		&Client{
+			ID:           `svc`,
+			Secret:       `secret`,
+			RedirectURIs: []string{`https://svc.example.com/callback`},
+			Scopes:       []string{`read`},
+			GrantTypes:   []string{GrantClientCredentials},
+		},
+	)
+	return New(store, jwt.New(`test-secret`), cachestore.NewMemory(nil), nil)
+}
+
+func formFunc(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func authorize(t *testing.T, s *Server, challenge string) (*Client, string) {
+	req, err := s.ParseAuthorizeRequest(formFunc(map[string]string{
+		`response_type`:         `code`,
+		`client_id`:             `spa`,
+		`redirect_uri`:          `https://spa.example.com/callback`,
+		`scope`:                 `read`,
+		`code_challenge`:        challenge,
+		`code_challenge_method`: PKCES256,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Store.SaveConsent(`u1`, req.Client.ID, req.Scopes); err != nil {
+		t.Fatal(err)
+	}
+	code, err := s.NewCode(req, `u1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req.Client, code
+}
+
+func errorCode(err error) string {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return ``
+}
+
+func TestAuthorizeRequest(t *testing.T) {
+	s := newTestServer()
+	_, err := s.ParseAuthorizeRequest(formFunc(map[string]string{
+		`response_type`: `code`,
+		`client_id`:     `spa`,
+		`redirect_uri`:  `https://evil.example.com/callback`,
+	}))
+	if errorCode(err) != `invalid_request` {
+		t.Errorf("unregistered redirect_uri: got %v", err)
+	}
+	req, err := s.ParseAuthorizeRequest(formFunc(map[string]string{
+		`response_type`: `code`,
+		`client_id`:     `spa`,
+	}))
+	if errorCode(err) != `invalid_request` || req == nil {
+		t.Errorf("public client without PKCE: got %v", err)
+	}
+	_, err = s.ParseAuthorizeRequest(formFunc(map[string]string{
+		`response_type`:  `code`,
+		`client_id`:      `spa`,
+		`scope`:          `admin`,
+		`code_challenge`: s256(testVerifier),
+	}))
+	if errorCode(err) != `invalid_scope` {
+		t.Errorf("scope not allowed: got %v", err)
+	}
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	s := newTestServer()
+	client, code := authorize(t, s, s256(testVerifier))
+	if _, err := s.ExchangeCode(client, code, `https://spa.example.com/callback`, `wrong-verifier-wrong-verifier-wrong-verifier`); errorCode(err) != `invalid_grant` {
+		t.Fatalf("wrong code_verifier: got %v", err)
+	}
+
+	client, code = authorize(t, s, s256(testVerifier))
+	token, err := s.ExchangeCode(client, code, `https://spa.example.com/callback`, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken == `` || token.RefreshToken == `` || token.Scope != `read` {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+	info := s.IntrospectToken(token.AccessToken)
+	if info[`active`] != true || info[`sub`] != `u1` || info[`client_id`] != `spa` || info[`scope`] != `read` {
+		t.Errorf("unexpected introspection: %v", info)
+	}
+
+	if _, err := s.ExchangeCode(client, code, `https://spa.example.com/callback`, testVerifier); errorCode(err) != `invalid_grant` {
+		t.Errorf("authorization code reused: got %v", err)
+	}
+	//重复使用授权码后，用它签发的令牌全部吊销
+	if info := s.IntrospectToken(token.AccessToken); info[`active`] != false {
+		t.Errorf("access token is active after the code is reused: %v", info)
+	}
+	if _, err := s.RefreshToken(client, token.RefreshToken, ``); errorCode(err) != `invalid_grant` {
+		t.Errorf("refresh token after the code is reused: got %v", err)
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	s := newTestServer()
+	client, code := authorize(t, s, s256(testVerifier))
+	token, err := s.ExchangeCode(client, code, `https://spa.example.com/callback`, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//并发使用同一个刷新令牌时只有一个请求成功
+	results := make(chan *Token, 4)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(results); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			next, err := s.RefreshToken(client, token.RefreshToken, ``)
+			if err != nil && errorCode(err) != `invalid_grant` {
+				t.Error(err)
+			}
+			results <- next
+		}()
+	}
+	wg.Wait()
+	close(results)
+	var next *Token
+	for v := range results {
+		if v == nil {
+			continue
+		}
+		if next != nil {
+			t.Fatal("refresh token is used twice")
+		}
+		next = v
+	}
+	if next == nil {
+		t.Fatal("refresh token is rejected")
+	}
+	if _, err := s.RefreshToken(client, next.RefreshToken, `read write`); errorCode(err) != `invalid_scope` {
+		t.Errorf("refresh with wider scope: got %v", err)
+	}
+	s.Store.RevokeConsent(`u1`, `spa`)
+	if _, err := s.RefreshToken(client, next.RefreshToken, ``); errorCode(err) != `invalid_grant` {
+		t.Errorf("refresh after consent revoked: got %v", err)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	s := newTestServer()
+	spa, _ := s.Store.GetClient(`spa`)
+	if _, err := s.ClientCredentials(spa, ``); err != errUnauthorizedClient {
+		t.Errorf("public client: got %v", err)
+	}
+	svc, _ := s.Store.GetClient(`svc`)
+	if svc.CheckSecret(`wrong`) || !svc.CheckSecret(`secret`) {
+		t.Error("CheckSecret")
+	}
+	token, err := s.ClientCredentials(svc, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.RefreshToken != `` || token.Scope != `read` {
+		t.Errorf("unexpected token: %+v", token)
+	}
+	if err := s.RevokeToken(spa, token.AccessToken); errorCode(err) != `unauthorized_client` {
+		t.Errorf("revoke by another client: got %v", err)
+	}
+	if err := s.RevokeToken(svc, token.AccessToken); err != nil {
+		t.Fatal(err)
+	}
+	if info := s.IntrospectToken(token.AccessToken); info[`active`] != false {
+		t.Errorf("revoked token is active: %v", info)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	header := `Basic ` + base64.StdEncoding.EncodeToString([]byte(`my%3Aclient:p%40ss`))
+	id, secret, ok := basicAuth(header)
+	if !ok || id != `my:client` || secret != `p@ss` {
+		t.Errorf("basicAuth(%q) = %q, %q, %v", header, id, secret, ok)
+	}
+	if _, _, ok := basicAuth(`Bearer abc`); ok {
+		t.Error("basicAuth accepted a bearer token")
+	}
+}
+
+// 用表单参数创建POST请求的Context，返回的ResponseRecorder记录JSON输出
+func newTestContext(form map[string]string) (*X.Context, *httptest.ResponseRecorder) {
+	values := url.Values{}
+	for k, v := range form {
+		values.Set(k, v)
+	}
+	req := testutil.NewRequest(`POST`, `/oauth2/introspect`, strings.NewReader(values.Encode()))
+	req.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+	ctx, rec := testutil.NewContext(req)
+	return &X.Context{Context: ctx}, rec
+}
+
+func TestIntrospectClient(t *testing.T) {
+	s := newTestServer()
+	svc, _ := s.Store.GetClient(`svc`)
+	token, err := s.ClientCredentials(svc, ``)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, rec := newTestContext(map[string]string{`client_id`: `spa`, `token`: token.AccessToken})
+	if err := s.Introspect(c); err != nil {
+		t.Fatal(err)
+	}
+	var e Error
+	if json.Unmarshal(rec.Body.Bytes(), &e); rec.Code != http.StatusUnauthorized || e.Code != `invalid_client` {
+		t.Errorf("public client introspection: got %d %s", rec.Code, rec.Body)
+	}
+
+	c, rec = newTestContext(map[string]string{`client_id`: `svc`, `client_secret`: `secret`, `token`: token.AccessToken})
+	if err := s.Introspect(c); err != nil {
+		t.Fatal(err)
+	}
+	var info map[string]interface{}
+	if json.Unmarshal(rec.Body.Bytes(), &info); rec.Code != http.StatusOK || info[`active`] != true {
+		t.Errorf("confidential client introspection: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+type testSession struct {
+	ssi.Session
+	values map[string]interface{}
+}
+
+func (s *testSession) Get(key string) interface{} { return s.values[key] }
+func (s *testSession) Set(key string, val interface{}) ssi.Session {
+	s.values[key] = val
+	return s
+}
+func (s *testSession) Delete(key string) ssi.Session {
+	delete(s.values, key)
+	return s
+}
+func (s *testSession) Save() error { return nil }
+
+func TestConsentNonce(t *testing.T) {
+	sess := &testSession{values: map[string]interface{}{}}
+	if checkConsentNonce(sess, `spa`, ``) {
+		t.Error("empty nonce accepted without a consent page")
+	}
+	nonce := newConsentNonce(sess, `spa`)
+	if checkConsentNonce(sess, `svc`, nonce) {
+		t.Error("nonce accepted for another client")
+	}
+	nonce = newConsentNonce(sess, `spa`)
+	if checkConsentNonce(sess, `spa`, `forged`) {
+		t.Error("forged nonce accepted")
+	}
+	nonce = newConsentNonce(sess, `spa`)
+	if !checkConsentNonce(sess, `spa`, nonce) {
+		t.Error("valid nonce rejected")
+	}
+	if checkConsentNonce(sess, `spa`, nonce) {
+		t.Error("nonce reused")
+	}
+}
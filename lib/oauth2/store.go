@@ -0,0 +1,162 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package oauth2
+
+import (
+	"crypto/subtle"
+	"sync"
+)
+
+var _ Store = &MemoryStore{}
+
+// Client 是第三方应用
+type Client struct {
+	ID           string
+	Secret       string   //公开客户端为空
+	RedirectURIs []string //允许的回调网址，须完全匹配
+	Scopes       []string //允许申请的权限范围，未指定scope时授予全部
+	GrantTypes   []string //允许的授权方式，为空时允许authorization_code和refresh_token
+	Public       bool     //公开客户端(单页应用、移动应用等无法保存密钥的客户端)，必须使用PKCE
+}
+
+// CheckSecret 校验客户端密钥
+func (c *Client) CheckSecret(secret string) bool {
+	if c.Public {
+		return secret == ``
+	}
+	return c.Secret != `` && subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) == 1
+}
+
+// AllowRedirectURI 回调网址是否已登记
+func (c *Client) AllowRedirectURI(uri string) bool {
+	return inSlice(uri, c.RedirectURIs)
+}
+
+// AllowGrant 是否允许使用授权方式grantType
+func (c *Client) AllowGrant(grantType string) bool {
+	if len(c.GrantTypes) == 0 {
+		return grantType == GrantAuthorizationCode || grantType == GrantRefreshToken
+	}
+	return inSlice(grantType, c.GrantTypes)
+}
+
+// AllowScopes 是否允许申请全部scopes
+func (c *Client) AllowScopes(scopes []string) bool {
+	return containsAll(c.Scopes, scopes)
+}
+
+// ClientStore 根据ID获取客户端，客户端不存在时返回nil
+type ClientStore interface {
+	GetClient(id string) (*Client, error)
+}
+
+// ConsentStore 保存用户对客户端的授权
+type ConsentStore interface {
+	Consented(uid string, clientID string) ([]string, error)
+	SaveConsent(uid string, clientID string, scopes []string) error
+	RevokeConsent(uid string, clientID string) error
+}
+
+// Store 是OAuth2服务端使用的客户端及授权存储
+type Store interface {
+	ClientStore
+	ConsentStore
+}
+
+func NewMemoryStore(clients ...*Client) *MemoryStore {
+	s := &MemoryStore{
+		clients:  make(map[string]*Client),
+		consents: make(map[string][]string),
+	}
+	for _, client := range clients {
+		s.AddClient(client)
+	}
+	return s
+}
+
+// MemoryStore 是保存在内存中的Store，用于测试或客户端固定的场合
+type MemoryStore struct {
+	clients  map[string]*Client
+	consents map[string][]string
+	mutex    sync.RWMutex
+}
+
+func (s *MemoryStore) AddClient(client *Client) {
+	s.mutex.Lock()
+	s.clients[client.ID] = client
+	s.mutex.Unlock()
+}
+
+func (s *MemoryStore) DelClient(id string) {
+	s.mutex.Lock()
+	delete(s.clients, id)
+	s.mutex.Unlock()
+}
+
+func (s *MemoryStore) GetClient(id string) (*Client, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.clients[id], nil
+}
+
+func (s *MemoryStore) Consented(uid string, clientID string) ([]string, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.consents[uid+"\x00"+clientID], nil
+}
+
+// SaveConsent 将scopes合并到已授权的权限范围中
+func (s *MemoryStore) SaveConsent(uid string, clientID string, scopes []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := uid + "\x00" + clientID
+	consented := s.consents[key]
+	for _, scope := range scopes {
+		if !inSlice(scope, consented) {
+			consented = append(consented, scope)
+		}
+	}
+	s.consents[key] = consented
+	return nil
+}
+
+func (s *MemoryStore) RevokeConsent(uid string, clientID string) error {
+	s.mutex.Lock()
+	delete(s.consents, uid+"\x00"+clientID)
+	s.mutex.Unlock()
+	return nil
+}
+
+func inSlice(v string, items []string) bool {
+	for _, item := range items {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// items是否包含全部values
+func containsAll(items []string, values []string) bool {
+	for _, v := range values {
+		if !inSlice(v, items) {
+			return false
+		}
+	}
+	return true
+}
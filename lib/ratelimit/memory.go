@@ -0,0 +1,126 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+var _ Store = &MemoryStore{}
+
+// NewMemoryStore 创建保存在内存中的计数器，只适用于单个进程
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		windows: make(map[string]*window),
+	}
+}
+
+type MemoryStore struct {
+	buckets map[string]*bucket
+	windows map[string]*window
+	swept   time.Time
+	mutex   sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	expire time.Time
+}
+
+type window struct {
+	index  int64 //窗口序号：时间/周期
+	prev   int64
+	curr   int64
+	expire time.Time
+}
+
+func (s *MemoryStore) TokenBucket(key string, rule Rule, now time.Time) (*Result, error) {
+	rate := float64(rule.Limit) / rule.Period.Seconds()
+	capacity := float64(rule.burst())
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sweep(now)
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+		b.last = now
+	}
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	//桶满之后不再需要保存
+	b.expire = now.Add(time.Duration((capacity - b.tokens) / rate * float64(time.Second)))
+	return bucketResult(rule, b.tokens, allowed), nil
+}
+
+func (s *MemoryStore) SlidingWindow(key string, rule Rule, now time.Time) (*Result, error) {
+	period := int64(rule.Period)
+	index := now.UnixNano() / period
+	elapsed := time.Duration(now.UnixNano() - index*period)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sweep(now)
+	w, ok := s.windows[key]
+	if !ok {
+		w = &window{index: index}
+		s.windows[key] = w
+	}
+	if w.index != index {
+		if w.index == index-1 {
+			w.prev = w.curr
+		} else {
+			w.prev = 0
+		}
+		w.curr = 0
+		w.index = index
+	}
+	weight := 1 - float64(elapsed)/float64(period)
+	allowed := float64(w.prev)*weight+float64(w.curr)+1 <= float64(rule.Limit)
+	if allowed {
+		w.curr++
+	}
+	w.expire = time.Unix(0, (index+2)*period)
+	return windowResult(rule, w.prev, w.curr, elapsed, allowed), nil
+}
+
+// 每分钟清理一次已过期的计数器，须在持有锁时调用
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.swept) < time.Minute {
+		return
+	}
+	s.swept = now
+	for key, b := range s.buckets {
+		if now.After(b.expire) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, w := range s.windows {
+		if now.After(w.expire) {
+			delete(s.windows, key)
+		}
+	}
+}
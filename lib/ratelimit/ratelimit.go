@@ -0,0 +1,357 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webx-top/echo"
+	"github.com/webx-top/echo/engine"
+	X "github.com/webx-top/webx"
+)
+
+// 限流算法
+const (
+	//令牌桶：桶容量为Burst，按Limit/Period的速率补充，允许短时间的突发请求
+	TokenBucket = iota
+	//滑动窗口：按当前和上一个窗口的计数加权估算最近Period内的请求数
+	SlidingWindow
+)
+
+var (
+	_ X.RateLimiter     = &Limiter{}
+	_ X.RateLimitParser = &Limiter{}
+
+	ErrTooManyRequests = X.NewError(http.StatusTooManyRequests, `too many requests`, `rate_limited`)
+)
+
+var periods = map[string]time.Duration{
+	`s`: time.Second,
+	`m`: time.Minute,
+	`h`: time.Hour,
+	`d`: 24 * time.Hour,
+}
+
+// Rule 是限流规则：每Period最多Limit个请求
+type Rule struct {
+	Limit  int
+	Period time.Duration
+	Burst  int    //令牌桶容量，为0时等于Limit
+	Key    string //Limiter.Keys中的键名，为空时使用Limiter.DefaultKey
+}
+
+func (r Rule) burst() int {
+	if r.Burst > 0 {
+		return r.Burst
+	}
+	return r.Limit
+}
+
+func (r Rule) String() string {
+	return fmt.Sprintf(`%d/%v`, r.Limit, r.Period)
+}
+
+// ParseRule 解析限流规则，格式为"次数/周期[ 键名][ burst=N]"，例如：
+// "10/m"、"100/5m user"、"1000/h apikey burst=50"、"30/1m30s"。周期的单位为s、m、h或d
+func ParseRule(rule string) (Rule, error) {
+	var r Rule
+	fields := strings.Fields(rule)
+	if len(fields) == 0 {
+		return r, errors.New(`ratelimit: empty rule`)
+	}
+	parts := strings.SplitN(fields[0], `/`, 2)
+	if len(parts) != 2 || parts[1] == `` {
+		return r, fmt.Errorf(`ratelimit: invalid rule %q`, rule)
+	}
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil || limit <= 0 {
+		return r, fmt.Errorf(`ratelimit: invalid limit in rule %q`, rule)
+	}
+	r.Limit = limit
+	if period, ok := periods[parts[1]]; ok {
+		r.Period = period
+	} else if period, ok := periods[parts[1][len(parts[1])-1:]]; ok {
+		n, err := strconv.Atoi(parts[1][:len(parts[1])-1])
+		if err == nil {
+			r.Period = time.Duration(n) * period
+		}
+	}
+	if r.Period <= 0 {
+		r.Period, err = time.ParseDuration(parts[1])
+		if err != nil || r.Period <= 0 {
+			return r, fmt.Errorf(`ratelimit: invalid period in rule %q`, rule)
+		}
+	}
+	for _, field := range fields[1:] {
+		if strings.HasPrefix(field, `burst=`) {
+			r.Burst, err = strconv.Atoi(strings.TrimPrefix(field, `burst=`))
+			if err != nil || r.Burst <= 0 {
+				return r, fmt.Errorf(`ratelimit: invalid burst in rule %q`, rule)
+			}
+			continue
+		}
+		r.Key = field
+	}
+	return r, nil
+}
+
+// Result 是一次限流检查的结果
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration //配额完全恢复(令牌桶)或当前窗口结束(滑动窗口)的时间
+	RetryAfter time.Duration //被拒绝时需等待的时间
+}
+
+// SetHeaders 设置RateLimit-Limit、RateLimit-Remaining、RateLimit-Reset响应头，被拒绝时设置Retry-After
+func (r *Result) SetHeaders(h engine.Header) {
+	h.Set(`RateLimit-Limit`, strconv.Itoa(r.Limit))
+	h.Set(`RateLimit-Remaining`, strconv.Itoa(r.Remaining))
+	h.Set(`RateLimit-Reset`, strconv.FormatInt(seconds(r.Reset), 10))
+	if !r.Allowed {
+		h.Set(`Retry-After`, strconv.FormatInt(seconds(r.RetryAfter), 10))
+	}
+}
+
+// 向上取整的秒数
+func seconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// Store 保存限流计数器
+type Store interface {
+	TokenBucket(key string, rule Rule, now time.Time) (*Result, error)
+	SlidingWindow(key string, rule Rule, now time.Time) (*Result, error)
+}
+
+// KeyFunc 返回当前请求所属的限流对象，例如IP、用户或API key
+type KeyFunc func(c *X.Context) string
+
+// KeyByIP 按客户端IP限流
+func KeyByIP(c *X.Context) string {
+	return `ip:` + c.IP()
+}
+
+// KeyByUser 按session中的登录用户限流，未登录时按IP
+func KeyByUser(c *X.Context) string {
+	if uid := c.Session().User(); uid != `` {
+		return `user:` + uid
+	}
+	return KeyByIP(c)
+}
+
+// KeyByRoute 所有客户端共用路由的配额。用于Middleware时，同一规则的所有路由共用配额
+func KeyByRoute(c *X.Context) string {
+	if u := c.Route(); u != nil {
+		return `route:` + c.App.Name + `:` + u.Route
+	}
+	return `route:`
+}
+
+// KeyByAPIKey 按请求头header或参数api_key中的API key限流，没有时按IP
+func KeyByAPIKey(header string) KeyFunc {
+	return func(c *X.Context) string {
+		key := c.Header(header)
+		if key == `` {
+			key = c.Query(`api_key`)
+		}
+		if key == `` {
+			return KeyByIP(c)
+		}
+		return `apikey:` + key
+	}
+}
+
+func New(store Store) *Limiter {
+	return &Limiter{
+		Store:     store,
+		Algorithm: TokenBucket,
+		Prefix:    `ratelimit_`,
+		Keys: map[string]KeyFunc{
+			`ip`:     KeyByIP,
+			`user`:   KeyByUser,
+			`route`:  KeyByRoute,
+			`apikey`: KeyByAPIKey(`X-API-Key`),
+		},
+		DefaultKey: `ip`,
+		rules:      make(map[string]Rule),
+	}
+}
+
+// Limiter 是限流器。Middleware用于整个App或分组，设置为Server.RateLimiter后检查路由声明的限制
+type Limiter struct {
+	Store      Store
+	Algorithm  int
+	Prefix     string             //计数器键名前缀
+	Keys       map[string]KeyFunc //规则中可以使用的限流对象
+	DefaultKey string             //规则中未指定限流对象时使用的键名
+	rules      map[string]Rule    //已解析的路由规则
+	mutex      sync.RWMutex
+}
+
+// Take 消耗key的一次配额
+func (l *Limiter) Take(key string, rule Rule) (*Result, error) {
+	key = l.Prefix + key
+	if l.Algorithm == SlidingWindow {
+		return l.Store.SlidingWindow(key, rule, time.Now())
+	}
+	return l.Store.TokenBucket(key, rule, time.Now())
+}
+
+func (l *Limiter) keyFunc(name string) (KeyFunc, error) {
+	if name == `` {
+		name = l.DefaultKey
+	}
+	fn, ok := l.Keys[name]
+	if !ok {
+		return nil, fmt.Errorf(`ratelimit: unknown key %q`, name)
+	}
+	return fn, nil
+}
+
+func (l *Limiter) check(c *X.Context, key string, rule Rule) error {
+	r, err := l.Take(key, rule)
+	if err != nil {
+		return err
+	}
+	r.SetHeaders(c.Response().Header())
+	if !r.Allowed {
+		return ErrTooManyRequests
+	}
+	return nil
+}
+
+// Middleware 返回按rule限流的中间件，rule的格式参见ParseRule
+func (l *Limiter) Middleware(rule string) echo.MiddlewareFunc {
+	r, err := ParseRule(rule)
+	if err != nil {
+		panic(err)
+	}
+	keyFn, err := l.keyFunc(r.Key)
+	if err != nil {
+		panic(err)
+	}
+	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
+		return echo.HandlerFunc(func(ctx echo.Context) error {
+			c := X.X(ctx)
+			if err := l.check(c, `mw:`+r.String()+`:`+keyFn(c), r); err != nil {
+				return err
+			}
+			return h.Handle(c)
+		})
+	})
+}
+
+// Allow 检查路由u声明的限制，每个App中每个路由的计数器相互独立
+func (l *Limiter) Allow(c *X.Context, u *X.Url) error {
+	rule, err := l.rule(u.RateLimit)
+	if err != nil {
+		return err
+	}
+	keyFn, err := l.keyFunc(rule.Key)
+	if err != nil {
+		return err
+	}
+	return l.check(c, c.App.Name+`:`+u.Route+`:`+keyFn(c), rule)
+}
+
+// ParseRateLimit 检查路由声明的规则及其中的限流对象，注册路由时调用
+func (l *Limiter) ParseRateLimit(rule string) error {
+	r, err := l.rule(rule)
+	if err != nil {
+		return err
+	}
+	_, err = l.keyFunc(r.Key)
+	return err
+}
+
+func (l *Limiter) rule(s string) (Rule, error) {
+	l.mutex.RLock()
+	r, ok := l.rules[s]
+	l.mutex.RUnlock()
+	if ok {
+		return r, nil
+	}
+	r, err := ParseRule(s)
+	if err != nil {
+		return r, err
+	}
+	l.mutex.Lock()
+	l.rules[s] = r
+	l.mutex.Unlock()
+	return r, nil
+}
+
+// 根据令牌桶中剩余的令牌数生成结果，rate为每秒补充的令牌数
+func bucketResult(rule Rule, tokens float64, allowed bool) *Result {
+	rate := float64(rule.Limit) / rule.Period.Seconds()
+	capacity := float64(rule.burst())
+	r := &Result{
+		Allowed:   allowed,
+		Limit:     rule.burst(),
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration((capacity - tokens) / rate * float64(time.Second)),
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration((1 - tokens) / rate * float64(time.Second))
+	}
+	return r
+}
+
+// 根据上一个窗口和当前窗口的计数生成结果，elapsed为当前窗口已经过的时间
+func windowResult(rule Rule, prev int64, curr int64, elapsed time.Duration, allowed bool) *Result {
+	period := float64(rule.Period)
+	weight := 1 - float64(elapsed)/period
+	used := float64(prev)*weight + float64(curr)
+	r := &Result{
+		Allowed:   allowed,
+		Limit:     rule.Limit,
+		Remaining: rule.Limit - int(math.Ceil(used)),
+		Reset:     rule.Period - elapsed,
+	}
+	if r.Remaining < 0 {
+		r.Remaining = 0
+	}
+	if allowed {
+		return r
+	}
+	limit := float64(rule.Limit)
+	if float64(curr)+1 <= limit && prev > 0 {
+		//当前窗口内，上一个窗口的权重降低到足够时即可再次请求
+		r.RetryAfter = time.Duration(period * (weight - (limit-1-float64(curr))/float64(prev)))
+	} else {
+		//下一个窗口中当前窗口的计数成为上一个窗口的计数
+		wait := 0.0
+		if curr > 0 {
+			wait = 1 - (limit-1)/float64(curr)
+		}
+		r.RetryAfter = r.Reset + time.Duration(period*wait)
+	}
+	return r
+}
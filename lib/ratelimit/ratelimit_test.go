@@ -0,0 +1,203 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package ratelimit
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/cachestore"
+	"github.com/webx-top/webx/testutil"
+)
+
+func TestParseRule(t *testing.T) {
+	cases := []struct {
+		rule string
+		want Rule
+	}{
+		{`10/m`, Rule{Limit: 10, Period: time.Minute}},
+		{`100/5m user`, Rule{Limit: 100, Period: 5 * time.Minute, Key: `user`}},
+		{`1000/h apikey burst=50`, Rule{Limit: 1000, Period: time.Hour, Burst: 50, Key: `apikey`}},
+		{`30/1m30s`, Rule{Limit: 30, Period: 90 * time.Second}},
+		{`5/d`, Rule{Limit: 5, Period: 24 * time.Hour}},
+	}
+	for _, v := range cases {
+		r, err := ParseRule(v.rule)
+		if err != nil || r != v.want {
+			t.Errorf("%q: got %+v %v, want %+v", v.rule, r, err, v.want)
+		}
+	}
+	for _, rule := range []string{``, `10`, `10/`, `0/m`, `-1/m`, `x/m`, `10/x`, `10/0m`, `10/m burst=0`, `10/m burst=x`} {
+		if _, err := ParseRule(rule); err == nil {
+			t.Errorf("%q: invalid rule accepted", rule)
+		}
+	}
+}
+
+func near(a, b time.Duration) bool {
+	d := a - b
+	return d > -time.Millisecond && d < time.Millisecond
+}
+
+func TestBucketResult(t *testing.T) {
+	rule := Rule{Limit: 10, Period: 10 * time.Second}
+	r := bucketResult(rule, 4.5, true)
+	if !r.Allowed || r.Limit != 10 || r.Remaining != 4 || !near(r.Reset, 5500*time.Millisecond) || r.RetryAfter != 0 {
+		t.Errorf("allowed: %+v", r)
+	}
+	r = bucketResult(rule, 0.25, false)
+	if r.Allowed || r.Remaining != 0 || !near(r.Reset, 9750*time.Millisecond) || !near(r.RetryAfter, 750*time.Millisecond) {
+		t.Errorf("denied: %+v", r)
+	}
+	rule.Burst = 20
+	r = bucketResult(rule, 20, true)
+	if r.Limit != 20 || r.Remaining != 20 || r.Reset != 0 {
+		t.Errorf("burst: %+v", r)
+	}
+}
+
+func TestWindowResult(t *testing.T) {
+	rule := Rule{Limit: 10, Period: 10 * time.Second}
+	r := windowResult(rule, 10, 4, 5*time.Second, true)
+	if !r.Allowed || r.Remaining != 1 || !near(r.Reset, 5*time.Second) || r.RetryAfter != 0 {
+		t.Errorf("allowed: %+v", r)
+	}
+	//上一个窗口的权重降到0.4时可以再次请求
+	r = windowResult(rule, 10, 5, 5*time.Second, false)
+	if r.Allowed || r.Remaining != 0 || !near(r.RetryAfter, time.Second) {
+		t.Errorf("denied by previous window: %+v", r)
+	}
+	//当前窗口已满，须等到下一个窗口中当前窗口的权重降到0.9
+	r = windowResult(rule, 0, 10, 3*time.Second, false)
+	if r.Allowed || !near(r.Reset, 7*time.Second) || !near(r.RetryAfter, 8*time.Second) {
+		t.Errorf("denied by current window: %+v", r)
+	}
+}
+
+// 依次请求并返回每次是否被允许
+func take(fn func(now time.Time) (*Result, error), start time.Time, steps []time.Duration) ([]bool, error) {
+	allowed := make([]bool, len(steps))
+	for i, step := range steps {
+		r, err := fn(start.Add(step))
+		if err != nil {
+			return nil, err
+		}
+		allowed[i] = r.Allowed
+	}
+	return allowed, nil
+}
+
+var steps = []time.Duration{0, 0, 0, 500 * time.Millisecond, time.Second, time.Second, 3 * time.Second, 10 * time.Second}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	rule := Rule{Limit: 2, Period: 2 * time.Second}
+	start := time.Unix(1000, 0)
+	got, _ := take(func(now time.Time) (*Result, error) {
+		return s.TokenBucket(`k`, rule, now)
+	}, start, steps)
+	want := []bool{true, true, false, false, true, false, true, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("token bucket: got %v, want %v", got, want)
+			break
+		}
+	}
+	got, _ = take(func(now time.Time) (*Result, error) {
+		return s.SlidingWindow(`k`, rule, now)
+	}, start, steps)
+	want = []bool{true, true, false, false, false, false, true, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("sliding window: got %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+// 设置环境变量WEBX_TEST_REDIS(例如127.0.0.1:6379)后检查Lua脚本与MemoryStore的结果一致
+func TestRedisScripts(t *testing.T) {
+	addr := os.Getenv(`WEBX_TEST_REDIS`)
+	if addr == `` {
+		t.Skip(`WEBX_TEST_REDIS is not set`)
+	}
+	rs := NewRedisStore(cachestore.NewRedis(map[string]string{`conn`: addr}, 0))
+	ms := NewMemoryStore()
+	key := `ratelimit_test:` + strconv.FormatInt(time.Now().UnixNano(), 10)
+	start := time.Now()
+	for _, rule := range []Rule{{Limit: 2, Period: 2 * time.Second}, {Limit: 5, Period: time.Second, Burst: 3}} {
+		for name, fns := range map[string][2]func(string, Rule, time.Time) (*Result, error){
+			`token bucket`:   {rs.TokenBucket, ms.TokenBucket},
+			`sliding window`: {rs.SlidingWindow, ms.SlidingWindow},
+		} {
+			k := key + `:` + rule.String() + `:` + name
+			for _, step := range steps {
+				now := start.Add(step)
+				rr, err := fns[0](k, rule, now)
+				if err != nil {
+					t.Fatal(err)
+				}
+				mr, _ := fns[1](k, rule, now)
+				if rr.Allowed != mr.Allowed || rr.Remaining != mr.Remaining || !near(rr.Reset, mr.Reset) || !near(rr.RetryAfter, mr.RetryAfter) {
+					t.Errorf("%v %v at %v: redis %+v, memory %+v", name, rule, step, rr, mr)
+				}
+			}
+		}
+	}
+}
+
+func newTestContext(app string) *X.Context {
+	ctx, _ := testutil.Get(`http://example.com/user/1`)
+	return &X.Context{
+		Context: ctx,
+		Server:  &X.Server{},
+		App:     &X.App{Name: app},
+	}
+}
+
+func TestAllow(t *testing.T) {
+	l := New(NewMemoryStore())
+	u := X.NewUrl()
+	u.Set(`/user/:id`)
+	u.RateLimit = `1/m`
+	if err := l.Allow(newTestContext(`a`), u); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Allow(newTestContext(`a`), u); err != ErrTooManyRequests {
+		t.Errorf("second request: got %v", err)
+	}
+	c := newTestContext(`b`)
+	if err := l.Allow(c, u); err != nil {
+		t.Errorf("same route of another app: %v", err)
+	}
+	if v := c.Response().Header().Get(`RateLimit-Remaining`); v != `0` {
+		t.Errorf("RateLimit-Remaining: %q", v)
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	l := New(NewMemoryStore())
+	for rule, valid := range map[string]bool{`10/m`: true, `10/m user`: true, `10/m nobody`: false, `10/q`: false} {
+		if err := l.ParseRateLimit(rule); (err == nil) != valid {
+			t.Errorf("%q: %v", rule, err)
+		}
+	}
+}
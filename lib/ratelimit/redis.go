@@ -0,0 +1,121 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package ratelimit
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/webx-top/webx/lib/cachestore"
+	"github.com/webx-top/webx/lib/cachestore/redigo/redis"
+)
+
+var _ Store = &RedisStore{}
+
+// 令牌桶：哈希中保存剩余令牌数和上次补充的时间(毫秒)
+var tokenBucketScript = redis.NewScript(1, `
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(capacity, tokens + (now - ts) * rate)
+	ts = now
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], math.ceil((capacity - tokens) / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// 滑动窗口：KEYS[1]为当前窗口的计数器，KEYS[2]为上一个窗口的计数器
+var slidingWindowScript = redis.NewScript(2, `
+local weight = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local curr = tonumber(redis.call("GET", KEYS[1]) or "0")
+local prev = tonumber(redis.call("GET", KEYS[2]) or "0")
+if prev * weight + curr + 1 > limit then
+	return {0, prev, curr}
+end
+curr = redis.call("INCR", KEYS[1])
+if curr == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+return {1, prev, curr}
+`)
+
+// NewRedisStore 创建保存在Redis中的计数器，多个进程共享配额。计数通过Lua脚本原子地完成
+func NewRedisStore(rc *cachestore.Redis) *RedisStore {
+	return &RedisStore{Redis: rc}
+}
+
+type RedisStore struct {
+	Redis *cachestore.Redis
+}
+
+func (s *RedisStore) TokenBucket(key string, rule Rule, now time.Time) (*Result, error) {
+	rate := float64(rule.Limit) / float64(rule.Period/time.Millisecond) //每毫秒补充的令牌数
+	reply, err := redis.Values(s.Redis.Eval(tokenBucketScript, key,
+		strconv.FormatFloat(rate, 'f', -1, 64),
+		rule.burst(),
+		now.UnixNano()/int64(time.Millisecond),
+	))
+	if err != nil {
+		return nil, err
+	}
+	var (
+		allowed int
+		tokens  string
+	)
+	if _, err := redis.Scan(reply, &allowed, &tokens); err != nil {
+		return nil, err
+	}
+	n, err := strconv.ParseFloat(tokens, 64)
+	if err != nil {
+		return nil, err
+	}
+	return bucketResult(rule, n, allowed == 1), nil
+}
+
+func (s *RedisStore) SlidingWindow(key string, rule Rule, now time.Time) (*Result, error) {
+	period := int64(rule.Period)
+	index := now.UnixNano() / period
+	elapsed := time.Duration(now.UnixNano() - index*period)
+	weight := 1 - float64(elapsed)/float64(period)
+	reply, err := redis.Values(s.Redis.Eval(slidingWindowScript,
+		key+`:`+strconv.FormatInt(index, 10),
+		key+`:`+strconv.FormatInt(index-1, 10),
+		strconv.FormatFloat(weight, 'f', -1, 64),
+		rule.Limit,
+		2*period/int64(time.Millisecond),
+	))
+	if err != nil {
+		return nil, err
+	}
+	var allowed, prev, curr int64
+	if _, err := redis.Scan(reply, &allowed, &prev, &curr); err != nil {
+		return nil, err
+	}
+	return windowResult(rule, prev, curr, elapsed, allowed == 1), nil
+}
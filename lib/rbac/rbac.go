@@ -0,0 +1,232 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	X "github.com/webx-top/webx"
+)
+
+var _ X.PermChecker = &Checker{}
+
+// Role 是角色。角色拥有自己被授予的权限和所有父角色的权限
+type Role struct {
+	Name    string
+	Parents []string
+	perms   map[string]bool
+}
+
+func New() *RBAC {
+	return &RBAC{roles: make(map[string]*Role)}
+}
+
+// RBAC 管理角色、权限和角色继承关系。
+// 权限名称以点号分隔层级，授予"article.*"表示拥有article下的所有权限，授予"*"表示拥有全部权限
+type RBAC struct {
+	roles map[string]*Role
+	mutex sync.RWMutex
+}
+
+// AddRole 添加角色或修改角色的父角色。父角色必须已存在，且不能形成循环继承
+func (r *RBAC) AddRole(name string, parents ...string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, parent := range parents {
+		if _, ok := r.roles[parent]; !ok {
+			return fmt.Errorf(`rbac: parent role %q of %q does not exist`, parent, name)
+		}
+		if parent == name || r.inherits(parent, name) {
+			return fmt.Errorf(`rbac: role %q can not inherit %q: circular inheritance`, name, parent)
+		}
+	}
+	role, ok := r.roles[name]
+	if !ok {
+		role = &Role{Name: name, perms: make(map[string]bool)}
+		r.roles[name] = role
+	}
+	role.Parents = parents
+	return nil
+}
+
+// DelRole 删除角色，并从其它角色的父角色中移除
+func (r *RBAC) DelRole(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.roles, name)
+	for _, role := range r.roles {
+		parents := role.Parents[:0]
+		for _, parent := range role.Parents {
+			if parent != name {
+				parents = append(parents, parent)
+			}
+		}
+		role.Parents = parents
+	}
+}
+
+// 角色name是否(直接或间接)继承了ancestor，须在持有锁时调用
+func (r *RBAC) inherits(name string, ancestor string) bool {
+	role, ok := r.roles[name]
+	if !ok {
+		return false
+	}
+	for _, parent := range role.Parents {
+		if parent == ancestor || r.inherits(parent, ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// Grant 为角色授予权限
+func (r *RBAC) Grant(name string, perms ...string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	role, ok := r.roles[name]
+	if !ok {
+		return fmt.Errorf(`rbac: role %q does not exist`, name)
+	}
+	for _, perm := range perms {
+		role.perms[perm] = true
+	}
+	return nil
+}
+
+// Revoke 收回角色被直接授予的权限
+func (r *RBAC) Revoke(name string, perms ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	role, ok := r.roles[name]
+	if !ok {
+		return
+	}
+	for _, perm := range perms {
+		delete(role.perms, perm)
+	}
+}
+
+// IsGranted 角色是否拥有权限(包括从父角色继承的权限)
+func (r *RBAC) IsGranted(name string, perm string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.isGranted(name, perm, map[string]bool{})
+}
+
+func (r *RBAC) isGranted(name string, perm string, visited map[string]bool) bool {
+	if visited[name] {
+		return false
+	}
+	visited[name] = true
+	role, ok := r.roles[name]
+	if !ok {
+		return false
+	}
+	if matchPerm(role.perms, perm) {
+		return true
+	}
+	for _, parent := range role.Parents {
+		if r.isGranted(parent, perm, visited) {
+			return true
+		}
+	}
+	return false
+}
+
+// 检查权限，依次匹配"a.b.c"、"a.b.*"、"a.*"和"*"
+func matchPerm(perms map[string]bool, perm string) bool {
+	if perms[perm] || perms[`*`] {
+		return true
+	}
+	for i := strings.LastIndex(perm, `.`); i > 0; i = strings.LastIndex(perm[:i], `.`) {
+		if perms[perm[:i]+`.*`] {
+			return true
+		}
+	}
+	return false
+}
+
+// Perms 返回角色拥有的全部权限(包括继承的)，按名称排序
+func (r *RBAC) Perms(name string) []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	perms := map[string]bool{}
+	r.collect(name, perms, map[string]bool{})
+	list := make([]string, 0, len(perms))
+	for perm := range perms {
+		list = append(list, perm)
+	}
+	sort.Strings(list)
+	return list
+}
+
+func (r *RBAC) collect(name string, perms map[string]bool, visited map[string]bool) {
+	if visited[name] {
+		return
+	}
+	visited[name] = true
+	role, ok := r.roles[name]
+	if !ok {
+		return
+	}
+	for perm := range role.perms {
+		perms[perm] = true
+	}
+	for _, parent := range role.Parents {
+		r.collect(parent, perms, visited)
+	}
+}
+
+// Roles 返回全部角色名称，按名称排序
+func (r *RBAC) Roles() []string {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	list := make([]string, 0, len(r.roles))
+	for name := range r.roles {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// NewChecker 创建用于Server.PermChecker的权限检查器，roles返回当前用户的角色
+func NewChecker(r *RBAC, roles func(c *X.Context) ([]string, error)) *Checker {
+	return &Checker{RBAC: r, UserRoles: roles}
+}
+
+// Checker 根据当前用户的角色检查权限
+type Checker struct {
+	*RBAC
+	UserRoles func(c *X.Context) ([]string, error)
+}
+
+func (k *Checker) CheckPerm(c *X.Context, perm string) (bool, error) {
+	roles, err := k.UserRoles(c)
+	if err != nil {
+		return false, err
+	}
+	for _, role := range roles {
+		if k.IsGranted(role, perm) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
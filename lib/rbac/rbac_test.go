@@ -0,0 +1,127 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package rbac
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	X "github.com/webx-top/webx"
+)
+
+func newTestRBAC(t *testing.T) *RBAC {
+	r := New()
+	for _, role := range [][]string{
+		{`guest`},
+		{`editor`, `guest`},
+		{`reviewer`},
+		{`admin`, `editor`, `reviewer`},
+		{`root`},
+	} {
+		if err := r.AddRole(role[0], role[1:]...); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Grant(`guest`, `article.view`)
+	r.Grant(`editor`, `article.edit`, `comment.*`)
+	r.Grant(`reviewer`, `article.publish`)
+	r.Grant(`root`, `*`)
+	return r
+}
+
+func TestIsGranted(t *testing.T) {
+	r := newTestRBAC(t)
+	tests := []struct {
+		role string
+		perm string
+		ok   bool
+	}{
+		{`guest`, `article.view`, true},
+		{`guest`, `article.edit`, false},
+		{`editor`, `article.view`, true}, //继承guest
+		{`editor`, `comment.delete`, true},
+		{`editor`, `comment.reply.delete`, true},
+		{`editor`, `comment`, false},
+		{`editor`, `article.publish`, false},
+		{`admin`, `article.publish`, true}, //多个父角色
+		{`admin`, `comment.delete`, true},
+		{`root`, `anything.at.all`, true},
+		{`unknown`, `article.view`, false},
+	}
+	for _, test := range tests {
+		if ok := r.IsGranted(test.role, test.perm); ok != test.ok {
+			t.Errorf(`IsGranted(%v, %v) = %v, want %v`, test.role, test.perm, ok, test.ok)
+		}
+	}
+	if perms := strings.Join(r.Perms(`admin`), `,`); perms != `article.edit,article.publish,article.view,comment.*` {
+		t.Errorf(`Perms(admin) = %v`, perms)
+	}
+
+	r.Revoke(`guest`, `article.view`)
+	if r.IsGranted(`editor`, `article.view`) {
+		t.Error(`revoked permission is still inherited`)
+	}
+	r.DelRole(`reviewer`)
+	if r.IsGranted(`admin`, `article.publish`) {
+		t.Error(`permission of deleted role is still inherited`)
+	}
+	if roles := strings.Join(r.Roles(), `,`); roles != `admin,editor,guest,root` {
+		t.Errorf(`Roles() = %v`, roles)
+	}
+}
+
+func TestAddRoleErrors(t *testing.T) {
+	r := newTestRBAC(t)
+	if err := r.AddRole(`writer`, `missing`); err == nil {
+		t.Error(`missing parent should fail`)
+	}
+	if err := r.AddRole(`guest`, `admin`); err == nil {
+		t.Error(`circular inheritance should fail`)
+	}
+	if err := r.AddRole(`guest`, `guest`); err == nil {
+		t.Error(`inheriting itself should fail`)
+	}
+	if err := r.Grant(`missing`, `a`); err == nil {
+		t.Error(`granting to a missing role should fail`)
+	}
+}
+
+func TestChecker(t *testing.T) {
+	r := newTestRBAC(t)
+	var roles []string
+	var rolesErr error
+	k := NewChecker(r, func(c *X.Context) ([]string, error) {
+		return roles, rolesErr
+	})
+	roles = []string{`guest`, `reviewer`}
+	if ok, err := k.CheckPerm(nil, `article.publish`); !ok || err != nil {
+		t.Errorf(`CheckPerm(article.publish) = %v, %v`, ok, err)
+	}
+	if ok, _ := k.CheckPerm(nil, `article.edit`); ok {
+		t.Error(`CheckPerm(article.edit) should be denied`)
+	}
+	roles = nil
+	if ok, _ := k.CheckPerm(nil, `article.view`); ok {
+		t.Error(`user without roles should be denied`)
+	}
+	rolesErr = errors.New(`no user`)
+	if ok, err := k.CheckPerm(nil, `article.view`); ok || err == nil {
+		t.Errorf(`CheckPerm with roles error = %v, %v`, ok, err)
+	}
+}
@@ -12,8 +12,11 @@ import (
 var boltDB *bolt.DB
 var onCloseBolt func() error
 
+// BoltStore 在BoltDB中保存session数据。实现了Deleter，RegenerateID后删除原ID的数据；
+// 按用户列出和注销session(I.Manager)只由CacheStore提供
 type BoltStore interface {
 	Store
+	Deleter
 }
 
 func CloseBolt() {
@@ -32,7 +35,7 @@ func NewBoltStore(dbFile string, options I.Options, bucketName []byte, keyPairs
 	if boltDB == nil {
 		boltDB, err = bolt.Open(dbFile, 0666, nil)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		quiteC, doneC := reaper.Run(boltDB, reaper.Options{})
 		onCloseBolt = func() error {
@@ -58,14 +61,32 @@ func NewBoltStore(dbFile string, options I.Options, bucketName []byte, keyPairs
 	if err != nil {
 		return nil, err
 	}
-	return &boltStore{stor}, nil
+	if len(bucketName) == 0 {
+		//与store.New的默认值相同
+		bucketName = []byte(`sessions`)
+	}
+	return &boltStore{Store: stor, bucketName: bucketName, idleTimeout: idleTimeout(options.IdleTimeout)}, nil
 }
 
 type boltStore struct {
 	*store.Store
+	bucketName []byte
+	idleTimeout
+}
+
+// Delete 删除session数据
+func (c *boltStore) Delete(id string) error {
+	return boltDB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(c.bucketName)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.Delete([]byte(id))
+	})
 }
 
 func (c *boltStore) Options(options I.Options) {
+	c.idleTimeout = idleTimeout(options.IdleTimeout)
 	/*
 		c.Store.SessionOptions = sessions.Options{
 			Path:     options.Path,
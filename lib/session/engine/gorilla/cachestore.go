@@ -0,0 +1,448 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package session
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/admpub/sessions"
+	"github.com/gorilla/securecookie"
+	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/cachestore"
+	I "github.com/webx-top/webx/lib/session/ssi"
+)
+
+// 未设置MaxAge时session数据在缓存中的有效期(秒)
+var DefaultCacheMaxAge = 20 * 60
+
+// 记录已加入用户索引的"uid\x00ID"
+const indexedKey = `__ssi_indexed`
+
+// ErrIndexLocked 其它节点长时间持有用户索引的锁
+var ErrIndexLocked = errors.New(`session: user index is locked by another node`)
+
+var (
+	_ Deleter   = &cacheStore{}
+	_ I.Manager = &cacheStore{}
+)
+
+// CacheStore 在服务端保存session数据，支持按用户列出和注销session
+type CacheStore interface {
+	Store
+	I.Manager
+	Deleter
+	Cache() cachestore.Cache
+}
+
+// NewCacheStore 创建使用cachestore保存session数据的Store，cookie中只保存session ID。
+// prefix为缓存键名前缀，为空时使用"session_"。
+// keyPairs用于对cookie中的session ID签名(及加密)，说明参见NewCookieStore
+func NewCacheStore(cache cachestore.Cache, prefix string, keyPairs ...[]byte) CacheStore {
+	if prefix == `` {
+		prefix = `session_`
+	}
+	return &cacheStore{
+		cache:  cache,
+		prefix: prefix,
+		codecs: securecookie.CodecsFromPairs(keyPairs...),
+		options: &sessions.Options{
+			Path:   `/`,
+			MaxAge: 86400 * 30,
+		},
+	}
+}
+
+type cacheStore struct {
+	cache   cachestore.Cache
+	prefix  string
+	codecs  []securecookie.Codec
+	options *sessions.Options
+	mutex   sync.Mutex //进程内修改用户索引时加锁
+	idleTimeout
+}
+
+func (c *cacheStore) Cache() cachestore.Cache {
+	return c.cache
+}
+
+func (c *cacheStore) Options(options I.Options) {
+	c.idleTimeout = idleTimeout(options.IdleTimeout)
+	c.options = &sessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+	}
+}
+
+func (c *cacheStore) Get(ctx echo.Context, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(ctx).Get(c, name)
+}
+
+// New 创建session，cookie中有有效的session ID时从缓存中读取数据
+func (c *cacheStore) New(ctx echo.Context, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(c, name)
+	opts := *c.options
+	session.Options = &opts
+	session.IsNew = true
+	value := ctx.Request().Cookie(name)
+	if value == `` {
+		return session, nil
+	}
+	if err := c.decodeID(name, value, &session.ID); err != nil {
+		session.ID = ``
+		return session, err
+	}
+	found, err := c.load(session)
+	if err != nil {
+		return session, err
+	}
+	if !found {
+		//不使用不存在(已过期或已注销)的ID，以免session固定攻击
+		session.ID = ``
+	}
+	session.IsNew = !found
+	return session, nil
+}
+
+// Save 保存session数据并设置cookie。MaxAge小于0时删除数据和cookie；
+// 已读取的session在缓存中已不存在时不保存，并删除cookie
+func (c *cacheStore) Save(ctx echo.Context, session *sessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if session.ID != `` {
+			if err := c.Delete(session.ID); err != nil {
+				return err
+			}
+		}
+		c.setCookie(ctx, session.Name(), ``, session.Options)
+		return nil
+	}
+	if session.ID == `` {
+		session.ID = strings.TrimRight(base32.StdEncoding.EncodeToString(securecookie.GenerateRandomKey(32)), `=`)
+	} else if !session.IsNew {
+		//读取之后被注销(RevokeUser/RevokeSession)或已过期的session不再写入，以免注销失效
+		ok, err := c.cache.Has(c.prefix + session.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			opts := *session.Options
+			opts.MaxAge = -1
+			c.setCookie(ctx, session.Name(), ``, &opts)
+			return nil
+		}
+	}
+	if err := c.index(session); err != nil {
+		return err
+	}
+	if err := c.save(session); err != nil {
+		return err
+	}
+	encoded, err := c.encodeID(session.Name(), session.ID)
+	if err != nil {
+		return err
+	}
+	c.setCookie(ctx, session.Name(), encoded, session.Options)
+	return nil
+}
+
+func (c *cacheStore) encodeID(name string, id string) (string, error) {
+	if len(c.codecs) == 0 {
+		return id, nil
+	}
+	return securecookie.EncodeMulti(name, id, c.codecs...)
+}
+
+func (c *cacheStore) decodeID(name string, value string, id *string) error {
+	if len(c.codecs) == 0 {
+		*id = value
+		return nil
+	}
+	return securecookie.DecodeMulti(name, value, id, c.codecs...)
+}
+
+func (c *cacheStore) save(session *sessions.Session) error {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(session.Values); err != nil {
+		return err
+	}
+	ttl := c.ttl(session.Options.MaxAge)
+	if idle := c.IdleTimeout(); idle > 0 && time.Duration(idle)*time.Second < ttl {
+		//空闲超时后缓存自动过期
+		ttl = time.Duration(idle) * time.Second
+	}
+	return c.cache.Set(c.prefix+session.ID, buf.Bytes(), ttl)
+}
+
+func (c *cacheStore) ttl(maxAge int) time.Duration {
+	if maxAge <= 0 {
+		maxAge = DefaultCacheMaxAge
+	}
+	return time.Duration(maxAge) * time.Second
+}
+
+func (c *cacheStore) load(session *sessions.Session) (bool, error) {
+	values, err := c.values(session.ID)
+	if err != nil || values == nil {
+		return false, err
+	}
+	session.Values = values
+	return true, nil
+}
+
+// 读取session数据，不存在时返回nil
+func (c *cacheStore) values(id string) (map[interface{}]interface{}, error) {
+	v, err := c.cache.Get(c.prefix + id)
+	if err == cachestore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.([]byte)
+	if !ok {
+		return nil, errors.New(`session: invalid session data in cache`)
+	}
+	values := make(map[interface{}]interface{})
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// 将绑定了用户的session加入用户索引，用户改变时从原用户的索引中移除
+func (c *cacheStore) index(session *sessions.Session) error {
+	uid, _ := session.Values[userKey].(string)
+	indexed, _ := session.Values[indexedKey].(string)
+	current := ``
+	if uid != `` {
+		current = uid + "\x00" + session.ID
+	}
+	if indexed == current {
+		return nil
+	}
+	if indexed != `` {
+		parts := strings.SplitN(indexed, "\x00", 2)
+		if len(parts) == 2 && parts[0] != uid {
+			if err := c.removeIndex(parts[0], parts[1]); err != nil {
+				return err
+			}
+		}
+	}
+	if uid == `` {
+		delete(session.Values, indexedKey)
+		return nil
+	}
+	err := c.updateIndex(uid, func(ids []string) []string {
+		for _, id := range ids {
+			if id == session.ID {
+				return ids
+			}
+		}
+		return append(ids, session.ID)
+	})
+	if err != nil {
+		return err
+	}
+	session.Values[indexedKey] = current
+	return nil
+}
+
+func (c *cacheStore) indexKey(uid string) string {
+	return c.prefix + `user_` + uid
+}
+
+func (c *cacheStore) userIDs(uid string) ([]string, error) {
+	v, err := c.cache.Get(c.indexKey(uid))
+	if err == cachestore.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids, ok := v.([]string)
+	if !ok {
+		return nil, errors.New(`session: invalid session index in cache`)
+	}
+	return ids, nil
+}
+
+// 修改用户索引。进程内总是加锁；缓存实现了cachestore.Locker时再加分布式锁，以免多个节点同时修改，
+// 未能获得分布式锁时返回ErrIndexLocked而不是在没有锁的情况下修改
+func (c *cacheStore) updateIndex(uid string, fn func([]string) []string) error {
+	key := c.indexKey(uid)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if locker, ok := c.cache.(cachestore.Locker); ok {
+		lockKey := key + `.lock`
+		locked := false
+		for i := 0; i < 20; i++ {
+			token, ok, err := locker.Lock(lockKey, 5*time.Second)
+			if err != nil {
+				return err
+			}
+			if ok {
+				defer locker.Unlock(lockKey, token)
+				locked = true
+				break
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		if !locked {
+			return ErrIndexLocked
+		}
+	}
+	ids, err := c.userIDs(uid)
+	if err != nil {
+		return err
+	}
+	ids = fn(ids)
+	if len(ids) == 0 {
+		return c.cache.Del(key)
+	}
+	return c.cache.Set(key, ids, c.ttl(c.options.MaxAge))
+}
+
+func (c *cacheStore) removeIndex(uid string, ids ...string) error {
+	return c.updateIndex(uid, func(old []string) []string {
+		var keep []string
+		for _, id := range old {
+			if !inSlice(id, ids) {
+				keep = append(keep, id)
+			}
+		}
+		return keep
+	})
+}
+
+func inSlice(v string, items []string) bool {
+	for _, item := range items {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// 空闲超时的session视为已失效
+func (c *cacheStore) isIdle(values map[interface{}]interface{}) bool {
+	idle := c.IdleTimeout()
+	if idle <= 0 {
+		return false
+	}
+	active, ok := values[activeKey].(int64)
+	return ok && time.Now().Unix()-active > int64(idle)
+}
+
+// Delete 删除session数据并从用户索引中移除
+func (c *cacheStore) Delete(id string) error {
+	values, err := c.values(id)
+	if err != nil {
+		return err
+	}
+	if err := c.cache.Del(c.prefix + id); err != nil {
+		return err
+	}
+	if uid, _ := values[userKey].(string); uid != `` {
+		return c.removeIndex(uid, id)
+	}
+	return nil
+}
+
+// UserSessions 返回用户的有效session，已失效的session会从索引中移除
+func (c *cacheStore) UserSessions(uid string) ([]I.Info, error) {
+	ids, err := c.userIDs(uid)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		infos []I.Info
+		stale []string
+	)
+	for _, id := range ids {
+		values, err := c.values(id)
+		if err != nil {
+			return nil, err
+		}
+		if values == nil || values[userKey] != uid || c.isIdle(values) {
+			stale = append(stale, id)
+			continue
+		}
+		infos = append(infos, Info(id, values))
+	}
+	if len(stale) > 0 {
+		if err := c.removeIndex(uid, stale...); err != nil {
+			return infos, err
+		}
+	}
+	return infos, nil
+}
+
+// RevokeSession 注销指定的session
+func (c *cacheStore) RevokeSession(id string) error {
+	return c.Delete(id)
+}
+
+// RevokeUser 注销用户除except之外的所有session
+func (c *cacheStore) RevokeUser(uid string, except ...string) error {
+	ids, err := c.userIDs(uid)
+	if err != nil {
+		return err
+	}
+	var revoked []string
+	for _, id := range ids {
+		if inSlice(id, except) {
+			continue
+		}
+		if err := c.cache.Del(c.prefix + id); err != nil {
+			return err
+		}
+		revoked = append(revoked, id)
+	}
+	if len(revoked) == 0 {
+		return nil
+	}
+	return c.removeIndex(uid, revoked...)
+}
+
+func (c *cacheStore) setCookie(ctx echo.Context, name string, value string, options *sessions.Options) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     options.Path,
+		Domain:   options.Domain,
+		Secure:   options.Secure,
+		HttpOnly: options.HttpOnly,
+	}
+	if options.MaxAge > 0 {
+		cookie.MaxAge = options.MaxAge
+		cookie.Expires = time.Now().Add(time.Duration(options.MaxAge) * time.Second)
+	} else if options.MaxAge < 0 {
+		cookie.MaxAge = -1
+		cookie.Expires = time.Unix(1, 0)
+	}
+	ctx.Response().Header().Add(`Set-Cookie`, cookie.String())
+}
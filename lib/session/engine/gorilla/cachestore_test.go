@@ -0,0 +1,229 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package session
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/cachestore"
+	I "github.com/webx-top/webx/lib/session/ssi"
+	"github.com/webx-top/webx/testutil"
+)
+
+const testName = `SID`
+
+// 模拟一次携带cookie的请求，返回该请求的session
+func testSession(t *testing.T, store Store, cookie string) (*Session, echo.Context) {
+	req := testutil.NewRequest(`GET`, `/`, nil)
+	req.Header.Set(`User-Agent`, `test`)
+	if cookie != `` {
+		req.AddCookie(&http.Cookie{Name: testName, Value: cookie})
+	}
+	ctx, _ := testutil.NewContext(req)
+	sess, err := store.New(ctx, testName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Session{name: testName, context: ctx, store: store, session: sess}
+	s.checkIdle()
+	return s, ctx
+}
+
+// 保存session并返回响应中的cookie值
+func testSave(t *testing.T, s *Session, ctx echo.Context) string {
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	v := ctx.Response().Header().Get(`Set-Cookie`)
+	v = strings.TrimPrefix(v, testName+`=`)
+	if pos := strings.Index(v, `;`); pos >= 0 {
+		v = v[:pos]
+	}
+	return v
+}
+
+func newTestStore(idle int) *cacheStore {
+	store := NewCacheStore(cachestore.NewMemory(nil), ``).(*cacheStore)
+	store.Options(I.Options{Path: `/`, MaxAge: 3600, IdleTimeout: idle})
+	return store
+}
+
+func TestCacheStoreRegenerate(t *testing.T) {
+	store := newTestStore(0)
+	s, ctx := testSession(t, store, ``)
+	s.Set(`name`, `webx`)
+	id1 := testSave(t, s, ctx)
+	if id1 == `` {
+		t.Fatal(`no session cookie`)
+	}
+
+	s, ctx = testSession(t, store, id1)
+	if v := s.Get(`name`); v != `webx` {
+		t.Fatalf(`name = %v, want webx`, v)
+	}
+	s.RegenerateID()
+	id2 := testSave(t, s, ctx)
+	if id2 == `` || id2 == id1 {
+		t.Fatalf(`regenerated id = %q, old id = %q`, id2, id1)
+	}
+	if values, _ := store.values(id1); values != nil {
+		t.Error(`data of the old id should be deleted`)
+	}
+	s, _ = testSession(t, store, id2)
+	if v := s.Get(`name`); v != `webx` {
+		t.Errorf(`name after regeneration = %v, want webx`, v)
+	}
+
+	//不存在的ID不会被使用
+	s, ctx = testSession(t, store, `unknown`)
+	if id := testSave(t, s.Set(`a`, 1).(*Session), ctx); id == `unknown` {
+		t.Error(`unknown session id should not be reused`)
+	}
+}
+
+func TestCacheStoreUserIndex(t *testing.T) {
+	store := newTestStore(0)
+	var ids []string
+	for i := 0; i < 2; i++ {
+		s, ctx := testSession(t, store, ``)
+		s.SetUser(`u1`)
+		ids = append(ids, testSave(t, s, ctx))
+	}
+	infos, err := store.UserSessions(`u1`)
+	if err != nil || len(infos) != 2 {
+		t.Fatalf(`UserSessions(u1) = %v, %v`, infos, err)
+	}
+	if infos[0].User != `u1` || infos[0].UserAgent != `test` || infos[0].Created.IsZero() {
+		t.Errorf(`info = %+v`, infos[0])
+	}
+
+	//改变用户时从原用户的索引中移除
+	s, ctx := testSession(t, store, ids[1])
+	s.SetUser(`u2`)
+	testSave(t, s, ctx)
+	if infos, _ = store.UserSessions(`u1`); len(infos) != 1 || infos[0].ID != ids[0] {
+		t.Errorf(`UserSessions(u1) after switching user = %v`, infos)
+	}
+	if infos, _ = store.UserSessions(`u2`); len(infos) != 1 || infos[0].ID != ids[1] {
+		t.Errorf(`UserSessions(u2) = %v`, infos)
+	}
+
+	if err = store.RevokeUser(`u1`); err != nil {
+		t.Fatal(err)
+	}
+	if values, _ := store.values(ids[0]); values != nil {
+		t.Error(`revoked session still exists`)
+	}
+	if infos, _ = store.UserSessions(`u1`); len(infos) != 0 {
+		t.Errorf(`UserSessions(u1) after revocation = %v`, infos)
+	}
+	if err = store.RevokeSession(ids[1]); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ = store.UserSessions(`u2`); len(infos) != 0 {
+		t.Errorf(`UserSessions(u2) after revocation = %v`, infos)
+	}
+	if ids, _ := store.userIDs(`u2`); len(ids) != 0 {
+		t.Errorf(`index of u2 = %v, want empty`, ids)
+	}
+}
+
+func TestCacheStoreSaveRevoked(t *testing.T) {
+	store := newTestStore(0)
+	s, ctx := testSession(t, store, ``)
+	s.SetUser(`u1`)
+	id := testSave(t, s, ctx)
+
+	//请求处理期间session被注销，保存时不能恢复
+	s, ctx = testSession(t, store, id)
+	if err := store.RevokeUser(`u1`); err != nil {
+		t.Fatal(err)
+	}
+	s.Set(`name`, `webx`)
+	if v := testSave(t, s, ctx); v != `` {
+		t.Errorf(`cookie of the revoked session = %q, want empty`, v)
+	}
+	if values, _ := store.values(id); values != nil {
+		t.Error(`revoked session is written back`)
+	}
+	if infos, _ := store.UserSessions(`u1`); len(infos) != 0 {
+		t.Errorf(`revoked session is indexed again: %v`, infos)
+	}
+}
+
+func TestCacheStoreIdleTimeout(t *testing.T) {
+	store := newTestStore(60)
+	s, ctx := testSession(t, store, ``)
+	s.SetUser(`u1`)
+	id := testSave(t, s, ctx)
+
+	//将最后活动时间改为2分钟前
+	values, _ := store.values(id)
+	values[activeKey] = time.Now().Unix() - 120
+	sess, _ := store.New(ctx, testName)
+	sess.ID = id
+	sess.Values = values
+	if err := store.save(sess); err != nil {
+		t.Fatal(err)
+	}
+	if infos, _ := store.UserSessions(`u1`); len(infos) != 0 {
+		t.Errorf(`idle session is listed: %v`, infos)
+	}
+
+	s, ctx = testSession(t, store, id)
+	if s.User() != `` {
+		t.Error(`idle session should be cleared`)
+	}
+	newID := testSave(t, s, ctx)
+	if newID == id {
+		t.Error(`idle session should get a new id`)
+	}
+	if values, _ := store.values(id); values != nil {
+		t.Error(`data of the idle session should be deleted`)
+	}
+}
+
+// 总是无法获得锁的缓存
+type lockedCache struct {
+	*cachestore.Memory
+}
+
+func (c lockedCache) Lock(key string, ttl time.Duration) (string, bool, error) {
+	return ``, false, nil
+}
+
+func (c lockedCache) Unlock(key string, token string) error {
+	return nil
+}
+
+func TestCacheStoreIndexLocked(t *testing.T) {
+	store := NewCacheStore(lockedCache{cachestore.NewMemory(nil)}, ``).(*cacheStore)
+	err := store.updateIndex(`u1`, func(ids []string) []string {
+		return append(ids, `id`)
+	})
+	if err != ErrIndexLocked {
+		t.Errorf(`updateIndex error = %v, want ErrIndexLocked`, err)
+	}
+	if ids, _ := store.userIDs(`u1`); len(ids) != 0 {
+		t.Errorf(`index was modified without the lock: %v`, ids)
+	}
+}
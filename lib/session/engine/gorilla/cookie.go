@@ -19,14 +19,16 @@ type CookieStore interface {
 // It is recommended to use an authentication key with 32 or 64 bytes. The encryption key,
 // if set, must be either 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256 modes.
 func NewCookieStore(keyPairs ...[]byte) CookieStore {
-	return &cookieStore{sessions.NewCookieStore(keyPairs...)}
+	return &cookieStore{CookieStore: sessions.NewCookieStore(keyPairs...)}
 }
 
 type cookieStore struct {
 	*sessions.CookieStore
+	idleTimeout
 }
 
 func (c *cookieStore) Options(options I.Options) {
+	c.idleTimeout = idleTimeout(options.IdleTimeout)
 	c.CookieStore.Options = &sessions.Options{
 		Path:     options.Path,
 		Domain:   options.Domain,
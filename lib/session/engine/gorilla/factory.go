@@ -19,9 +19,12 @@ package session
 
 import (
 	"fmt"
+	"log"
 	"strconv"
+	"sync"
 
 	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/cachestore"
 	I "github.com/webx-top/webx/lib/session/ssi"
 )
 
@@ -31,54 +34,137 @@ func NewSession(options *I.Options, setting interface{}, ctx echo.Context) I.Ses
 }
 
 func NewMySession(store Store, name string, ctx echo.Context) I.Session {
-	return &Session{name, ctx, store, nil, false}
+	return &Session{name: name, context: ctx, store: store}
 }
 
+// StoreEngine 与NewStore相同，但只记录错误
+//
+// Deprecated: 使用NewStore
 func StoreEngine(options *I.Options, setting interface{}) (store Store) {
+	store, err := NewStore(options, setting)
+	if err != nil {
+		log.Printf(errorFormat, err)
+	}
+	return
+}
+
+// NewStore 根据options.Engine创建Store，setting为对应的配置：
+//
+//	cookie  string，用于签名的key
+//	file    map[string]string{"path":"","key":""}
+//	redis   map[string]string{"size":"10","network":"tcp","address":"127.0.0.1:6379","password":"","key":""}
+//	bolt    map[string]string{"file":"","name":"","key":""}
+//	cache   cachestore.Cache，或map[string]string{"adapter":"memory","config":"{}","prefix":"session_","key":""}
+//
+// 其中cache使用cachestore中注册的任意缓存后端保存session数据，config为传给cachestore.Create的配置
+func NewStore(options *I.Options, setting interface{}) (Store, error) {
 	switch options.Engine {
 	case `file`:
-		s := setting.(map[string]string)
-		path, _ := s["path"]
-		key, _ := s["key"]
-		store = NewFilesystemStore(path, []byte(key))
+		s, err := settingMap(options.Engine, setting)
+		if err != nil {
+			return nil, err
+		}
+		store := NewFilesystemStore(s["path"], []byte(s["key"]))
 		store.Options(*options)
+		return store, nil
 	case `redis`:
-		s := setting.(map[string]string)
-		sizeStr, _ := s["size"]
-		network, _ := s["network"]
-		address, _ := s["address"]
-		password, _ := s["password"]
-		key, _ := s["key"]
-		size, _ := strconv.Atoi(sizeStr)
-		if size < 1 {
-			size = 10
+		s, err := settingMap(options.Engine, setting)
+		if err != nil {
+			return nil, err
 		}
-		var err error
-		store, err = NewRedisStore(size, network, address, password, []byte(key))
+		size := 10
+		if s["size"] != `` {
+			size, err = strconv.Atoi(s["size"])
+			if err != nil {
+				return nil, fmt.Errorf(`session: invalid redis size %q: %v`, s["size"], err)
+			}
+		}
+		if s["address"] == `` {
+			return nil, fmt.Errorf(`session: redis setting has no address`)
+		}
+		network := s["network"]
+		if network == `` {
+			network = `tcp`
+		}
+		store, err := NewRedisStore(size, network, s["address"], s["password"], []byte(s["key"]))
 		if err != nil {
-			fmt.Println(err)
+			return nil, err
 		}
 		store.Options(*options)
+		return store, nil
 	case `bolt`:
-		s := setting.(map[string]string)
-		dbFile, _ := s["file"]
-		key, _ := s["key"]
-		name, _ := s["name"]
+		s, err := settingMap(options.Engine, setting)
+		if err != nil {
+			return nil, err
+		}
+		if s["file"] == `` {
+			return nil, fmt.Errorf(`session: bolt setting has no file`)
+		}
 		var bucketName []byte
-		if name != `` {
-			bucketName = []byte(name)
+		if s["name"] != `` {
+			bucketName = []byte(s["name"])
 		}
-		var err error
-		store, err = NewBoltStore(dbFile, *options, bucketName, []byte(key))
-		if err != nil {
-			fmt.Println(err)
+		return NewBoltStore(s["file"], *options, bucketName, []byte(s["key"]))
+	case `cache`:
+		var store Store
+		switch s := setting.(type) {
+		case cachestore.Cache:
+			store = NewCacheStore(s, ``)
+		case map[string]string:
+			c, err := createCache(s["adapter"], s["config"])
+			if err != nil {
+				return nil, err
+			}
+			var keyPairs [][]byte
+			if s["key"] != `` {
+				keyPairs = append(keyPairs, []byte(s["key"]))
+			}
+			store = NewCacheStore(c, s["prefix"], keyPairs...)
+		default:
+			return nil, fmt.Errorf(`session: invalid setting type %T for engine cache`, setting)
 		}
-	case `cookie`:
-		fallthrough
-	default:
-		s := setting.(string)
-		store = NewCookieStore([]byte(s))
 		store.Options(*options)
+		return store, nil
+	case `cookie`, ``:
+		s, ok := setting.(string)
+		if !ok {
+			return nil, fmt.Errorf(`session: invalid setting type %T for engine cookie`, setting)
+		}
+		store := NewCookieStore([]byte(s))
+		store.Options(*options)
+		return store, nil
 	}
-	return
+	return nil, fmt.Errorf(`session: unknown engine %q`, options.Engine)
+}
+
+func settingMap(engine string, setting interface{}) (map[string]string, error) {
+	s, ok := setting.(map[string]string)
+	if !ok {
+		return nil, fmt.Errorf(`session: invalid setting type %T for engine %s`, setting, engine)
+	}
+	return s, nil
+}
+
+var (
+	caches      = make(map[string]cachestore.Cache)
+	cachesMutex = &sync.Mutex{}
+)
+
+// 相同配置的缓存只创建一次
+func createCache(adapter string, config string) (cachestore.Cache, error) {
+	if adapter == `` {
+		return nil, fmt.Errorf(`session: cache setting has no adapter`)
+	}
+	cachesMutex.Lock()
+	defer cachesMutex.Unlock()
+	key := adapter + "\x00" + config
+	if c, ok := caches[key]; ok {
+		return c, nil
+	}
+	c, err := cachestore.Create(adapter, config)
+	if err != nil {
+		return nil, err
+	}
+	caches[key] = c
+	return c, nil
 }
@@ -1,12 +1,18 @@
 package session
 
 import (
+	"os"
+	"path/filepath"
+
 	"github.com/admpub/sessions"
 	I "github.com/webx-top/webx/lib/session/ssi"
 )
 
+// FilesystemStore 在文件中保存session数据。实现了Deleter，RegenerateID后删除原ID的数据；
+// 按用户列出和注销session(I.Manager)只由CacheStore提供
 type FilesystemStore interface {
 	Store
+	Deleter
 }
 
 // NewFilesystemStore returns a new FilesystemStore.
@@ -16,14 +22,30 @@ type FilesystemStore interface {
 //
 // See NewCookieStore() for a description of the other parameters.
 func NewFilesystemStore(path string, keyPairs ...[]byte) FilesystemStore {
-	return &filesystemStore{sessions.NewFilesystemStore(path, keyPairs...)}
+	if path == `` {
+		path = os.TempDir()
+	}
+	return &filesystemStore{FilesystemStore: sessions.NewFilesystemStore(path, keyPairs...), path: path}
 }
 
 type filesystemStore struct {
 	*sessions.FilesystemStore
+	path string
+	idleTimeout
+}
+
+// Delete 删除session文件
+func (c *filesystemStore) Delete(id string) error {
+	//文件名与sessions.FilesystemStore保存时相同
+	err := os.Remove(filepath.Join(c.path, `session_`+id))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 func (c *filesystemStore) Options(options I.Options) {
+	c.idleTimeout = idleTimeout(options.IdleTimeout)
 	c.FilesystemStore.Options = &sessions.Options{
 		Path:     options.Path,
 		Domain:   options.Domain,
@@ -6,8 +6,12 @@ import (
 	I "github.com/webx-top/webx/lib/session/ssi"
 )
 
+// RedisStore 在Redis中保存session数据。实现了Deleter，RegenerateID后删除原ID的数据；
+// 按用户列出和注销session(I.Manager)只由CacheStore提供
 type RedisStore interface {
 	Store
+	Deleter
+	SetKeyPrefix(prefix string)
 }
 
 // size: maximum number of idle connections.
@@ -28,14 +32,31 @@ func NewRedisStore(size int, network, address, password string, keyPairs ...[]by
 	if err != nil {
 		return nil, err
 	}
-	return &redisStore{store}, nil
+	return &redisStore{RediStore: store, keyPrefix: `session_`}, nil
 }
 
 type redisStore struct {
 	*redistore.RediStore
+	keyPrefix string //与RediStore中的键名前缀相同，用于Delete
+	idleTimeout
+}
+
+// SetKeyPrefix 设置session数据的键名前缀
+func (c *redisStore) SetKeyPrefix(prefix string) {
+	c.keyPrefix = prefix
+	c.RediStore.SetKeyPrefix(prefix)
+}
+
+// Delete 删除session数据
+func (c *redisStore) Delete(id string) error {
+	conn := c.RediStore.Pool.Get()
+	defer conn.Close()
+	_, err := conn.Do(`DEL`, c.keyPrefix+id)
+	return err
 }
 
 func (c *redisStore) Options(options I.Options) {
+	c.idleTimeout = idleTimeout(options.IdleTimeout)
 	c.RediStore.Options = &sessions.Options{
 		Path:     options.Path,
 		Domain:   options.Domain,
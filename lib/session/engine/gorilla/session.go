@@ -6,6 +6,8 @@ package session
 
 import (
 	"log"
+	"strings"
+	"time"
 
 	"github.com/admpub/sessions"
 	"github.com/webx-top/echo"
@@ -21,12 +23,62 @@ type Store interface {
 	Options(I.Options)
 }
 
+// Deleter is implemented by stores that keep session data on the server side.
+// It is used to remove the old data when the session ID is regenerated or the
+// session is idle for too long.
+type Deleter interface {
+	Delete(id string) error
+}
+
+// IdleTimeouter is implemented by stores that remember I.Options.IdleTimeout.
+type IdleTimeouter interface {
+	IdleTimeout() int
+}
+
+// idleTimeout is embedded by the builtin stores to implement IdleTimeouter.
+type idleTimeout int
+
+func (t idleTimeout) IdleTimeout() int {
+	return int(t)
+}
+
+// Reserved session values used for session management.
+const (
+	userKey    = `__ssi_uid`
+	createdKey = `__ssi_created`
+	activeKey  = `__ssi_active`
+	ipKey      = `__ssi_ip`
+	uaKey      = `__ssi_ua`
+)
+
+func isReservedKey(key string) bool {
+	return strings.HasPrefix(key, `__ssi_`)
+}
+
+// Info returns the management information stored in the session values.
+func Info(id string, values map[interface{}]interface{}) I.Info {
+	info := I.Info{ID: id}
+	info.User, _ = values[userKey].(string)
+	info.IP, _ = values[ipKey].(string)
+	info.UserAgent, _ = values[uaKey].(string)
+	if n, ok := values[createdKey].(int64); ok {
+		info.Created = time.Unix(n, 0)
+	}
+	if n, ok := values[activeKey].(int64); ok {
+		info.LastActive = time.Unix(n, 0)
+	}
+	return info
+}
+
 type Session struct {
-	name    string
-	context echo.Context
-	store   Store
-	session *sessions.Session
-	written bool
+	name        string
+	context     echo.Context
+	store       Store
+	session     *sessions.Session
+	written     bool
+	idleTimeout int
+	oldID       string //RegenerateID前的ID，保存时删除其数据
+	destroyed   bool
 }
 
 func (s *Session) Get(key string) interface{} {
@@ -45,9 +97,10 @@ func (s *Session) Delete(key string) I.Session {
 	return s
 }
 
+// Clear deletes all values in the session, including the bound user.
 func (s *Session) Clear() I.Session {
 	for key := range s.Session().Values {
-		if k, ok := key.(string); ok {
+		if k, ok := key.(string); ok && (k == userKey || !isReservedKey(k)) {
 			s.Delete(k)
 		}
 	}
@@ -73,6 +126,7 @@ func (s *Session) Options(options I.Options) I.Session {
 		Secure:   options.Secure,
 		HttpOnly: options.HttpOnly,
 	}
+	s.idleTimeout = options.IdleTimeout
 	return s
 }
 
@@ -81,17 +135,83 @@ func (s *Session) SetID(id string) I.Session {
 	return s
 }
 
+func (s *Session) ID() string {
+	return s.Session().ID
+}
+
+func (s *Session) RegenerateID() I.Session {
+	session := s.Session()
+	if s.oldID == `` {
+		s.oldID = session.ID
+	}
+	session.ID = ``
+	s.written = true
+	return s
+}
+
+func (s *Session) SetUser(uid string) I.Session {
+	if uid == `` {
+		return s.Delete(userKey)
+	}
+	return s.Set(userKey, uid)
+}
+
+func (s *Session) User() string {
+	uid, _ := s.Get(userKey).(string)
+	return uid
+}
+
+func (s *Session) Destroy() I.Session {
+	session := s.Session()
+	session.Values = make(map[interface{}]interface{})
+	opts := *session.Options
+	opts.MaxAge = -1
+	session.Options = &opts
+	s.destroyed = true
+	s.written = true
+	return s
+}
+
 func (s *Session) Save() error {
-	if s.Written() {
-		e := s.Session().Save(s.context)
-		if e == nil {
-			s.written = false
+	if !s.Written() {
+		return nil
+	}
+	session := s.Session()
+	if !s.destroyed {
+		s.touch(session)
+	}
+	if err := session.Save(s.context); err != nil {
+		return err
+	}
+	s.written = false
+	if s.oldID != `` && s.oldID != session.ID {
+		if d, ok := s.store.(Deleter); ok {
+			if err := d.Delete(s.oldID); err != nil {
+				return err
+			}
 		}
-		return e
 	}
+	s.oldID = ``
 	return nil
 }
 
+// 记录创建时间、最后活动时间和客户端信息
+func (s *Session) touch(session *sessions.Session) {
+	now := time.Now().Unix()
+	if _, ok := session.Values[createdKey]; !ok {
+		session.Values[createdKey] = now
+		if ipper, ok := s.context.(interface {
+			IP() string
+		}); ok {
+			session.Values[ipKey] = ipper.IP()
+		} else {
+			session.Values[ipKey] = s.context.Request().RemoteAddress()
+		}
+		session.Values[uaKey] = s.context.Request().UserAgent()
+	}
+	session.Values[activeKey] = now
+}
+
 func (s *Session) Session() *sessions.Session {
 	if s.session == nil {
 		var err error
@@ -99,10 +219,53 @@ func (s *Session) Session() *sessions.Session {
 		if err != nil {
 			log.Printf(errorFormat, err)
 		}
+		s.checkIdle()
 	}
 	return s.session
 }
 
+func (s *Session) getIdleTimeout() int {
+	if s.idleTimeout > 0 {
+		return s.idleTimeout
+	}
+	if t, ok := s.store.(IdleTimeouter); ok {
+		return t.IdleTimeout()
+	}
+	return 0
+}
+
+// 检查空闲超时：超时的session被清空并更换ID；
+// 未超时的session在距上次记录活动时间超过空闲时间的1/10(最多1分钟)后更新活动时间
+func (s *Session) checkIdle() {
+	idle := s.getIdleTimeout()
+	session := s.session
+	if idle <= 0 || session == nil || session.IsNew {
+		return
+	}
+	active, ok := session.Values[activeKey].(int64)
+	if !ok {
+		return
+	}
+	elapsed := time.Now().Unix() - active
+	if elapsed > int64(idle) {
+		if session.ID != `` {
+			s.oldID = session.ID
+		}
+		session.ID = ``
+		session.Values = make(map[interface{}]interface{})
+		session.IsNew = true
+		s.written = true
+		return
+	}
+	interval := int64(idle / 10)
+	if interval > 60 {
+		interval = 60
+	}
+	if elapsed >= interval {
+		s.written = true
+	}
+}
+
 func (s *Session) Written() bool {
 	return s.written
 }
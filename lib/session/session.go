@@ -2,6 +2,7 @@ package session
 
 import (
 	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/cachestore"
 	ss "github.com/webx-top/webx/lib/session/engine/gorilla"
 	in "github.com/webx-top/webx/lib/session/ssi"
 )
@@ -21,3 +22,22 @@ func NewMySession(store ss.Store, name string, ctx echo.Context) in.Session {
 func StoreEngine(options *in.Options, setting interface{}) (store Store) {
 	return ss.StoreEngine(options, setting)
 }
+
+// NewStore 根据options.Engine创建Store，配置有误时返回错误
+func NewStore(options *in.Options, setting interface{}) (Store, error) {
+	return ss.NewStore(options, setting)
+}
+
+// NewCacheStore 创建使用cachestore保存session数据的Store
+func NewCacheStore(cache cachestore.Cache, prefix string, keyPairs ...[]byte) Store {
+	return ss.NewCacheStore(cache, prefix, keyPairs...)
+}
+
+// Manager 返回store的session管理接口(列出和注销用户的session)，不支持时返回ssi.ErrNotSupported
+func Manager(store Store) (in.Manager, error) {
+	m, ok := store.(in.Manager)
+	if !ok {
+		return nil, in.ErrNotSupported
+	}
+	return m, nil
+}
@@ -1,5 +1,14 @@
 package ssi
 
+import (
+	"errors"
+	"time"
+)
+
+// ErrNotSupported is returned by stores that do not keep session data on the
+// server side and therefore can not list or revoke sessions.
+var ErrNotSupported = errors.New(`session: the store does not support session management`)
+
 // Wraps thinly gorilla-session methods.
 // Session stores the values and optional configuration for a session.
 type Session interface {
@@ -23,6 +32,42 @@ type Session interface {
 
 	Options(Options) Session
 
+	// ID returns the session ID. It is empty for a new session until it is saved.
+	ID() string
+	// RegenerateID assigns a new session ID when the session is saved and
+	// removes the data stored under the old ID. Call it after login to
+	// prevent session fixation.
+	RegenerateID() Session
+	// SetUser binds the session to a user so that it shows up in the user's
+	// session index. An empty uid unbinds the session.
+	SetUser(uid string) Session
+	// User returns the uid bound by SetUser.
+	User() string
+	// Destroy removes the session data and expires the cookie when saved.
+	Destroy() Session
+
 	// Save saves all sessions used during the current request.
 	Save() error
 }
+
+// Info describes an active session of a user.
+type Info struct {
+	ID         string
+	User       string
+	Created    time.Time
+	LastActive time.Time
+	IP         string
+	UserAgent  string
+}
+
+// Manager is implemented by stores that keep session data on the server side.
+// It lists and revokes the sessions of a user ("log out everywhere").
+// Among the builtin gorilla stores only the cache store implements it.
+type Manager interface {
+	// UserSessions returns the active sessions bound to uid.
+	UserSessions(uid string) ([]Info, error)
+	// RevokeSession removes the session with the given ID.
+	RevokeSession(id string) error
+	// RevokeUser removes all sessions bound to uid except the given IDs.
+	RevokeUser(uid string, except ...string) error
+}
@@ -18,4 +18,7 @@ type Options struct {
 	MaxAge   int
 	Secure   bool
 	HttpOnly bool
+	// IdleTimeout>0 expires the session after IdleTimeout seconds without
+	// activity, independently of the absolute MaxAge.
+	IdleTimeout int
 }
@@ -0,0 +1,55 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+// genmanifest 在构建发布包时为静态文件生成带内容指纹的Manifest，供tplfunc.LoadManifest读取。
+//
+//	genmanifest -root public/assets -out public/assets/manifest.json -ext .js,.css,.png
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/webx-top/webx/lib/tplfunc"
+)
+
+func main() {
+	root := flag.String("root", "assets", "static files root path")
+	out := flag.String("out", "", "manifest file, default is <root>/manifest.json")
+	ext := flag.String("ext", "", "comma separated file extensions, default is all files")
+	flag.Parse()
+
+	var exts []string
+	if *ext != "" {
+		exts = strings.Split(*ext, ",")
+	}
+	m, err := tplfunc.BuildManifest(*root, exts...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	file := *out
+	if file == "" {
+		file = strings.TrimSuffix(*root, "/") + "/manifest.json"
+	}
+	delete(m, "manifest.json")
+	if err := m.Save(file); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d files written to %s\n", len(m), file)
+}
@@ -0,0 +1,155 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package tplfunc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/webx-top/webx/lib/com"
+)
+
+// 内容指纹的长度(md5的前若干位)
+const fingerprintLen = 10
+
+// Manifest 是静态文件路径与带指纹的路径的对应关系，如："js/app.js" => "js/app.3f2a9c1b0e.js"。
+// 路径均相对于Static.RootPath
+type Manifest map[string]string
+
+// BuildManifest 为rootPath中的文件生成Manifest，一般在构建发布包时执行(见genmanifest)。
+// exts为需要加指纹的文件扩展名(如：".js")，为空时包括所有文件；已带指纹的文件和预压缩文件(.gz等)会被忽略
+func BuildManifest(rootPath string, exts ...string) (Manifest, error) {
+	m := Manifest{}
+	err := filepath.Walk(rootPath, func(file string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		ext := filepath.Ext(file)
+		if len(exts) > 0 && !com.InSlice(ext, exts) {
+			return nil
+		}
+		if ext == `.gz` || ext == `.zz` || ext == `.br` {
+			return nil
+		}
+		name, err := filepath.Rel(rootPath, file)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		if _, hash := StripFingerprint(name); hash != `` {
+			return nil
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		m[name] = FingerprintName(name, content)
+		return nil
+	})
+	return m, err
+}
+
+// LoadManifest 读取由Manifest.Save保存的文件
+func LoadManifest(file string) (Manifest, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	m := Manifest{}
+	err = json.Unmarshal(b, &m)
+	return m, err
+}
+
+// Save 以JSON格式保存
+func (m Manifest) Save(file string) error {
+	b, err := json.MarshalIndent(m, ``, `  `)
+	if err != nil {
+		return err
+	}
+	return com.WriteFile(file, b)
+}
+
+// FingerprintName 在文件名的扩展名之前插入内容指纹，如："js/app.js" => "js/app.3f2a9c1b0e.js"
+func FingerprintName(name string, content []byte) string {
+	ext := path.Ext(name)
+	return name[:len(name)-len(ext)] + `.` + com.Md5(string(content))[:fingerprintLen] + ext
+}
+
+// StripFingerprint 去掉文件名中的内容指纹，返回原文件名和指纹。不带指纹时hash为空字符串
+func StripFingerprint(name string) (orig string, hash string) {
+	ext := path.Ext(name)
+	base := name[:len(name)-len(ext)]
+	if v := path.Ext(base); isFingerprint(v) {
+		return base[:len(base)-len(v)] + ext, v[1:]
+	}
+	if isFingerprint(ext) {
+		return base, ext[1:]
+	}
+	return name, ``
+}
+
+func isFingerprint(ext string) bool {
+	if len(ext) != fingerprintLen+1 {
+		return false
+	}
+	return strings.Trim(ext[1:], `0123456789abcdef`) == ``
+}
+
+// 缓存的文件指纹，文件修改后重新计算
+type fingerprint struct {
+	modTime int64
+	size    int64
+	path    string
+}
+
+// FingerprintPath 返回带有内容指纹的文件路径。优先使用Manifest，其中没有时根据文件内容计算，
+// 文件不存在时返回原路径
+func (s *Static) FingerprintPath(staticFile string) string {
+	if p, ok := s.Manifest[staticFile]; ok {
+		return p
+	}
+	fi, err := os.Stat(filepath.Join(s.RootPath, filepath.FromSlash(staticFile)))
+	if err != nil || fi.IsDir() {
+		return staticFile
+	}
+	s.mutex.Lock()
+	fp, ok := s.fingerprints[staticFile]
+	s.mutex.Unlock()
+	if ok && fp.modTime == fi.ModTime().UnixNano() && fp.size == fi.Size() {
+		return fp.path
+	}
+	content, err := ioutil.ReadFile(filepath.Join(s.RootPath, filepath.FromSlash(staticFile)))
+	if err != nil {
+		return staticFile
+	}
+	p := FingerprintName(staticFile, content)
+	s.mutex.Lock()
+	if s.fingerprints == nil {
+		s.fingerprints = make(map[string]*fingerprint)
+	}
+	s.fingerprints[staticFile] = &fingerprint{modTime: fi.ModTime().UnixNano(), size: fi.Size(), path: p}
+	s.mutex.Unlock()
+	return p
+}
@@ -0,0 +1,36 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package tplfunc
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	for _, name := range []string{`js/app.js`, `js/jquery.min.js`, `LICENSE`} {
+		fp := FingerprintName(name, []byte(`content`))
+		if fp == name {
+			t.Errorf(`%s: fingerprint not added`, name)
+		}
+		orig, hash := StripFingerprint(fp)
+		if orig != name || hash == `` {
+			t.Errorf("StripFingerprint(%q) = %q, %q; want %q", fp, orig, hash, name)
+		}
+	}
+	if orig, hash := StripFingerprint(`js/jquery.min.js`); orig != `js/jquery.min.js` || hash != `` {
+		t.Errorf(`unexpected fingerprint %q in %q`, hash, orig)
+	}
+}
@@ -39,6 +39,13 @@ var (
 	regexCssCleanComment *regexp.Regexp = regexp.MustCompile(`(?s)[\s]*/\*(.*?)\*/[\s]*`)
 )
 
+// Compressor 生成预压缩的内容，如：compress.Compress。
+// 预压缩文件可由static.Handler或compress中间件的StaticDirs直接输出
+type Compressor interface {
+	Variants(contentType string, content []byte) (map[string][]byte, error) //键为压缩方式
+	Ext(encoding string) string                                             //压缩方式对应的文件扩展名
+}
+
 func NewStatic(staticPath, rootPath string) *Static {
 	return &Static{
 		Path:            staticPath,
@@ -60,10 +67,17 @@ type Static struct {
 	CombineSavePath string //合并文件保存路径，首尾均不带斜杠
 	Combined        map[string][]string
 	Combines        map[string]bool
+	Compress        Compressor //不为nil时为合并后的文件生成预压缩版本(如：xxx.js.gz)
+	Fingerprint     bool       //网址中是否带有内容指纹(如：js/app.3f2a9c1b0e.js)，文件内容改变后网址随之改变
+	Manifest        Manifest   //构建时生成的指纹，其中没有的文件在使用时根据内容计算
+	fingerprints    map[string]*fingerprint
 	mutex           *sync.Mutex
 }
 
 func (s *Static) StaticUrl(staticFile string) (r string) {
+	if s.Fingerprint {
+		staticFile = s.FingerprintPath(staticFile)
+	}
 	r = s.Path + "/" + staticFile
 	return
 }
@@ -84,10 +98,14 @@ func (s *Static) ImgUrl(staticFile string) (r string) {
 }
 
 func (s *Static) JsTag(staticFiles ...string) template.HTML {
+	return s.jsTag(``, staticFiles...)
+}
+
+func (s *Static) jsTag(nonce string, staticFiles ...string) template.HTML {
 	var r string
 	if len(staticFiles) == 1 || !s.CombineJs {
 		for _, staticFile := range staticFiles {
-			r += `<script type="text/javascript" src="` + s.JsUrl(staticFile) + `" charset="utf-8"></script>`
+			r += `<script type="text/javascript" src="` + s.JsUrl(staticFile) + `" charset="utf-8"` + nonceAttr(nonce) + `></script>`
 		}
 		return template.HTML(r)
 	}
@@ -115,17 +133,22 @@ func (s *Static) JsTag(staticFiles ...string) template.HTML {
 			//fmt.Println(url)
 		}
 		com.WriteFile(s.RootPath+"/"+r, []byte(content))
+		s.writeVariants(r, `application/javascript`, []byte(content))
 		s.RecordCombines(r)
 	}
-	r = `<script type="text/javascript" src="` + s.StaticUrl(r) + `" charset="utf-8"></script>`
+	r = `<script type="text/javascript" src="` + s.StaticUrl(r) + `" charset="utf-8"` + nonceAttr(nonce) + `></script>`
 	return template.HTML(r)
 }
 
 func (s *Static) CssTag(staticFiles ...string) template.HTML {
+	return s.cssTag(``, staticFiles...)
+}
+
+func (s *Static) cssTag(nonce string, staticFiles ...string) template.HTML {
 	var r string
 	if len(staticFiles) == 1 || !s.CombineCss {
 		for _, staticFile := range staticFiles {
-			r += `<link rel="stylesheet" type="text/css" href="` + s.CssUrl(staticFile) + `" charset="utf-8" />`
+			r += `<link rel="stylesheet" type="text/css" href="` + s.CssUrl(staticFile) + `" charset="utf-8"` + nonceAttr(nonce) + ` />`
 		}
 		return template.HTML(r)
 	}
@@ -178,9 +201,10 @@ func (s *Static) CssTag(staticFiles ...string) template.HTML {
 			}
 		}
 		com.WriteFile(s.RootPath+"/"+r, []byte(content))
+		s.writeVariants(r, `text/css`, []byte(content))
 		s.RecordCombines(r)
 	}
-	r = `<link rel="stylesheet" type="text/css" href="` + s.StaticUrl(r) + `" charset="utf-8" />`
+	r = `<link rel="stylesheet" type="text/css" href="` + s.StaticUrl(r) + `" charset="utf-8"` + nonceAttr(nonce) + ` />`
 	return template.HTML(r)
 }
 
@@ -208,6 +232,50 @@ func (s *Static) Register(funcMap map[string]interface{}) map[string]interface{}
 	return funcMap
 }
 
+// NonceFuncs 返回输出带有CSP nonce属性的JsTag和CssTag模板函数，用于secure中间件的NonceFuncs
+func (s *Static) NonceFuncs(nonce string) map[string]interface{} {
+	return map[string]interface{}{
+		"JsTag": func(staticFiles ...string) template.HTML {
+			return s.jsTag(nonce, staticFiles...)
+		},
+		"CssTag": func(staticFiles ...string) template.HTML {
+			return s.cssTag(nonce, staticFiles...)
+		},
+	}
+}
+
+// 保存合并后文件的预压缩版本
+func (s *Static) writeVariants(combineUrl string, contentType string, content []byte) {
+	if s.Compress == nil {
+		return
+	}
+	variants, err := s.Compress.Variants(contentType, content)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for encoding, data := range variants {
+		if err := com.WriteFile(s.RootPath+"/"+combineUrl+s.Compress.Ext(encoding), data); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// 删除文件的预压缩版本
+func removeVariants(file string) {
+	variants, _ := filepath.Glob(file + ".*")
+	for _, v := range variants {
+		os.Remove(v)
+	}
+}
+
+func nonceAttr(nonce string) string {
+	if nonce == `` {
+		return ``
+	}
+	return ` nonce="` + nonce + `"`
+}
+
 func (s *Static) DeleteCombined(url string) {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
@@ -217,6 +285,7 @@ func (s *Static) DeleteCombined(url string) {
 				continue
 			}
 			err := os.Remove(filepath.Join(s.RootPath, v))
+			removeVariants(filepath.Join(s.RootPath, v))
 			delete(s.Combines, v)
 			if err != nil {
 				fmt.Println(err)
@@ -259,6 +328,7 @@ func (s *Static) IsCombined(combineUrl string) (ok bool) {
 func (s *Static) ClearCache() {
 	for f, _ := range s.Combines {
 		os.Remove(filepath.Join(s.RootPath, f))
+		removeVariants(filepath.Join(s.RootPath, f))
 	}
 	s.Combined = make(map[string][]string)
 	s.Combines = make(map[string]bool)
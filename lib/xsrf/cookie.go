@@ -34,7 +34,7 @@ func (c *SecCookieStorage) Set(key, val string, ctx echo.Context) {
 }
 
 func (c *SecCookieStorage) Valid(key, val string, ctx echo.Context) bool {
-	return c.Get(key, ctx) == val
+	return validToken(c.Get(key, ctx), val)
 }
 
 type CookieStorage struct {
@@ -49,5 +49,21 @@ func (c *CookieStorage) Set(key, val string, ctx echo.Context) {
 }
 
 func (c *CookieStorage) Valid(key, val string, ctx echo.Context) bool {
-	return c.Get(key, ctx) == val
+	return validToken(c.Get(key, ctx), val)
+}
+
+// ReadableCookieStorage 将令牌保存在JavaScript可读(非HttpOnly)的cookie中，用于双重提交cookie方式
+type ReadableCookieStorage struct {
+}
+
+func (c *ReadableCookieStorage) Get(key string, ctx echo.Context) string {
+	return X.X(ctx).GetCookie(key)
+}
+
+func (c *ReadableCookieStorage) Set(key, val string, ctx echo.Context) {
+	X.X(ctx).Cookie(key, val).HttpOnly(false).Send(ctx)
+}
+
+func (c *ReadableCookieStorage) Valid(key, val string, ctx echo.Context) bool {
+	return validToken(c.Get(key, ctx), val)
 }
@@ -44,5 +44,5 @@ func (c *SessionStorage) Set(key, val string, ctx echo.Context) {
 }
 
 func (c *SessionStorage) Valid(key, val string, ctx echo.Context) bool {
-	return c.Get(key, ctx) == val
+	return validToken(c.Get(key, ctx), val)
 }
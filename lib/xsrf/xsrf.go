@@ -18,19 +18,44 @@
 package xsrf
 
 import (
-	"errors"
+	"crypto/subtle"
 	"fmt"
 	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
 	"github.com/webx-top/webx/lib/com"
 	"github.com/webx-top/webx/lib/uuid"
 )
 
+// 令牌的保存和提交方式
+const (
+	//令牌保存在Manager中(默认为加密cookie)，请求通过表单字段或请求头提交
+	ModeToken = iota
+	//双重提交cookie：令牌保存在JavaScript可读的cookie(CookieName)中，请求通过请求头或表单字段提交相同的值
+	ModeDoubleSubmit
+)
+
+var (
+	ErrTokenMissing   = X.NewError(http.StatusForbidden, `xsrf token is missing`, `xsrf_token_missing`)
+	ErrTokenInvalid   = X.NewError(http.StatusForbidden, `xsrf token is invalid`, `xsrf_token_invalid`)
+	ErrOriginMismatch = X.NewError(http.StatusForbidden, `request origin is not allowed`, `xsrf_origin_mismatch`)
+	ErrRefererMissing = X.NewError(http.StatusForbidden, `referer is required for secure requests`, `xsrf_referer_missing`)
+)
+
+// 令牌绑定的用户在session中的键名
+const userKey = `webx:xsrfUser`
+
 func New(args ...Manager) *Xsrf {
 	x := &Xsrf{
-		FieldName: `_xsrf`,
-		On:        true,
+		FieldName:   `_xsrf`,
+		HeaderName:  `X-XSRF-Token`,
+		CookieName:  `XSRF-TOKEN`,
+		On:          true,
+		CheckOrigin: true,
 	}
 	if len(args) > 0 {
 		x.Manager = args[0]
@@ -42,21 +67,59 @@ func New(args ...Manager) *Xsrf {
 	return x
 }
 
+// NewDoubleSubmit 创建使用双重提交cookie方式的Xsrf，适用于前端从cookie中读取令牌并放入请求头的AJAX客户端
+func NewDoubleSubmit() *Xsrf {
+	x := New(&ReadableCookieStorage{})
+	x.Mode = ModeDoubleSubmit
+	return x
+}
+
 type Xsrf struct {
 	Manager
-	FieldName string
-	On        bool
+	Mode           int      //ModeToken或ModeDoubleSubmit
+	FieldName      string   //表单字段名，也是令牌在Manager中的键名
+	HeaderName     string   //请求头名称，AJAX客户端通过它提交令牌
+	CookieName     string   //ModeDoubleSubmit时保存令牌的cookie名称(不含Server.CookiePrefix)
+	On             bool     //是否启用
+	CheckOrigin    bool     //是否检查Origin/Referer与当前网站同源
+	TrustedOrigins []string //允许的其它来源，例如：https://admin.example.com
+	PerSession     bool     //令牌与session中的用户绑定，登录、注销或切换用户后自动更换令牌
+}
+
+func (c *Xsrf) key() string {
+	if c.Mode == ModeDoubleSubmit {
+		return c.CookieName
+	}
+	return c.FieldName
 }
 
 func (c *Xsrf) Value(ctx echo.Context) string {
-	var val string = c.Manager.Get(c.FieldName, ctx)
-	if val == "" {
-		val = uuid.NewRandom().String()
-		c.Manager.Set(c.FieldName, val, ctx)
+	var val string = c.Manager.Get(c.key(), ctx)
+	if val == "" || (c.PerSession && c.userChanged(ctx)) {
+		val = c.Rotate(ctx)
 	}
 	return val
 }
 
+// Rotate 生成新令牌，之前的令牌随即失效。在提升权限等操作后调用
+func (c *Xsrf) Rotate(ctx echo.Context) string {
+	val := uuid.NewRandom().String()
+	c.Manager.Set(c.key(), val, ctx)
+	if c.PerSession {
+		s := X.X(ctx).Session()
+		s.Set(userKey, s.User())
+		s.Save()
+	}
+	return val
+}
+
+// 令牌生成后session中的用户是否已改变
+func (c *Xsrf) userChanged(ctx echo.Context) bool {
+	s := X.X(ctx).Session()
+	bound, _ := s.Get(userKey).(string)
+	return bound != s.User()
+}
+
 func (c *Xsrf) Form(ctx echo.Context) template.HTML {
 	var html string
 	if c.On {
@@ -79,8 +142,14 @@ func (c *Xsrf) Register(ctx echo.Context) {
 	ctx.SetFunc("XsrfName", func() string {
 		return c.FieldName
 	})
+	ctx.SetFunc("XsrfHeader", func() string {
+		return c.HeaderName
+	})
 }
 
+// Middleware 对GET、HEAD、OPTIONS、TRACE以外的请求检查来源和令牌。
+// ModeDoubleSubmit时，安全方法的请求在cookie中没有令牌时下发令牌，供前端读取。
+// 检查失败时返回*webx.Error，由Server.HTTPErrorHandler按Context.Format输出
 func (c *Xsrf) Middleware() echo.MiddlewareFunc {
 	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
 		return echo.HandlerFunc(func(ctx echo.Context) error {
@@ -91,18 +160,92 @@ func (c *Xsrf) Middleware() echo.MiddlewareFunc {
 				return h.Handle(ctx)
 			}
 			c.Register(ctx)
-			val := c.Value(ctx)
-			if ctx.Request().Method() == `POST` {
-				formVal := ctx.Form(c.FieldName)
-				if formVal == "" || val != formVal {
-					return errors.New("xsrf token error.")
+			if isSafeMethod(ctx.Request().Method()) {
+				if c.Mode == ModeDoubleSubmit {
+					c.Value(ctx)
 				}
+				return h.Handle(ctx)
+			}
+			if err := c.Check(ctx); err != nil {
+				return err
 			}
 			return h.Handle(ctx)
 		})
 	})
 }
 
+// Check 检查请求的来源和令牌
+func (c *Xsrf) Check(ctx echo.Context) error {
+	if c.CheckOrigin {
+		if err := c.checkOrigin(X.X(ctx)); err != nil {
+			return err
+		}
+	}
+	token := ctx.Request().Header().Get(c.HeaderName)
+	if token == `` {
+		token = ctx.Form(c.FieldName)
+	}
+	if token == `` {
+		return ErrTokenMissing
+	}
+	if c.PerSession && c.userChanged(ctx) {
+		return ErrTokenInvalid
+	}
+	if !c.Manager.Valid(c.key(), token, ctx) {
+		return ErrTokenInvalid
+	}
+	return nil
+}
+
+// 检查Origin请求头，没有时检查Referer。HTTPS请求两者都没有时拒绝
+func (c *Xsrf) checkOrigin(ctx *X.Context) error {
+	origin := ctx.Header(`Origin`)
+	if origin == `` {
+		referer := ctx.Referer()
+		if referer == `` {
+			if ctx.IsSecure() {
+				return ErrRefererMissing
+			}
+			return nil
+		}
+		u, err := url.Parse(referer)
+		if err != nil {
+			return ErrOriginMismatch
+		}
+		origin = u.Scheme + `://` + u.Host
+	}
+	if c.sameOrigin(ctx, origin) {
+		return nil
+	}
+	return ErrOriginMismatch
+}
+
+func (c *Xsrf) sameOrigin(ctx *X.Context, origin string) bool {
+	origin = strings.ToLower(origin)
+	if origin == strings.ToLower(ctx.Forwarded().Origin()) {
+		return true
+	}
+	for _, trusted := range c.TrustedOrigins {
+		if origin == strings.ToLower(strings.TrimSuffix(trusted, `/`)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case echo.GET, echo.HEAD, echo.OPTIONS, echo.TRACE:
+		return true
+	}
+	return false
+}
+
+// 以固定时间比较令牌，避免时序攻击
+func validToken(expected string, token string) bool {
+	return expected != `` && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1
+}
+
 type Manager interface {
 	Get(key string, ctx echo.Context) string
 	Set(key, val string, ctx echo.Context)
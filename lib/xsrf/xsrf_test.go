@@ -0,0 +1,180 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package xsrf
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/webx-top/echo"
+	X "github.com/webx-top/webx"
+	"github.com/webx-top/webx/lib/session/ssi"
+	"github.com/webx-top/webx/testutil"
+)
+
+type testSession struct {
+	ssi.Session
+	user   string
+	values map[string]interface{}
+}
+
+func (s *testSession) User() string               { return s.user }
+func (s *testSession) Get(key string) interface{} { return s.values[key] }
+func (s *testSession) Set(key string, val interface{}) ssi.Session {
+	s.values[key] = val
+	return s
+}
+func (s *testSession) Save() error { return nil }
+
+// 保存在内存中的Manager
+type testManager map[string]string
+
+func (m testManager) Get(key string, ctx echo.Context) string { return m[key] }
+func (m testManager) Set(key, val string, ctx echo.Context)   { m[key] = val }
+func (m testManager) Valid(key, val string, ctx echo.Context) bool {
+	return validToken(m[key], val)
+}
+
+func newTestContext(method string, header map[string]string, form map[string]string, sess *testSession) *X.Context {
+	values := url.Values{}
+	for k, v := range form {
+		values.Set(k, v)
+	}
+	req := testutil.NewRequest(method, `http://example.com/`, strings.NewReader(values.Encode()))
+	req.Header.Set(`Content-Type`, `application/x-www-form-urlencoded`)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	ctx, _ := testutil.NewContext(req)
+	c := &X.Context{Context: ctx, Server: &X.Server{}}
+	if sess != nil {
+		c.InitSession(sess)
+	}
+	return c
+}
+
+func serve(x *Xsrf, c *X.Context) (called bool, err error) {
+	h := echo.HandlerFunc(func(echo.Context) error {
+		called = true
+		return nil
+	})
+	err = x.Middleware()(h).Handle(c)
+	return
+}
+
+func TestDoubleSubmitIssueCookie(t *testing.T) {
+	m := testManager{}
+	x := New(m)
+	x.Mode = ModeDoubleSubmit
+	if called, err := serve(x, newTestContext(echo.GET, nil, nil, nil)); !called || err != nil {
+		t.Fatalf("GET: called=%v err=%v", called, err)
+	}
+	token := m[x.CookieName]
+	if token == `` {
+		t.Fatal("token cookie is not issued on a safe request")
+	}
+	serve(x, newTestContext(echo.GET, nil, nil, nil))
+	if m[x.CookieName] != token {
+		t.Error("existing token cookie is replaced")
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	m := testManager{}
+	x := New(m)
+	x.CheckOrigin = false
+	token := x.Rotate(newTestContext(echo.GET, nil, nil, nil))
+	cases := []struct {
+		header map[string]string
+		form   map[string]string
+		err    error
+	}{
+		{nil, nil, ErrTokenMissing},
+		{map[string]string{x.HeaderName: token}, nil, nil},
+		{nil, map[string]string{x.FieldName: token}, nil},
+		{map[string]string{x.HeaderName: `forged`}, map[string]string{x.FieldName: token}, ErrTokenInvalid},
+		{nil, map[string]string{x.FieldName: `forged`}, ErrTokenInvalid},
+	}
+	for i, v := range cases {
+		called, err := serve(x, newTestContext(echo.POST, v.header, v.form, nil))
+		if err != v.err || called != (v.err == nil) {
+			t.Errorf("case %d: called=%v err=%v, want %v", i, called, err, v.err)
+		}
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	m := testManager{}
+	x := New(m)
+	x.TrustedOrigins = []string{`https://admin.example.com/`}
+	token := x.Rotate(newTestContext(echo.GET, nil, nil, nil))
+	cases := []struct {
+		origin  string
+		referer string
+		err     error
+	}{
+		{``, ``, nil},
+		{`http://example.com`, ``, nil},
+		{`HTTP://EXAMPLE.COM`, ``, nil},
+		{`https://admin.example.com`, ``, nil},
+		{`http://evil.com`, ``, ErrOriginMismatch},
+		{`http://example.com:8080`, ``, ErrOriginMismatch},
+		{``, `http://example.com/form`, nil},
+		{``, `http://evil.com/form`, ErrOriginMismatch},
+		{`http://evil.com`, `http://example.com/form`, ErrOriginMismatch},
+	}
+	for i, v := range cases {
+		header := map[string]string{x.HeaderName: token}
+		if v.origin != `` {
+			header[`Origin`] = v.origin
+		}
+		if v.referer != `` {
+			header[`Referer`] = v.referer
+		}
+		if _, err := serve(x, newTestContext(echo.POST, header, nil, nil)); err != v.err {
+			t.Errorf("case %d: got %v, want %v", i, err, v.err)
+		}
+	}
+}
+
+func TestPerSession(t *testing.T) {
+	m := testManager{}
+	x := New(m)
+	x.CheckOrigin = false
+	x.PerSession = true
+	sess := &testSession{user: `alice`, values: map[string]interface{}{}}
+	token := x.Value(newTestContext(echo.GET, nil, nil, sess))
+	header := map[string]string{x.HeaderName: token}
+	if _, err := serve(x, newTestContext(echo.POST, header, nil, sess)); err != nil {
+		t.Fatalf("same user: %v", err)
+	}
+
+	sess.user = `bob`
+	if _, err := serve(x, newTestContext(echo.POST, header, nil, sess)); err != ErrTokenInvalid {
+		t.Errorf("token of another user: got %v", err)
+	}
+	renewed := x.Value(newTestContext(echo.GET, nil, nil, sess))
+	if renewed == token {
+		t.Error("token is not rotated after the user changed")
+	}
+	header[x.HeaderName] = renewed
+	if _, err := serve(x, newTestContext(echo.POST, header, nil, sess)); err != nil {
+		t.Errorf("renewed token: %v", err)
+	}
+}
@@ -0,0 +1,103 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"net/http"
+	"sort"
+)
+
+// PermChecker 检查当前请求是否拥有路由声明的权限(perm标签或WrapperRoute.Perm)。
+// 在控制器的Init之后、Before之前执行
+type PermChecker interface {
+	CheckPerm(c *Context, perm string) (bool, error)
+}
+
+// PermCheckerFunc 将函数转换为PermChecker
+type PermCheckerFunc func(c *Context, perm string) (bool, error)
+
+func (f PermCheckerFunc) CheckPerm(c *Context, perm string) (bool, error) {
+	return f(c, perm)
+}
+
+// Permission 是路由声明的权限及使用该权限的路由
+type Permission struct {
+	Name string
+	Urls []*Url
+}
+
+// Perms 返回所有路由声明的权限，按名称排序，可用于在后台列出权限供分配
+func (a *URL) Perms() []*Permission {
+	perms := map[string]*Permission{}
+	for _, u := range a.urls {
+		if u.Perm == `` {
+			continue
+		}
+		p, ok := perms[u.Perm]
+		if !ok {
+			p = &Permission{Name: u.Perm}
+			perms[u.Perm] = p
+		}
+		p.Urls = append(p.Urls, u)
+	}
+	list := make([]*Permission, 0, len(perms))
+	for _, p := range perms {
+		sort.Sort(urlsByRoute(p.Urls))
+		list = append(list, p)
+	}
+	sort.Sort(permsByName(list))
+	return list
+}
+
+type permsByName []*Permission
+
+func (p permsByName) Len() int           { return len(p) }
+func (p permsByName) Less(i, j int) bool { return p[i].Name < p[j].Name }
+func (p permsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+type urlsByRoute []*Url
+
+func (u urlsByRoute) Len() int           { return len(u) }
+func (u urlsByRoute) Less(i, j int) bool { return u[i].Route < u[j].Route }
+func (u urlsByRoute) Swap(i, j int)      { u[i], u[j] = u[j], u[i] }
+
+// 检查路由u声明的权限，没有权限时输出NO_PERM并设置c.Exit。
+// 路由声明了权限但没有设置Server.PermChecker时拒绝访问，以免配置遗漏导致权限失效
+func (c *Context) checkPerm(u *Url) error {
+	if u.Perm == `` {
+		return nil
+	}
+	if c.Server.PermChecker == nil {
+		return c.denyPerm()
+	}
+	ok, err := c.Server.PermChecker.CheckPerm(c, u.Perm)
+	if err != nil || ok {
+		return err
+	}
+	return c.denyPerm()
+}
+
+// 输出NO_PERM并设置c.Exit
+func (c *Context) denyPerm() error {
+	c.SetNoPerm(http.StatusText(http.StatusForbidden))
+	c.Exit = true
+	if c.Server.ErrorTemplate != `` {
+		c.Tmpl = c.Server.ErrorTemplate
+	}
+	return c.Display(http.StatusForbidden)
+}
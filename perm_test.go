@@ -0,0 +1,80 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/webx-top/webx/testutil"
+)
+
+// 创建输出格式为test的Context，rendered记录输出时的状态码
+func newTestContext(s *Server, rendered *int) *Context {
+	s.Formats = NewFormats()
+	s.Formats.Register(`test`, func(c *Context) error {
+		*rendered = c.Code
+		return nil
+	})
+	ec, _ := testutil.Get(`/`)
+	return &Context{
+		Context: ec,
+		Server:  s,
+		App:     &App{Name: `test`},
+		Output:  &Output{},
+		Format:  `test`,
+	}
+}
+
+func TestCheckPerm(t *testing.T) {
+	granted := map[string]bool{`article.edit`: true}
+	checker := PermCheckerFunc(func(c *Context, perm string) (bool, error) {
+		if perm == `error` {
+			return false, errors.New(`checker error`)
+		}
+		return granted[perm], nil
+	})
+	tests := []struct {
+		checker PermChecker
+		perm    string
+		code    int //0表示未拒绝
+		err     bool
+	}{
+		{checker, ``, 0, false},
+		{checker, `article.edit`, 0, false},
+		{checker, `article.delete`, http.StatusForbidden, false},
+		{checker, `error`, 0, true},
+		{nil, ``, 0, false},
+		{nil, `article.edit`, http.StatusForbidden, false}, //没有PermChecker时拒绝访问声明了权限的路由
+	}
+	for _, test := range tests {
+		var rendered int
+		c := newTestContext(&Server{PermChecker: test.checker}, &rendered)
+		err := c.checkPerm(&Url{Route: `/article/edit`, Perm: test.perm})
+		if (err != nil) != test.err {
+			t.Errorf(`checkPerm(%q) error = %v`, test.perm, err)
+		}
+		if rendered != test.code || c.Exit != (test.code != 0) {
+			t.Errorf(`checkPerm(%q): rendered = %d, Exit = %v; want %d`, test.perm, rendered, c.Exit, test.code)
+		}
+		if test.code != 0 && c.Output.Status != NO_PERM {
+			t.Errorf(`checkPerm(%q): Output.Status = %d, want NO_PERM`, test.perm, c.Output.Status)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var proxyAliases = map[string][]string{
+	`loopback`: {`127.0.0.0/8`, `::1/128`},
+	`private`:  {`10.0.0.0/8`, `172.16.0.0/12`, `192.168.0.0/16`, `fc00::/7`},
+}
+
+// NewProxies 创建受信任的反向代理列表。cidrs可以是IP、CIDR或以下别名：
+// loopback(127.0.0.0/8、::1)、private(10.0.0.0/8、172.16.0.0/12、192.168.0.0/16、fc00::/7)
+func NewProxies(cidrs ...string) (*Proxies, error) {
+	p := &Proxies{}
+	for _, cidr := range cidrs {
+		if alias, ok := proxyAliases[cidr]; ok {
+			for _, v := range alias {
+				_, n, _ := net.ParseCIDR(v)
+				p.nets = append(p.nets, n)
+			}
+			continue
+		}
+		if !strings.Contains(cidr, `/`) {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf(`webx: invalid proxy address %q`, cidr)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			p.nets = append(p.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf(`webx: invalid proxy address %q: %v`, cidr, err)
+		}
+		p.nets = append(p.nets, n)
+	}
+	return p, nil
+}
+
+// Proxies 是受信任的反向代理。只有直接连接来自受信任的代理时，
+// 才会根据Forwarded、X-Forwarded-*和X-Real-IP请求头确定客户端的IP、协议、主机和端口
+type Proxies struct {
+	nets []*net.IPNet
+}
+
+// Trusted ip是否为受信任的代理
+func (p *Proxies) Trusted(ip string) bool {
+	if p == nil {
+		return false
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range p.nets {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forwarded 是客户端请求的原始信息
+type Forwarded struct {
+	IP     string //客户端IP，IPv6地址不含方括号
+	Scheme string //http或https
+	Host   string //主机名，不含端口，IPv6地址不含方括号
+	Port   int
+}
+
+// Origin 返回scheme://host[:port]，端口为协议的默认端口时省略
+func (f *Forwarded) Origin() string {
+	host := f.Host
+	if strings.Contains(host, `:`) {
+		host = `[` + host + `]`
+	}
+	if f.Port > 0 && f.Port != defaultPort(f.Scheme) {
+		host += `:` + strconv.Itoa(f.Port)
+	}
+	return f.Scheme + `://` + host
+}
+
+// 转发链中的一个节点
+type forwardedNode struct {
+	For   string
+	Proto string
+	Host  string
+	Port  string
+}
+
+// Resolve 解析客户端请求的原始信息。
+// remoteAddr为直接连接的地址，host为请求头Host，scheme为直接连接使用的协议，header用于读取请求头。
+// 转发链从右向左查找第一个不受信任的地址作为客户端IP，协议和主机使用该节点转发时记录的值
+func (p *Proxies) Resolve(remoteAddr string, host string, scheme string, header func(string) string) *Forwarded {
+	f := &Forwarded{Scheme: scheme}
+	f.IP, _ = splitHostPort(remoteAddr)
+	f.IP = cleanIP(f.IP)
+	hostPort := host
+	var port string
+	if p.Trusted(f.IP) {
+		nodes := parseForwardedHeader(header(`Forwarded`))
+		if len(nodes) == 0 {
+			nodes = parseXForwarded(header)
+		}
+		for i := len(nodes) - 1; i >= 0; i-- {
+			ip := cleanIP(nodes[i].For)
+			if ip == `` {
+				break
+			}
+			f.IP = ip
+			if nodes[i].Proto != `` {
+				f.Scheme = strings.ToLower(nodes[i].Proto)
+			}
+			if nodes[i].Host != `` {
+				hostPort = nodes[i].Host
+			}
+			port = nodes[i].Port
+			if !p.Trusted(ip) {
+				break
+			}
+		}
+	}
+	f.Host, f.Port = splitHostPortInt(hostPort)
+	if f.Port == 0 && port != `` {
+		f.Port, _ = strconv.Atoi(port)
+	}
+	if f.Port == 0 {
+		f.Port = defaultPort(f.Scheme)
+	}
+	return f
+}
+
+func defaultPort(scheme string) int {
+	if scheme == `https` {
+		return 443
+	}
+	return 80
+}
+
+// 解析RFC 7239 Forwarded请求头，例如：for=192.0.2.60;proto=https;host=example.com, for="[2001:db8::1]:4711"
+func parseForwardedHeader(value string) []forwardedNode {
+	if value == `` {
+		return nil
+	}
+	var nodes []forwardedNode
+	for _, element := range splitQuoted(value, ',') {
+		var node forwardedNode
+		for _, pair := range splitQuoted(element, ';') {
+			kv := strings.SplitN(strings.TrimSpace(pair), `=`, 2)
+			if len(kv) != 2 {
+				continue
+			}
+			v := strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			switch strings.ToLower(strings.TrimSpace(kv[0])) {
+			case `for`:
+				node.For = v
+			case `proto`:
+				node.Proto = v
+			case `host`:
+				node.Host = v
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// 根据X-Forwarded-For(没有时使用X-Real-IP)、X-Forwarded-Proto、X-Forwarded-Host和X-Forwarded-Port生成转发链。
+// 协议等请求头的值与X-Forwarded-For的地址一一对应时按位置取值，否则使用最后一个值
+func parseXForwarded(header func(string) string) []forwardedNode {
+	fors := splitList(header(`X-Forwarded-For`))
+	if len(fors) == 0 {
+		if ip := strings.TrimSpace(header(`X-Real-IP`)); ip != `` {
+			fors = []string{ip}
+		}
+	}
+	protos := splitList(header(`X-Forwarded-Proto`))
+	hosts := splitList(header(`X-Forwarded-Host`))
+	ports := splitList(header(`X-Forwarded-Port`))
+	nodes := make([]forwardedNode, len(fors))
+	for i, ip := range fors {
+		nodes[i] = forwardedNode{
+			For:   ip,
+			Proto: pickValue(protos, i, len(fors)),
+			Host:  pickValue(hosts, i, len(fors)),
+			Port:  pickValue(ports, i, len(fors)),
+		}
+	}
+	return nodes
+}
+
+func pickValue(values []string, i int, n int) string {
+	if len(values) == n {
+		return values[i]
+	}
+	if len(values) > 0 && i == n-1 {
+		return values[len(values)-1]
+	}
+	return ``
+}
+
+func splitList(value string) []string {
+	if value == `` {
+		return nil
+	}
+	list := strings.Split(value, `,`)
+	for i, v := range list {
+		list[i] = strings.TrimSpace(v)
+	}
+	return list
+}
+
+// 按sep分割，忽略引号内的sep
+func splitQuoted(value string, sep byte) []string {
+	var (
+		list   []string
+		quoted bool
+		start  int
+	)
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				list = append(list, value[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(list, value[start:])
+}
+
+// 从for的值或地址中取出IP，无效(例如unknown、_hidden)时返回空字符串
+func cleanIP(addr string) string {
+	host, _ := splitHostPort(strings.Trim(strings.TrimSpace(addr), `"`))
+	if i := strings.IndexByte(host, '%'); i > 0 {
+		host = host[:i] //去掉IPv6的zone
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ``
+	}
+	return ip.String()
+}
+
+// 分割主机和端口，支持[::1]:80、::1、example.com:8080等格式
+func splitHostPort(hostPort string) (host string, port string) {
+	if strings.HasPrefix(hostPort, `[`) {
+		end := strings.IndexByte(hostPort, ']')
+		if end < 0 {
+			return hostPort, ``
+		}
+		host = hostPort[1:end]
+		if rest := hostPort[end+1:]; strings.HasPrefix(rest, `:`) {
+			port = rest[1:]
+		}
+		return
+	}
+	if strings.Count(hostPort, `:`) == 1 {
+		i := strings.IndexByte(hostPort, ':')
+		return hostPort[:i], hostPort[i+1:]
+	}
+	return hostPort, ``
+}
+
+func splitHostPortInt(hostPort string) (string, int) {
+	host, port := splitHostPort(hostPort)
+	n, _ := strconv.Atoi(port)
+	return strings.ToLower(host), n
+}
@@ -0,0 +1,117 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import "testing"
+
+func TestProxiesResolve(t *testing.T) {
+	proxies, err := NewProxies(`loopback`, `10.0.0.0/8`, `2001:db8::1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		proxies    *Proxies
+		remoteAddr string
+		host       string
+		headers    map[string]string
+		want       Forwarded
+	}{
+		{
+			name:       `untrusted peer`,
+			proxies:    proxies,
+			remoteAddr: `203.0.113.9:5000`,
+			host:       `example.com`,
+			headers:    map[string]string{`X-Forwarded-For`: `1.2.3.4`, `X-Forwarded-Proto`: `https`},
+			want:       Forwarded{IP: `203.0.113.9`, Scheme: `http`, Host: `example.com`, Port: 80},
+		},
+		{
+			name:       `no trusted proxies`,
+			remoteAddr: `127.0.0.1:5000`,
+			host:       `example.com:8080`,
+			headers:    map[string]string{`X-Forwarded-For`: `1.2.3.4`},
+			want:       Forwarded{IP: `127.0.0.1`, Scheme: `http`, Host: `example.com`, Port: 8080},
+		},
+		{
+			name:       `spoofed x-forwarded-for`,
+			proxies:    proxies,
+			remoteAddr: `10.0.0.2:5000`,
+			host:       `internal:8080`,
+			headers: map[string]string{
+				`X-Forwarded-For`:   `6.6.6.6, 198.51.100.7, 10.0.0.1`,
+				`X-Forwarded-Proto`: `https`,
+				`X-Forwarded-Host`:  `example.com`,
+			},
+			want: Forwarded{IP: `198.51.100.7`, Scheme: `https`, Host: `example.com`, Port: 443},
+		},
+		{
+			name:       `x-real-ip`,
+			proxies:    proxies,
+			remoteAddr: `127.0.0.1:5000`,
+			host:       `example.com`,
+			headers:    map[string]string{`X-Real-IP`: `198.51.100.7`, `X-Forwarded-Port`: `8443`, `X-Forwarded-Proto`: `https`},
+			want:       Forwarded{IP: `198.51.100.7`, Scheme: `https`, Host: `example.com`, Port: 8443},
+		},
+		{
+			name:       `forwarded header with ipv6`,
+			proxies:    proxies,
+			remoteAddr: `[2001:db8::1]:5000`,
+			host:       `[2001:db8::2]:8080`,
+			headers: map[string]string{
+				`Forwarded`: `for="[2001:db8:cafe::17]:4711";proto=https;host="[2001:db8::80]:9443", for=10.0.0.1`,
+			},
+			want: Forwarded{IP: `2001:db8:cafe::17`, Scheme: `https`, Host: `2001:db8::80`, Port: 9443},
+		},
+		{
+			name:       `ipv6 host without forwarding`,
+			remoteAddr: `[::1]:5000`,
+			host:       `[::1]:8080`,
+			want:       Forwarded{IP: `::1`, Scheme: `http`, Host: `::1`, Port: 8080},
+		},
+		{
+			name:       `obfuscated client`,
+			proxies:    proxies,
+			remoteAddr: `127.0.0.1:5000`,
+			host:       `example.com`,
+			headers:    map[string]string{`Forwarded`: `for=unknown, for=10.0.0.1;proto=https`},
+			want:       Forwarded{IP: `10.0.0.1`, Scheme: `https`, Host: `example.com`, Port: 443},
+		},
+	}
+	for _, test := range tests {
+		header := func(name string) string {
+			return test.headers[name]
+		}
+		got := test.proxies.Resolve(test.remoteAddr, test.host, `http`, header)
+		if *got != test.want {
+			t.Errorf("%s:\n got %+v\nwant %+v", test.name, *got, test.want)
+		}
+	}
+}
+
+func TestForwardedOrigin(t *testing.T) {
+	tests := map[string]Forwarded{
+		`https://example.com`:       {Scheme: `https`, Host: `example.com`, Port: 443},
+		`http://example.com:8080`:   {Scheme: `http`, Host: `example.com`, Port: 8080},
+		`http://[2001:db8::1]:8080`: {Scheme: `http`, Host: `2001:db8::1`, Port: 8080},
+	}
+	for want, f := range tests {
+		if got := f.Origin(); got != want {
+			t.Errorf("Origin() = %q, want %q", got, want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+// RateLimiter 检查当前请求是否超出路由声明的访问频率限制(ratelimit标签或WrapperRoute.RateLimit)，
+// 超出时返回错误(一般为状态码429的*Error)。在控制器的Init之后、权限检查之前执行
+type RateLimiter interface {
+	Allow(c *Context, u *Url) error
+}
+
+// RateLimiterFunc 将函数转换为RateLimiter
+type RateLimiterFunc func(c *Context, u *Url) error
+
+func (f RateLimiterFunc) Allow(c *Context, u *Url) error {
+	return f(c, u)
+}
+
+// RateLimitParser 由RateLimiter实现时，注册路由时检查路由声明的访问频率限制规则
+type RateLimitParser interface {
+	ParseRateLimit(rule string) error
+}
+
+// 注册路由时检查访问频率限制规则。Server.RateLimiter须在注册路由之前设置
+func (s *Server) parseRateLimit(rule string) error {
+	if rule == `` {
+		return nil
+	}
+	if p, ok := s.RateLimiter.(RateLimitParser); ok {
+		return p.ParseRateLimit(rule)
+	}
+	return nil
+}
+
+// 检查路由u声明的访问频率限制和权限
+func (c *Context) checkRoute(u *Url) error {
+	c.route = u
+	if u.RateLimit != `` && c.Server.RateLimiter != nil {
+		if err := c.Server.RateLimiter.Allow(c, u); err != nil {
+			return err
+		}
+	}
+	return c.checkPerm(u)
+}
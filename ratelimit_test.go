@@ -0,0 +1,79 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"errors"
+	"testing"
+)
+
+type testRateLimiter struct {
+	RateLimiterFunc
+}
+
+func (l testRateLimiter) ParseRateLimit(rule string) error {
+	if rule != `10/m` {
+		return errors.New(`invalid rule`)
+	}
+	return nil
+}
+
+func TestCheckRouteRateLimit(t *testing.T) {
+	var allowed *Url
+	limiter := testRateLimiter{RateLimiterFunc(func(c *Context, u *Url) error {
+		allowed = u
+		return nil
+	})}
+	var rendered int
+	c := newTestContext(&Server{RateLimiter: limiter}, &rendered)
+	u := &Url{Route: `/article/list`, RateLimit: `10/m`}
+	if err := c.checkRoute(u); err != nil {
+		t.Fatal(err)
+	}
+	if allowed != u || c.Route() != u {
+		t.Errorf(`checkRoute: RateLimiter got %v, Route() = %v`, allowed, c.Route())
+	}
+	if err := c.Server.parseRateLimit(`10/m`); err != nil {
+		t.Errorf(`parseRateLimit(10/m): %v`, err)
+	}
+	if err := c.Server.parseRateLimit(`10/q`); err == nil {
+		t.Error(`parseRateLimit(10/q): invalid rule accepted`)
+	}
+	if err := (&Server{}).parseRateLimit(`10/q`); err != nil {
+		t.Errorf(`parseRateLimit without RateLimiter: %v`, err)
+	}
+}
+
+type rateLimitController struct {
+	list Mapper `ratelimit:"10/q"`
+}
+
+func (c *rateLimitController) Init(*Context) error { return nil }
+func (c *rateLimitController) List() error         { return nil }
+
+func TestRouteTagsInvalidRateLimit(t *testing.T) {
+	s := NewServer(`ratelimit_test`)
+	s.RateLimiter = testRateLimiter{}
+	wr := s.NewApp(`test`).Reg(&rateLimitController{})
+	defer func() {
+		if recover() == nil {
+			t.Error(`RouteTags: invalid ratelimit tag accepted`)
+		}
+	}()
+	wr.RouteTags()
+}
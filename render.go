@@ -0,0 +1,408 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/webx-top/echo/engine"
+)
+
+// FormatRender 以某种格式输出Context.Output。
+// 状态码为Context.Code，模板为Context.Tmpl
+type FormatRender func(c *Context) error
+
+// Formats 是按格式名称(即Context.Format)注册的输出方式。例如注册YAML格式：
+//
+//	s.Formats.Register(`yaml`, func(c *webx.Context) error {
+//		b, err := yaml.Marshal(c.Output)
+//		if err != nil {
+//			return err
+//		}
+//		return c.Blob(c.Code, `application/x-yaml`, b)
+//	}, `application/x-yaml`, `text/yaml`)
+type Formats struct {
+	Default string                  //无法根据请求确定格式时使用的格式
+	renders map[string]FormatRender //格式名称 => 输出方式
+	errors  map[string]ErrorRender  //格式名称 => 错误详情输出方式
+	mimes   map[string]string       //MIME类型 => 格式名称
+	types   []string                //按注册顺序排列的MIME类型，用于匹配`text/*`这样的通配符
+	mutex   sync.RWMutex
+}
+
+// NewFormats 创建包含html、json、xml、text、csv和protobuf格式的输出方式
+func NewFormats() *Formats {
+	f := &Formats{
+		Default: `html`,
+		renders: make(map[string]FormatRender),
+		errors:  make(map[string]ErrorRender),
+		mimes:   make(map[string]string),
+	}
+	f.Register(`html`, renderHTML, `text/html`, `application/xhtml+xml`)
+	f.Register(`json`, renderJSON, `application/json`, `text/javascript`, `application/javascript`)
+	f.Register(`xml`, renderXML, `application/xml`, `text/xml`)
+	f.Register(`text`, renderText, `text/plain`)
+	f.Register(`csv`, renderCSV, `text/csv`)
+	f.Register(`protobuf`, renderProtobuf, `application/x-protobuf`, `application/protobuf`)
+	f.RegisterError(`json`, renderProblemJSON)
+	f.RegisterError(`xml`, renderProblemXML)
+	return f
+}
+
+// Register 注册格式。mimes为请求头Accept中对应此格式的MIME类型
+func (f *Formats) Register(name string, render FormatRender, mimes ...string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.renders[name] = render
+	for _, mime := range mimes {
+		mime = strings.ToLower(mime)
+		if _, ok := f.mimes[mime]; !ok {
+			f.types = append(f.types, mime)
+		}
+		f.mimes[mime] = name
+	}
+}
+
+// Unregister 取消注册格式
+func (f *Formats) Unregister(name string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.renders, name)
+	delete(f.errors, name)
+	types := f.types[:0]
+	for _, mime := range f.types {
+		if f.mimes[mime] == name {
+			delete(f.mimes, mime)
+			continue
+		}
+		types = append(types, mime)
+	}
+	f.types = types
+}
+
+// Get 获取格式的输出方式，未注册时返回nil
+func (f *Formats) Get(name string) FormatRender {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.renders[name]
+}
+
+// RegisterError 注册格式的错误详情输出方式。
+// 未注册的格式在输出错误时将错误信息赋值给Output后按该格式的输出方式输出
+func (f *Formats) RegisterError(name string, render ErrorRender) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.errors[name] = render
+}
+
+// GetError 获取格式的错误详情输出方式，未注册时返回nil
+func (f *Formats) GetError(name string) ErrorRender {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.errors[name]
+}
+
+// Has 判断格式是否已注册
+func (f *Formats) Has(name string) bool {
+	return f.Get(name) != nil
+}
+
+// Names 返回所有已注册的格式名称
+func (f *Formats) Names() []string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	names := make([]string, 0, len(f.renders))
+	for name := range f.renders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MimeType 返回格式注册的第一个MIME类型，未注册时返回空字符串
+func (f *Formats) MimeType(name string) string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	for _, mime := range f.types {
+		if f.mimes[mime] == name {
+			return mime
+		}
+	}
+	return ``
+}
+
+// Negotiate 根据请求头Accept的内容(含q值)选择格式，没有可接受的格式时返回Default
+func (f *Formats) Negotiate(accept string) string {
+	if accept == `` {
+		return f.Default
+	}
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	for _, r := range parseAccept(accept) {
+		if r.q <= 0 {
+			continue
+		}
+		switch {
+		case r.value == `*/*`:
+			return f.Default
+		case strings.HasSuffix(r.value, `/*`):
+			prefix := strings.TrimSuffix(r.value, `*`)
+			for _, mime := range f.types {
+				if strings.HasPrefix(mime, prefix) {
+					return f.mimes[mime]
+				}
+			}
+		default:
+			if name, ok := f.mimes[r.value]; ok {
+				return name
+			}
+		}
+	}
+	return f.Default
+}
+
+// acceptRange 是Accept类请求头中的一项
+type acceptRange struct {
+	value       string
+	q           float64
+	specificity int //`*/*`为0，`text/*`为1，其它为2
+	index       int
+}
+
+type acceptRanges []acceptRange
+
+func (a acceptRanges) Len() int      { return len(a) }
+func (a acceptRanges) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a acceptRanges) Less(i, j int) bool {
+	if a[i].q != a[j].q {
+		return a[i].q > a[j].q
+	}
+	if a[i].specificity != a[j].specificity {
+		return a[i].specificity > a[j].specificity
+	}
+	return a[i].index < a[j].index
+}
+
+// 解析Accept类请求头，按q值、精确程度和出现顺序排序。
+// 例如：text/html;q=0.8, application/json => application/json, text/html
+func parseAccept(accept string) []acceptRange {
+	parts := strings.Split(accept, `,`)
+	ranges := make(acceptRanges, 0, len(parts))
+	for i, part := range parts {
+		params := strings.Split(part, `;`)
+		r := acceptRange{
+			value: strings.ToLower(strings.TrimSpace(params[0])),
+			q:     1,
+			index: i,
+		}
+		if r.value == `` {
+			continue
+		}
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, `q=`) && !strings.HasPrefix(param, `Q=`) {
+				continue
+			}
+			q, err := strconv.ParseFloat(param[2:], 64)
+			if err != nil {
+				q = 0
+			}
+			r.q = q
+		}
+		switch {
+		case r.value == `*/*` || r.value == `*`:
+			r.specificity = 0
+		case strings.HasSuffix(r.value, `/*`):
+			r.specificity = 1
+		default:
+			r.specificity = 2
+		}
+		ranges = append(ranges, r)
+	}
+	sort.Stable(ranges)
+	return ranges
+}
+
+// AddVary 在响应头Vary中追加字段(已存在时忽略)
+func AddVary(h engine.Header, fields ...string) {
+	vary := h.Get(`Vary`)
+	for _, field := range fields {
+		exists := false
+		for _, v := range strings.Split(vary, `,`) {
+			v = strings.TrimSpace(v)
+			if v == `*` || strings.EqualFold(v, field) {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+		if vary != `` {
+			vary += `, `
+		}
+		vary += field
+	}
+	if vary != `` {
+		h.Set(`Vary`, vary)
+	}
+}
+
+// 以模板方式输出。没有指定模板时输出Output.Message
+func renderHTML(c *Context) error {
+	if c.Tmpl == `` {
+		msg, _ := c.Output.Message.(string)
+		return c.String(c.Code, msg)
+	}
+	c.Context.SetFunc(`Status`, func() int {
+		return c.Output.Status
+	})
+	c.Context.SetFunc(`Message`, func() interface{} {
+		return c.Output.Message
+	})
+	return c.Render(c.Code, c.Tmpl, c.Output.Data)
+}
+
+// 输出JSON，请求中带有callback参数时输出JSONP
+func renderJSON(c *Context) error {
+	b, err := json.Marshal(c.Output)
+	if err != nil {
+		return err
+	}
+	if callback := c.Query(`callback`); callback != `` {
+		b = []byte(callback + `(` + string(b) + `);`)
+	}
+	return c.Object().JSONBlob(c.Code, b)
+}
+
+func renderXML(c *Context) error {
+	return c.Object().XML(c.Code, c.Output)
+}
+
+// 输出纯文本。Output.Data为字符串时输出Output.Data，否则输出Output.Message
+func renderText(c *Context) error {
+	switch v := c.Output.Data.(type) {
+	case string:
+		return c.Blob(c.Code, `text/plain; charset=utf-8`, []byte(v))
+	case []byte:
+		return c.Blob(c.Code, `text/plain; charset=utf-8`, v)
+	case fmt.Stringer:
+		return c.Blob(c.Code, `text/plain; charset=utf-8`, []byte(v.String()))
+	}
+	return c.Blob(c.Code, `text/plain; charset=utf-8`, []byte(fmt.Sprint(c.Output.Message)))
+}
+
+// 输出CSV。Output.Data支持[][]string、[][]interface{}、[]map[string]interface{}、
+// []map[string]string、map[string]interface{}和map[string]string，其它类型输出Output.Message
+func renderCSV(c *Context) error {
+	var records [][]string
+	switch v := c.Output.Data.(type) {
+	case [][]string:
+		records = v
+	case [][]interface{}:
+		for _, row := range v {
+			record := make([]string, len(row))
+			for i, col := range row {
+				record[i] = fmt.Sprint(col)
+			}
+			records = append(records, record)
+		}
+	case []map[string]interface{}:
+		var keys []string
+		for i, row := range v {
+			if i == 0 {
+				keys = sortedKeys(row)
+				records = append(records, keys)
+			}
+			record := make([]string, len(keys))
+			for j, key := range keys {
+				record[j] = fmt.Sprint(row[key])
+			}
+			records = append(records, record)
+		}
+	case []map[string]string:
+		var keys []string
+		for i, row := range v {
+			if i == 0 {
+				for key := range row {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				records = append(records, keys)
+			}
+			record := make([]string, len(keys))
+			for j, key := range keys {
+				record[j] = row[key]
+			}
+			records = append(records, record)
+		}
+	case map[string]interface{}:
+		for _, key := range sortedKeys(v) {
+			records = append(records, []string{key, fmt.Sprint(v[key])})
+		}
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			records = append(records, []string{key, v[key]})
+		}
+	default:
+		records = [][]string{{fmt.Sprint(c.Output.Message)}}
+	}
+	buf := new(bytes.Buffer)
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(records); err != nil {
+		return err
+	}
+	return c.Blob(c.Code, `text/csv; charset=utf-8`, buf.Bytes())
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// 输出protobuf。Output.Data须实现Marshal() ([]byte, error)方法(例如gogo/protobuf生成的结构体)
+func renderProtobuf(c *Context) error {
+	m, ok := c.Output.Data.(interface {
+		Marshal() ([]byte, error)
+	})
+	if !ok {
+		return c.String(http.StatusNotAcceptable, `The contents can not be displayed in this format: `+c.Format)
+	}
+	b, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	return c.Blob(c.Code, `application/x-protobuf`, b)
+}
@@ -0,0 +1,52 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import "testing"
+
+func TestFormatsNegotiate(t *testing.T) {
+	f := NewFormats()
+	f.Register(`yaml`, renderText, `application/x-yaml`)
+	tests := []struct {
+		accept, want string
+	}{
+		{``, `html`},
+		{`*/*`, `html`},
+		{`text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8`, `html`},
+		{`application/json, text/javascript, */*; q=0.01`, `json`},
+		{`text/html;q=0.5, application/json`, `json`},
+		{`application/xml;q=0.9, application/json;q=0.9`, `xml`},
+		{`*/*;q=0.8, text/csv`, `csv`},
+		{`text/*;q=0.9, image/png`, `html`},
+		{`application/x-yaml`, `yaml`},
+		{`application/json;q=0, text/plain`, `text`},
+		{`image/png`, `html`},
+	}
+	for _, test := range tests {
+		if got := f.Negotiate(test.accept); got != test.want {
+			t.Errorf(`Negotiate(%q) = %v, want %v`, test.accept, got, test.want)
+		}
+	}
+	if f.MimeType(`json`) != `application/json` || f.MimeType(`yaml`) != `application/x-yaml` {
+		t.Errorf(`MimeType: %v, %v`, f.MimeType(`json`), f.MimeType(`yaml`))
+	}
+	f.Unregister(`yaml`)
+	if f.Has(`yaml`) || f.Negotiate(`application/x-yaml`) != `html` || f.MimeType(`yaml`) != `` {
+		t.Error(`yaml format should be unregistered`)
+	}
+}
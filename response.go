@@ -0,0 +1,113 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"net/http"
+
+	"github.com/webx-top/echo/engine"
+)
+
+// WrapResponse 包装响应，使BeforeWriteHeader注册的函数在发送响应头之前被调用。
+// Server.ServeHTTP已自动包装，在Server之外处理请求时使用
+func WrapResponse(w engine.Response) engine.Response {
+	if _, ok := w.(*response); ok {
+		return w
+	}
+	return &response{Response: w}
+}
+
+// BeforeWriteHeader 注册在发送响应头之前调用的函数，code为将要发送的状态码，
+// 中间件可以据此根据处理函数最终设置的响应头决定如何输出。
+// 响应没有被WrapResponse包装或已经发送响应头时返回false
+func BeforeWriteHeader(w engine.Response, fn func(code int)) bool {
+	r, ok := w.(*response)
+	if !ok || r.done || r.Response.Committed() {
+		return false
+	}
+	r.before = append(r.before, fn)
+	return true
+}
+
+// HoldWriteHeader 推迟发送响应头，在BeforeWriteHeader注册的函数中调用。
+// 之后写入的内容直接交给SetWriter设置的Writer，调用返回的函数时才以原状态码发送响应头。
+// 响应没有被WrapResponse包装或已经发送响应头时返回nil
+func HoldWriteHeader(w engine.Response) func() {
+	r, ok := w.(*response)
+	if !ok || r.Response.Committed() {
+		return nil
+	}
+	r.held = true
+	return func() {
+		if !r.held {
+			return
+		}
+		r.held = false
+		r.Response.WriteHeader(r.code)
+	}
+}
+
+type response struct {
+	engine.Response
+	before []func(code int)
+	done   bool
+	held   bool //响应头被推迟发送
+	code   int  //将要发送的状态码
+}
+
+func (r *response) beforeWriteHeader(code int) {
+	if r.done || r.Response.Committed() {
+		return
+	}
+	r.done = true
+	r.code = code
+	for _, fn := range r.before {
+		fn(code)
+	}
+}
+
+// Committed 响应头被推迟发送时也视为已发送，避免处理函数之后再输出其它内容
+func (r *response) Committed() bool {
+	return r.held || r.Response.Committed()
+}
+
+func (r *response) WriteHeader(code int) {
+	r.beforeWriteHeader(code)
+	if r.held {
+		return
+	}
+	r.Response.WriteHeader(code)
+}
+
+func (r *response) Write(b []byte) (int, error) {
+	r.beforeWriteHeader(http.StatusOK)
+	if r.held {
+		return r.Response.Writer().Write(b)
+	}
+	return r.Response.Write(b)
+}
+
+func (r *response) Redirect(code int, url string) {
+	r.beforeWriteHeader(code)
+	r.Response.Redirect(code, url)
+}
+
+func (r *response) NotFound() {
+	r.beforeWriteHeader(http.StatusNotFound)
+	r.Response.NotFound()
+}
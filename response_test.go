@@ -0,0 +1,89 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+
+	"github.com/webx-top/webx/testutil"
+)
+
+func TestBeforeWriteHeader(t *testing.T) {
+	raw, rec := testutil.NewResponse()
+	if BeforeWriteHeader(raw, func(int) {}) {
+		t.Error("registered on an unwrapped response")
+	}
+	w := WrapResponse(raw)
+	if WrapResponse(w) != w {
+		t.Error("response is wrapped twice")
+	}
+	var codes []int
+	BeforeWriteHeader(w, func(code int) {
+		codes = append(codes, code)
+		w.Header().Set(`X-Before`, `1`)
+	})
+	w.Write([]byte(`a`))
+	w.Write([]byte(`b`))
+	if len(codes) != 1 || codes[0] != http.StatusOK {
+		t.Errorf("hook calls: %v", codes)
+	}
+	if rec.Result().Header.Get(`X-Before`) != `1` || rec.Body.String() != `ab` {
+		t.Error("header set by the hook is not sent")
+	}
+	if BeforeWriteHeader(w, func(int) {}) {
+		t.Error("registered after the header is sent")
+	}
+
+	raw, rec = testutil.NewResponse()
+	w = WrapResponse(raw)
+	codes = nil
+	BeforeWriteHeader(w, func(code int) {
+		codes = append(codes, code)
+	})
+	w.WriteHeader(http.StatusNotFound)
+	if len(codes) != 1 || codes[0] != http.StatusNotFound || rec.Code != http.StatusNotFound {
+		t.Errorf("WriteHeader: hook calls %v, sent %d", codes, rec.Code)
+	}
+}
+
+func TestHoldWriteHeader(t *testing.T) {
+	raw, _ := testutil.NewResponse()
+	if HoldWriteHeader(raw) != nil {
+		t.Error("held on an unwrapped response")
+	}
+	raw, rec := testutil.NewResponse()
+	w := WrapResponse(raw)
+	var buf bytes.Buffer
+	var release func()
+	BeforeWriteHeader(w, func(code int) {
+		release = HoldWriteHeader(w)
+		w.SetWriter(&buf)
+	})
+	w.WriteHeader(http.StatusCreated)
+	w.Write([]byte(`a`))
+	if !w.Committed() || rec.Body.Len() != 0 || buf.String() != `a` {
+		t.Fatalf("held response: committed %v, sent %q, buffered %q", w.Committed(), rec.Body.String(), buf.String())
+	}
+	w.Header().Set(`X-Held`, `1`)
+	release()
+	if rec.Code != http.StatusCreated || rec.Result().Header.Get(`X-Held`) != `1` {
+		t.Errorf("released header: %d %v", rec.Code, rec.Result().Header)
+	}
+}
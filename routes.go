@@ -0,0 +1,181 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"strings"
+
+	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/com"
+)
+
+// 路由索引：按路径片段组织的树，由引擎中已注册的路由生成，用于查找与请求网址匹配的路由
+type routeNode struct {
+	static  map[string]*routeNode
+	param   *routeNode
+	any     *routeNode //通配符(*)
+	pattern string     //以此节点结束的路由规则
+	methods []string
+}
+
+func newRouteNode() *routeNode {
+	return &routeNode{static: make(map[string]*routeNode)}
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, `/`), `/`)
+}
+
+// 添加路由规则pattern及其请求方式，通配符之后的片段被忽略
+func (n *routeNode) add(pattern string, methods ...string) {
+	for _, seg := range splitPath(pattern) {
+		if strings.HasPrefix(seg, `*`) {
+			if n.any == nil {
+				n.any = newRouteNode()
+			}
+			n = n.any
+			break
+		}
+		if strings.HasPrefix(seg, `:`) {
+			if n.param == nil {
+				n.param = newRouteNode()
+			}
+			n = n.param
+			continue
+		}
+		child, ok := n.static[seg]
+		if !ok {
+			child = newRouteNode()
+			n.static[seg] = child
+		}
+		n = child
+	}
+	n.pattern = pattern
+	for _, method := range methods {
+		if !com.InSlice(method, n.methods) {
+			n.methods = append(n.methods, method)
+		}
+	}
+}
+
+// 查找与路径片段segs匹配的节点。与路由器一样，静态片段优先于参数(:name)，参数优先于通配符(*)
+func (n *routeNode) match(segs []string) *routeNode {
+	if len(segs) == 0 {
+		if n.pattern != `` {
+			return n
+		}
+		if n.any != nil && n.any.pattern != `` {
+			return n.any
+		}
+		return nil
+	}
+	if child, ok := n.static[segs[0]]; ok {
+		if m := child.match(segs[1:]); m != nil {
+			return m
+		}
+	}
+	if n.param != nil && segs[0] != `` {
+		if m := n.param.match(segs[1:]); m != nil {
+			return m
+		}
+	}
+	if n.any != nil && n.any.pattern != `` {
+		return n.any
+	}
+	return nil
+}
+
+// 返回路由索引。引擎中的路由数量改变后(注册了新路由)重新生成
+func (a *App) routeIndex() *routeNode {
+	var routes []echo.Route
+	prefix := ``
+	if a.Handler != nil {
+		routes = a.Handler.Routes()
+	} else {
+		routes = a.Server.Core.Routes()
+		prefix = strings.TrimSuffix(a.Dir, `/`)
+	}
+	a.routeMutex.RLock()
+	index, count := a.routes, a.routeCount
+	a.routeMutex.RUnlock()
+	if index != nil && count == len(routes) {
+		return index
+	}
+	index = newRouteNode()
+	for _, r := range routes {
+		if !strings.HasPrefix(r.Path, prefix) {
+			continue
+		}
+		path := r.Path[len(prefix):]
+		if path == `` {
+			path = `/`
+		} else if path[0] != '/' {
+			continue
+		}
+		index.add(path, r.Method)
+	}
+	a.routeMutex.Lock()
+	a.routes, a.routeCount = index, len(routes)
+	a.routeMutex.Unlock()
+	return index
+}
+
+// MatchRoute 查找与请求网址path(包含App的路径前缀)匹配的路由，返回路由规则和已注册的请求方式。
+// 路由来自引擎中已注册的路由(包括通过Trace和Group注册的路由)，
+// 与路由器一样，静态片段优先于参数(:name)，参数优先于通配符(*)
+func (a *App) MatchRoute(path string) (pattern string, methods []string) {
+	if prefix := strings.TrimSuffix(a.Dir, `/`); a.Domain == `` && prefix != `` {
+		if !strings.HasPrefix(path, prefix) {
+			return
+		}
+		path = path[len(prefix):]
+	}
+	if n := a.routeIndex().match(splitPath(path)); n != nil {
+		return n.pattern, n.methods
+	}
+	return
+}
+
+// 登记App中的Url，供RouteUrl查找
+func (a *App) setUrl(u *Url) {
+	u.app = a
+	a.routeMutex.Lock()
+	if a.urls == nil {
+		a.urls = make(map[string]*Url)
+	}
+	a.urls[u.Route] = u
+	a.routeMutex.Unlock()
+}
+
+// RouteUrl 返回App中路由规则为pattern的Url，没有时返回nil。
+// 省略了末尾index的路由(例如"/user/"对应"/user/index")也能找到
+func (a *App) RouteUrl(pattern string) *Url {
+	a.routeMutex.RLock()
+	defer a.routeMutex.RUnlock()
+	if u, ok := a.urls[pattern]; ok {
+		return u
+	}
+	if strings.HasSuffix(pattern, `/`) {
+		for _, index := range []string{pattern + `index`, pattern + `index/`} {
+			if u, ok := a.urls[index]; ok {
+				return u
+			}
+		}
+	}
+	return nil
+}
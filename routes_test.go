@@ -0,0 +1,87 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/webx-top/echo"
+)
+
+func TestMatchRoute(t *testing.T) {
+	s := &Server{Core: echo.New()}
+	a := &App{Server: s, Dir: `/admin/`, Group: s.Core.Group(`/admin`)}
+	h := echo.HandlerFunc(func(echo.Context) error { return nil })
+	a.Group.Match([]string{`GET`, `PUT`}, `/user/:id`, h)
+	a.Group.Match([]string{`DELETE`, `GET`}, `/user/:id`, h)
+	a.Group.Match([]string{`POST`}, `/user/new`, h)
+	a.Group.Get(`/file/*`, h)
+	a.Group.Trace(`/debug`, h)
+	a.Group.Group(`/api`).Match([]string{`PATCH`}, `/item/:id`, h)
+	s.Core.Get(`/other/:id`, h)
+	tests := []struct {
+		path    string
+		pattern string
+		methods string
+	}{
+		{`/admin/user/5`, `/user/:id`, `DELETE,GET,PUT`},
+		{`/admin/user/new`, `/user/new`, `POST`},
+		{`/admin/user/`, ``, ``},
+		{`/admin/file/a/b.txt`, `/file/*`, `GET`},
+		{`/admin/debug`, `/debug`, `TRACE`},
+		{`/admin/api/item/1`, `/api/item/:id`, `PATCH`},
+		{`/user/5`, ``, ``},
+		{`/other/5`, ``, ``},
+	}
+	check := func() {
+		for _, test := range tests {
+			pattern, methods := a.MatchRoute(test.path)
+			methods = append([]string{}, methods...)
+			sort.Strings(methods)
+			if pattern != test.pattern || strings.Join(methods, `,`) != test.methods {
+				t.Errorf("MatchRoute(%q) = %q, %v; want %q, %v", test.path, pattern, methods, test.pattern, test.methods)
+			}
+		}
+	}
+	check()
+	//之后注册的路由也能找到
+	a.Group.Match([]string{`POST`}, `/user/:id`, h)
+	tests[0].methods = `DELETE,GET,POST,PUT`
+	check()
+}
+
+func TestRouteUrl(t *testing.T) {
+	a := &App{}
+	for _, route := range []string{`/user/index`, `/article/:id`} {
+		u := NewUrl()
+		u.Set(route)
+		a.setUrl(u)
+	}
+	for pattern, want := range map[string]string{
+		`/user/`:       `/user/index`,
+		`/article/:id`: `/article/:id`,
+		`/article/`:    ``,
+	} {
+		u := a.RouteUrl(pattern)
+		if (u == nil && want != ``) || (u != nil && (u.Route != want || u.app != a)) {
+			t.Errorf("RouteUrl(%q) = %v, want %q", pattern, u, want)
+		}
+	}
+}
@@ -18,15 +18,21 @@
 package webx
 
 import (
+	"net"
 	"strings"
+	"sync"
+	"time"
 
 	codec "github.com/gorilla/securecookie"
 	"github.com/webx-top/echo"
 	"github.com/webx-top/echo/engine"
 	"github.com/webx-top/echo/engine/standard"
 	mw "github.com/webx-top/echo/middleware"
+	"github.com/webx-top/webx/lib/cachestore"
 	"github.com/webx-top/webx/lib/events"
 	"github.com/webx-top/webx/lib/pprof"
+	ss "github.com/webx-top/webx/lib/session"
+	"github.com/webx-top/webx/lib/session/ssi"
 	"github.com/webx-top/webx/lib/tplex"
 	"github.com/webx-top/webx/lib/tplfunc"
 )
@@ -40,17 +46,40 @@ func webxHeader() echo.MiddlewareFunc {
 	})
 }
 
+// 记录访问日志。客户端IP由Context.IP根据Server.TrustedProxies确定
+func webxLog() echo.MiddlewareFunc {
+	return echo.MiddlewareFunc(func(h echo.Handler) echo.Handler {
+		return echo.HandlerFunc(func(c echo.Context) error {
+			start := time.Now()
+			if err := h.Handle(c); err != nil {
+				c.Error(err)
+			}
+			req := c.Request()
+			res := c.Response()
+			path := req.URL().Path()
+			if path == `` {
+				path = `/`
+			}
+			c.Echo().Logger().Infof(`%s %s %s %d %v %d`, X(c).IP(), req.Method(), path, res.Status(), time.Since(start), res.Size())
+			return nil
+		})
+	})
+}
+
 func NewServer(name string, middlewares ...echo.Middleware) (s *Server) {
 	s = &Server{
 		Name:               name,
 		Apps:               make(map[string]*App),
 		apps:               make(map[string]*App),
-		DefaultMiddlewares: []echo.Middleware{webxHeader(), mw.Log(), mw.Recover()},
+		DefaultMiddlewares: []echo.Middleware{webxHeader(), webxLog(), mw.Recover()},
 		TemplateDir:        `template`,
 		Url:                `/`,
 		MaxUploadSize:      10 * 1024 * 1024,
 		CookiePrefix:       "webx_" + name + "_",
 		CookieHttpOnly:     true,
+		DrainTimeout:       30 * time.Second,
+		graceful:           newGraceful(),
+		Formats:            NewFormats(),
 	}
 	s.InitContext = func(e *echo.Echo) interface{} {
 		return NewContext(s, echo.NewContext(nil, nil, e))
@@ -62,6 +91,7 @@ func NewServer(name string, middlewares ...echo.Middleware) (s *Server) {
 	s.SessionStoreConfig = s.CookieAuthKey
 	s.Codec = codec.New([]byte(s.CookieAuthKey), []byte(s.CookieBlockKey))
 	s.Core = echo.NewWithContext(s.InitContext)
+	s.Core.SetHTTPErrorHandler(s.HTTPErrorHandler)
 	s.URL = NewURL(name, s)
 	s.Core.Use(s.DefaultMiddlewares...)
 	s.Core.Use(middlewares...)
@@ -78,6 +108,7 @@ type Server struct {
 	TemplateEngine     tplex.TemplateEx
 	TemplateDir        string
 	MaxUploadSize      int64
+	TrustedProxies     *Proxies //受信任的反向代理，为nil时不使用转发请求头
 	CookiePrefix       string
 	CookieHttpOnly     bool
 	CookieAuthKey      string
@@ -86,10 +117,31 @@ type Server struct {
 	CookieDomain       string
 	SessionStoreEngine string
 	SessionStoreConfig interface{}
+	SessionIdleTimeout int //session空闲超时(秒)，0表示不限制
+	sessionStore       ss.Store
+	sessionFallback    ss.Store //创建sessionStore失败时使用的进程内存储
+	sessionMutex       sync.Mutex
 	codec.Codec
 	Url string
 	*URL
-	InitContext func(*echo.Echo) interface{}
+	InitContext   func(*echo.Echo) interface{}
+	DrainTimeout  time.Duration //平滑关闭时等待正在处理的请求完成的最长时间
+	graceful      *graceful
+	PermChecker   PermChecker //检查路由声明的权限，为nil时拒绝访问声明了权限的路由
+	RateLimiter   RateLimiter //检查路由声明的访问频率限制，为nil时不检查。须在注册路由之前设置
+	Formats       *Formats    //输出格式
+	ErrorTemplate string      //html等格式输出错误时使用的模板，为空时只输出错误信息
+	statics       []*tplfunc.Static
+}
+
+// SetTrustedProxies 设置受信任的反向代理，参数说明参见NewProxies
+func (s *Server) SetTrustedProxies(cidrs ...string) error {
+	p, err := NewProxies(cidrs...)
+	if err != nil {
+		return err
+	}
+	s.TrustedProxies = p
+	return nil
 }
 
 // 初始化 加密/解密 接口
@@ -97,6 +149,57 @@ func (s *Server) InitCodec(hashKey []byte, blockKey []byte) {
 	s.Codec = codec.New(hashKey, blockKey)
 }
 
+// SessionStore 返回根据SessionStoreEngine和SessionStoreConfig创建的session存储，
+// 只在第一次调用时创建，配置有误时返回错误
+func (s *Server) SessionStore() (ss.Store, error) {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	if s.sessionStore != nil {
+		return s.sessionStore, nil
+	}
+	store, err := ss.NewStore(&ssi.Options{
+		Engine:      s.SessionStoreEngine,
+		Path:        `/`,
+		Domain:      s.CookieDomain,
+		MaxAge:      int(s.CookieExpires),
+		HttpOnly:    s.CookieHttpOnly,
+		IdleTimeout: s.SessionIdleTimeout,
+	}, s.SessionStoreConfig)
+	if err != nil {
+		return nil, err
+	}
+	s.sessionStore = store
+	return store, nil
+}
+
+// fallbackSessionStore 返回进程内的session存储(数据保存在内存中，重启后丢失)，
+// 用于SessionStore配置有误时避免每个请求都失败
+func (s *Server) fallbackSessionStore() ss.Store {
+	s.sessionMutex.Lock()
+	defer s.sessionMutex.Unlock()
+	if s.sessionFallback == nil {
+		s.sessionFallback = ss.NewCacheStore(cachestore.NewMemory(nil), ``)
+		s.sessionFallback.Options(ssi.Options{
+			Path:        `/`,
+			Domain:      s.CookieDomain,
+			MaxAge:      int(s.CookieExpires),
+			HttpOnly:    s.CookieHttpOnly,
+			IdleTimeout: s.SessionIdleTimeout,
+		})
+	}
+	return s.sessionFallback
+}
+
+// SessionManager 返回用于列出和注销用户session的接口，
+// session存储不在服务端保存数据(例如cookie)时返回ssi.ErrNotSupported
+func (s *Server) SessionManager() (ssi.Manager, error) {
+	store, err := s.SessionStore()
+	if err != nil {
+		return nil, err
+	}
+	return ss.Manager(store)
+}
+
 // HTTP服务执行入口
 func (s *Server) ServeHTTP(r engine.Request, w engine.Response) {
 	var h *echo.Echo
@@ -108,7 +211,7 @@ func (s *Server) ServeHTTP(r engine.Request, w engine.Response) {
 	}
 
 	if h != nil {
-		h.ServeHTTP(r, w)
+		h.ServeHTTP(r, WrapResponse(w))
 	} else {
 		w.NotFound()
 	}
@@ -178,6 +281,9 @@ func (s *Server) Debug(on bool) *Server {
 }
 
 // 运行服务
+// 参数可以是监听地址(默认为":80")、net.Listener或engine.Engine。
+// 使用监听地址或net.Listener时支持通过Shutdown平滑关闭和通过Restart平滑重启；
+// 使用engine.Engine时Shutdown通过其Stop、Shutdown或Close方法停止服务(如果有)，并在排空请求后返回
 func (s *Server) Run(args ...interface{}) {
 	var eng engine.Engine
 	var arg interface{}
@@ -186,24 +292,64 @@ func (s *Server) Run(args ...interface{}) {
 	}
 	switch arg.(type) {
 	case string:
-		eng = standard.New(arg.(string))
+		eng = s.listen(arg.(string), nil)
+	case net.Listener:
+		eng = s.listen(``, arg.(net.Listener))
 	case engine.Engine:
 		eng = args[0].(engine.Engine)
+		s.graceful.engine = eng
 	default:
-		eng = standard.New(`:80`)
+		eng = s.listen(`:80`, nil)
+	}
+	if eng == nil {
+		return
+	}
+	if _, err := s.SessionStore(); err != nil {
+		s.Core.Logger().Error(err)
+		return
 	}
 	defer func() {
 		events.GoEvent(`webx.serverExit`, nil, func(_ bool) {})
 	}()
 	s.Core.Logger().Infof(`Server "%v" has been launched.`, s.Name)
 
-	eng.SetHandler(s.ServeHTTP)
+	eng.SetHandler(s.serveGraceful)
 	eng.SetLogger(s.Core.Logger())
-	eng.Start()
+	s.handleSignals()
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- eng.Start()
+	}()
+	select {
+	case err := <-errCh:
+		if s.graceful.isClosing() {
+			<-s.graceful.drained
+		} else if err != nil {
+			s.Core.Logger().Error(err)
+		}
+	case <-s.graceful.drained:
+		//engine不能被停止时，排空请求后直接返回
+	}
 
 	s.Core.Logger().Infof(`Server "%v" has been closed.`, s.Name)
 }
 
+func (s *Server) listen(addr string, ln net.Listener) engine.Engine {
+	if ln == nil {
+		var err error
+		ln, err = Listen(addr)
+		if err != nil {
+			s.Core.Logger().Error(err)
+			return nil
+		}
+	}
+	s.graceful.listener = &gracefulListener{Listener: ln, g: s.graceful}
+	return standard.NewWithConfig(&engine.Config{
+		Address:  ln.Addr().String(),
+		Listener: s.graceful.listener,
+	})
+}
+
 // 已创建app实例
 func (s *Server) App(args ...string) (a *App) {
 	var name string
@@ -225,6 +371,7 @@ func (s *Server) FuncMap() (f map[string]interface{}) {
 	}
 	f["AppUrlFor"] = s.URL.BuildByPath
 	f["AppUrl"] = s.URL.Build
+	f["RouteUrl"] = s.URL.For
 	f["RootUrl"] = func(p ...string) string {
 		if len(p) > 0 {
 			return s.Url + p[0]
@@ -240,5 +387,18 @@ func (s *Server) Static(absPath string, urlPath string, f ...*map[string]interfa
 	if len(f) > 0 {
 		*f[0] = st.Register(*f[0])
 	}
+	s.statics = append(s.statics, st)
 	return st
 }
+
+// NonceFuncs 返回Server.Static创建的静态资源管理器中输出带有CSP nonce属性标签的JsTag和CssTag，
+// secure中间件为每个请求注册这些模板函数
+func (s *Server) NonceFuncs(nonce string) map[string]interface{} {
+	funcs := map[string]interface{}{}
+	for _, st := range s.statics {
+		for name, fn := range st.NonceFuncs(nonce) {
+			funcs[name] = fn
+		}
+	}
+	return funcs
+}
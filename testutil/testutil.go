@@ -0,0 +1,53 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+//Package testutil 提供基于net/http/httptest和standard引擎的测试辅助函数
+package testutil
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/webx-top/echo"
+	"github.com/webx-top/echo/engine"
+	"github.com/webx-top/echo/engine/standard"
+)
+
+//NewRequest 创建测试请求，参数同httptest.NewRequest
+func NewRequest(method, target string, body io.Reader) *http.Request {
+	return httptest.NewRequest(method, target, body)
+}
+
+//NewResponse 创建standard引擎的Response，实际发送的响应记录在返回的ResponseRecorder中
+func NewResponse() (engine.Response, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	return standard.NewResponse(rec, echo.New().Logger()), rec
+}
+
+//NewContext 用req创建standard引擎的echo.Context，实际发送的响应记录在返回的ResponseRecorder中
+func NewContext(req *http.Request) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	res := standard.NewResponse(rec, e.Logger())
+	return echo.NewContext(standard.NewRequest(req, e.Logger()), res, e), rec
+}
+
+//Get 创建GET请求的echo.Context
+func Get(target string) (echo.Context, *httptest.ResponseRecorder) {
+	return NewContext(NewRequest(`GET`, target, nil))
+}
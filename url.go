@@ -19,6 +19,7 @@ package webx
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"net/url"
 	"strings"
@@ -26,10 +27,16 @@ import (
 	"github.com/webx-top/webx/lib/com"
 )
 
+var (
+	ErrRouteNotFound = errors.New(`Route not found`)
+	ErrRouteParam    = errors.New(`Route parameter is not filled`)
+)
+
 func NewURL(project string, serv *Server) *URL {
 	return &URL{
 		projectPath: `github.com/webx-top/` + project,
 		urls:        make(map[string]*Url),
+		names:       make(map[string]*Url),
 		Server:      serv,
 	}
 }
@@ -37,6 +44,7 @@ func NewURL(project string, serv *Server) *URL {
 type URL struct {
 	projectPath string
 	urls        map[string]*Url
+	names       map[string]*Url //路由名称关联
 	*Server
 }
 
@@ -103,10 +111,7 @@ func (a *URL) FuncPath(h interface{}) string {
 
 func (a *URL) Set(route string, h interface{}, memo ...string) (pkg string, ctl string, act string) {
 	key := a.FuncPath(h)
-	a.Server.Core.Logger().Infof(`URL:%v => %v`, route, key)
-	urls := &Url{}
-	urls.Set(route, memo...)
-	a.urls[key] = urls
+	a.SetByKey(route, key, memo...)
 	pkg, ctl, act = com.ParseFuncName(key)
 	return
 }
@@ -128,12 +133,85 @@ func (a *URL) Get(key string) (u *Url) {
 	return
 }
 
+// SetName 为路由命名。同名路由会被覆盖
+func (a *URL) SetName(name string, u *Url) {
+	if old, ok := a.names[name]; ok && old.Route != u.Route {
+		a.Server.Core.Logger().Warnf(`Route name "%v" is already used by %v, overwritten by %v`, name, old.Route, u.Route)
+	}
+	u.Name = name
+	a.names[name] = u
+}
+
+// ByName 根据路由名称获取路由信息
+func (a *URL) ByName(name string) (u *Url) {
+	u, _ = a.names[name]
+	return
+}
+
+// Names 全部已命名的路由
+func (a *URL) Names() map[string]*Url {
+	return a.names
+}
+
+// For 根据路由名称生成网址。
+// params可以是map[string]interface{}、map[string]string或url.Values，
+// 其中未被路由规则使用的参数会作为查询字符串附加在网址后面；
+// 也可以按路由规则中参数出现的顺序依次传入各参数值。
+// 例如：Server.URL.For(`user.show`, map[string]interface{}{"id": 1})
+func (a *URL) For(name string, params ...interface{}) (string, error) {
+	u, ok := a.names[name]
+	if !ok {
+		return ``, fmt.Errorf(`%v: %v`, ErrRouteNotFound, name)
+	}
+	vals := url.Values{}
+	if len(params) == 1 {
+		switch v := params[0].(type) {
+		case url.Values:
+			for k, val := range v {
+				vals[k] = val
+			}
+			params = nil
+		case map[string]string:
+			for k, val := range v {
+				vals.Set(k, val)
+			}
+			params = nil
+		case map[string]interface{}:
+			for k, val := range v {
+				vals.Set(k, fmt.Sprintf("%v", val))
+			}
+			params = nil
+		}
+	}
+	if len(params) > 0 {
+		if len(params) != len(u.Params) {
+			return ``, fmt.Errorf(`Route "%v" needs %d parameters, got %d`, name, len(u.Params), len(params))
+		}
+		for i, p := range u.Params {
+			vals.Set(p, fmt.Sprintf("%v", params[i]))
+		}
+	}
+	r, err := u.Fill(vals)
+	if err != nil {
+		return ``, fmt.Errorf(`%v: %v`, name, err)
+	}
+	if u.app != nil {
+		r = strings.TrimSuffix(u.app.Url, `/`) + r
+	}
+	return r, nil
+}
+
 type Url struct {
-	Route  string
-	Format string
-	Params []string
-	Memo   string
-	exts   map[string]int
+	Route     string
+	Format    string
+	Params    []string
+	Memo      string
+	Name      string
+	Perm      string //访问需要的权限
+	RateLimit string //访问频率限制，例如："10/m"或"100/h user"
+	CORS      string //跨域资源共享策略的名称
+	exts      map[string]int
+	app       *App
 }
 
 func NewUrl() *Url {
@@ -143,10 +221,20 @@ func NewUrl() *Url {
 	}
 }
 
-func (m *Url) SetExts(exts []string) {
+// SetExts 设置路由允许的格式(扩展名)。路由所属App的Server.Formats中未注册的格式会被忽略并返回错误
+func (m *Url) SetExts(exts []string) error {
+	var unsupported []string
 	for key, val := range exts {
+		if m.app != nil && !m.app.Server.Formats.Has(val) {
+			unsupported = append(unsupported, val)
+			continue
+		}
 		m.exts[val] = key
 	}
+	if len(unsupported) > 0 {
+		return fmt.Errorf(`Unsupported formats of route %v: %v`, m.Route, strings.Join(unsupported, `, `))
+	}
+	return nil
 }
 
 func (m *Url) ValidExt(ext string) (ok bool) {
@@ -168,6 +256,9 @@ func (m *Url) Gen(vals interface{}) (r string) {
 		val := vals.(url.Values)
 		for _, name := range m.Params {
 			tag := `:` + name
+			if name == `*` {
+				tag = name
+			}
 			v := val.Get(name)
 			r = strings.Replace(r, tag+`/`, v+`/`, -1)
 			if strings.HasSuffix(r, tag) {
@@ -183,6 +274,9 @@ func (m *Url) Gen(vals interface{}) (r string) {
 		val := vals.(map[string]string)
 		for _, name := range m.Params {
 			tag := `:` + name
+			if name == `*` {
+				tag = name
+			}
 			v, _ := val[name]
 			r = strings.Replace(r, tag+`/`, v+`/`, -1)
 			if strings.HasSuffix(r, tag) {
@@ -197,11 +291,57 @@ func (m *Url) Gen(vals interface{}) (r string) {
 	return
 }
 
+// Fill 使用参数填充路由规则，有参数(通配符"*"除外)未填充时返回错误。
+// 未被路由规则使用的参数作为查询字符串附加在后面
+func (m *Url) Fill(vals url.Values) (r string, err error) {
+	r = m.Route
+	used := make(map[string]bool, len(m.Params))
+	for _, name := range m.Params {
+		v := vals.Get(name)
+		if name == `*` {
+			//通配符可以为空，其中的"/"保持不变
+			segs := strings.Split(strings.TrimPrefix(v, `/`), `/`)
+			for i, seg := range segs {
+				segs[i] = url.PathEscape(seg)
+			}
+			r = strings.Replace(r, `*`, strings.Join(segs, `/`), 1)
+			used[name] = true
+			continue
+		}
+		if v == `` {
+			return ``, fmt.Errorf(`%v: %v`, ErrRouteParam, name)
+		}
+		v = url.PathEscape(v)
+		tag := `:` + name
+		r = strings.Replace(r, tag+`/`, v+`/`, -1)
+		if strings.HasSuffix(r, tag) {
+			r = strings.TrimSuffix(r, tag) + v
+		}
+		used[name] = true
+	}
+	q := url.Values{}
+	for k, v := range vals {
+		if !used[k] {
+			q[k] = v
+		}
+	}
+	if s := q.Encode(); s != `` {
+		r += `?` + s
+	}
+	return
+}
+
 func (m *Url) Set(route string, memo ...string) {
 	m.Route = route
 	m.Params = make([]string, 0)
 	uri := new(bytes.Buffer)
 	for i, l := 0, len(route); i < l; i++ {
+		if route[i] == '*' {
+			//通配符，参数名为"*"
+			m.Params = append(m.Params, `*`)
+			uri.WriteString("%v")
+			continue
+		}
 		if route[i] == ':' {
 			start := i + 1
 			for ; i < l && route[i] != '/'; i++ {
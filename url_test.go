@@ -0,0 +1,92 @@
+/*
+
+   Copyright 2016 Wenhui Shen <www.webx.top>
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+
+*/
+package webx
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestUrlSet(t *testing.T) {
+	u := NewUrl()
+	u.Set(`/user/:id/post/:pid`)
+	if len(u.Params) != 2 || u.Params[0] != `id` || u.Params[1] != `pid` {
+		t.Errorf(`Params = %v`, u.Params)
+	}
+	if u.Format != `/user/%v/post/%v` {
+		t.Errorf(`Format = %q`, u.Format)
+	}
+	u.Set(`/file/*`)
+	if len(u.Params) != 1 || u.Params[0] != `*` || u.Format != `/file/%v` {
+		t.Errorf(`Params = %v, Format = %q`, u.Params, u.Format)
+	}
+}
+
+func TestUrlFill(t *testing.T) {
+	tests := []struct {
+		route string
+		vals  url.Values
+		want  string
+		err   bool
+	}{
+		{`/user/:id`, url.Values{`id`: {`5`}}, `/user/5`, false},
+		{`/user/:id/edit`, url.Values{`id`: {`5`}, `tab`: {`info`}}, `/user/5/edit?tab=info`, false},
+		{`/user/:id`, url.Values{`id`: {`a b/c`}}, `/user/a%20b%2Fc`, false},
+		{`/user/:id`, url.Values{}, ``, true},
+		{`/file/*`, url.Values{`*`: {`a/b c.txt`}}, `/file/a/b%20c.txt`, false},
+		{`/file/*`, url.Values{}, `/file/`, false},
+	}
+	for _, test := range tests {
+		u := NewUrl()
+		u.Set(test.route)
+		r, err := u.Fill(test.vals)
+		if test.err {
+			if err == nil {
+				t.Errorf(`Fill(%v, %v) should fail`, test.route, test.vals)
+			}
+			continue
+		}
+		if err != nil || r != test.want {
+			t.Errorf(`Fill(%v, %v) = %q, %v; want %q`, test.route, test.vals, r, err, test.want)
+		}
+	}
+}
+
+func TestURLFor(t *testing.T) {
+	a := &URL{names: map[string]*Url{}}
+	u := NewUrl()
+	u.Set(`/user/:id/post/:pid`)
+	a.names[`post`] = u
+	r, err := a.For(`post`, 1, 2)
+	if err != nil || r != `/user/1/post/2` {
+		t.Errorf(`For(post, 1, 2) = %q, %v`, r, err)
+	}
+	r, err = a.For(`post`, map[string]interface{}{`id`: 1, `pid`: 2, `page`: 3})
+	if err != nil || r != `/user/1/post/2?page=3` {
+		t.Errorf(`For(post, map) = %q, %v`, r, err)
+	}
+	if _, err = a.For(`post`, 1); err == nil {
+		t.Error(`For with too few parameters should fail`)
+	}
+	if _, err = a.For(`post`, map[string]string{`id`: `1`}); err == nil {
+		t.Error(`For with a missing parameter should fail`)
+	}
+	if _, err = a.For(`none`); err == nil {
+		t.Error(`For with an unknown name should fail`)
+	}
+}
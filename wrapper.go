@@ -18,14 +18,15 @@
 package webx
 
 import (
-	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
 	"regexp"
 	"runtime"
 	"strings"
 
 	"github.com/webx-top/echo"
+	"github.com/webx-top/webx/lib/com"
 )
 
 var (
@@ -72,6 +73,11 @@ type Wrapper struct {
 	HasBefore bool
 	HasAfter  bool
 
+	//是否复用动态实例，默认关闭。开启后实例在请求结束时被清零并放回对象池，
+	//因此行为方法中不能在请求结束后继续引用实例(例如在goroutine中)。
+	//需要在注册路由之前设置：wr := app.Reg(ctl); wr.UsePool = true; wr.Auto()
+	UsePool bool
+
 	//实例对象
 	Controller interface{}
 
@@ -79,13 +85,16 @@ type Wrapper struct {
 	*App
 }
 
-func (a *Wrapper) wrapHandler(h HandlerFunc, ctl string, act string) func(echo.Context) error {
+func (a *Wrapper) wrapHandler(h HandlerFunc, u *Url, ctl string, act string) func(echo.Context) error {
 	if a.BeforeHandler != nil && a.AfterHandler != nil {
 		return func(ctx echo.Context) error {
 			c := X(ctx)
 			if err := c.Init(a.App, a.Controller, ctl, act); err != nil {
 				return err
 			}
+			if err := c.checkRoute(u); err != nil || c.Exit {
+				return err
+			}
 			if err := a.BeforeHandler(c); err != nil {
 				return err
 			}
@@ -107,6 +116,9 @@ func (a *Wrapper) wrapHandler(h HandlerFunc, ctl string, act string) func(echo.C
 			if err := c.Init(a.App, a.Controller, ctl, act); err != nil {
 				return err
 			}
+			if err := c.checkRoute(u); err != nil || c.Exit {
+				return err
+			}
 			if err := a.BeforeHandler(c); err != nil {
 				return err
 			}
@@ -122,6 +134,9 @@ func (a *Wrapper) wrapHandler(h HandlerFunc, ctl string, act string) func(echo.C
 			if err := c.Init(a.App, a.Controller, ctl, act); err != nil {
 				return err
 			}
+			if err := c.checkRoute(u); err != nil || c.Exit {
+				return err
+			}
 			if err := h(c); err != nil {
 				return err
 			}
@@ -136,19 +151,74 @@ func (a *Wrapper) wrapHandler(h HandlerFunc, ctl string, act string) func(echo.C
 		if err := c.Init(a.App, a.Controller, ctl, act); err != nil {
 			return err
 		}
+		if err := c.checkRoute(u); err != nil || c.Exit {
+			return err
+		}
 		return h(c)
 	}
 }
 
+// WrapperRoute 是Wrapper.Route的返回值，用于为刚注册的路由命名，同时可以继续调用Wrapper的方法
+type WrapperRoute struct {
+	*Wrapper
+	url *Url
+}
+
+// Name 为路由命名，以便通过Server.URL.For生成网址
+func (r *WrapperRoute) Name(name string) *WrapperRoute {
+	r.App.Server.URL.SetName(name, r.url)
+	return r
+}
+
+// Perm 设置路由需要的权限，由Server.PermChecker检查
+func (r *WrapperRoute) Perm(perm string) *WrapperRoute {
+	r.url.Perm = perm
+	return r
+}
+
+// RateLimit 设置路由的访问频率限制，由Server.RateLimiter检查。规则无效时panic
+func (r *WrapperRoute) RateLimit(rule string) *WrapperRoute {
+	if err := r.Server.parseRateLimit(rule); err != nil {
+		panic(err)
+	}
+	r.url.RateLimit = rule
+	return r
+}
+
+// CORS 设置路由使用的跨域资源共享策略
+func (r *WrapperRoute) CORS(policy string) *WrapperRoute {
+	r.url.CORS = policy
+	return r
+}
+
 //路由注册方案1：注册函数(可匿名)或静态实例的成员函数
 //例如：Controller.R(`/index`,Index.Index,"GET","POST")
 func (a *Wrapper) R(path string, h HandlerFunc, methods ...string) *Wrapper {
+	a.Route(path, h, methods...)
+	return a
+}
+
+//Route 与R相同，但返回WrapperRoute以便继续设置该路由。
+//例如：Controller.Route(`/index`,Index.Index,"GET","POST").Name(`index`)
+func (a *Wrapper) Route(path string, h HandlerFunc, methods ...string) *WrapperRoute {
 	if len(methods) < 1 {
 		methods = append(methods, "GET")
 	}
-	_, ctl, act := a.App.Server.URL.Set(path, h)
-	a.Webx.Match(methods, path, echo.HandlerFunc(a.wrapHandler(h, ctl, act)))
-	return a
+	key := a.App.Server.URL.FuncPath(h)
+	u := a.App.Server.URL.SetByKey(path, key)
+	a.App.setUrl(u)
+	_, ctl, act := com.ParseFuncName(key)
+	a.Webx.Match(methods, path, echo.HandlerFunc(a.wrapHandler(h, u, ctl, act)))
+	return &WrapperRoute{Wrapper: a, url: u}
+}
+
+// 动态实例路由的默认名称：[App名称.]控制器名.行为名，均为小写
+func (a *Wrapper) routeName(ctl string, act string) string {
+	name := strings.ToLower(ctl + `.` + act)
+	if a.App.Name != `` {
+		name = a.App.Name + `.` + name
+	}
+	return name
 }
 
 //路由注册方案2：从动态实例内Mapper类型字段标签中获取路由信息
@@ -179,6 +249,15 @@ func (a *Wrapper) RouteTags() {
 		//支持的tag:
 		// 1. webx - 路由规则
 		// 2. memo - 注释说明
+		// 3. name - 路由名称(默认为：[App名称.]控制器名.行为名)
+		// 4. args - 行为方法中基本类型参数依次对应的参数名称，多个用逗号分隔(默认为路由规则中的参数)
+		// 5. perm - 访问需要的权限，如`perm:"article.edit"`，在Before之前由Server.PermChecker检查
+		// 6. ratelimit - 访问频率限制，如`ratelimit:"10/m"`或`ratelimit:"100/h user"`，在权限检查之前由Server.RateLimiter检查，规则无效时panic
+		// 7. cors - 跨域资源共享策略的名称，如`cors:"public"`，策略在cors中间件中定义
+		//行为方法可以带有参数和返回值，例如：
+		// func (a *User) Show_GET(id int64, form *UserForm) (*User, error)
+		//基本类型参数依次从路由参数、表单和查询字符串中获取，结构体参数通过MapForm填充并验证，
+		//非error类型的返回值会作为Output.Data并通过Display输出
 		//webx标签内容支持以下格式：
 		// 1、只指定http请求方式，如`webx:"POST|GET"`
 		// 2、只指定路由规则，如`webx:"index"`
@@ -227,55 +306,32 @@ func (a *Wrapper) RouteTags() {
 			extends = strings.Split(ext, "|")
 		}
 		k := ctlPath + name + "-fm"
+		if err := a.Server.parseRateLimit(tag.Get("ratelimit")); err != nil {
+			panic(fmt.Errorf(`%v.%v: %v`, e.Name(), f.Name, err))
+		}
 		u := a.App.Server.URL.SetByKey(path, k, tag.Get("memo"))
-		u.SetExts(extends)
-		h := echo.HandlerFunc(func(ctx echo.Context) error {
-			c := X(ctx)
-			if !u.ValidExt(c.Format) {
-				return c.HTML(404, `The contents can not be displayed in this format: `+c.Format)
-			}
-			v := reflect.New(e)
-			ac := v.Interface()
-			if err := c.Init(a.App, ac, e.Name(), name); err != nil {
-				return err
-			}
-			if err := ac.(Initer).Init(c); err != nil {
-				return err
-			}
-			if a.HasBefore {
-				if err := ac.(Before).Before(); err != nil {
-					return err
-				}
-				if c.Exit {
-					return nil
-				}
-			}
-			format := strings.ToUpper(c.Format)
-			m := v.MethodByName(fn + `_` + c.Method() + format)
-			if !m.IsValid() {
-				m = v.MethodByName(fn + `_` + c.Method())
-				if !m.IsValid() {
-					m = v.MethodByName(fn + `_` + format)
-					if !m.IsValid() {
-						m = v.MethodByName(fn)
-					}
-				}
-			}
-			if r, err := a.SafelyCall(m, []reflect.Value{}); err != nil {
-				return err
-			} else if len(r) > 0 {
-				if err, ok := r[0].Interface().(error); ok && err != nil {
-					return c.DisplayError(err.Error())
-				}
-			}
-			if a.HasAfter {
-				if c.Exit {
-					return nil
-				}
-				return ac.(After).After()
-			}
-			return nil
-		})
+		a.App.setUrl(u)
+		u.Perm = tag.Get("perm")
+		u.RateLimit = tag.Get("ratelimit")
+		u.CORS = tag.Get("cors")
+		if err := u.SetExts(extends); err != nil {
+			a.Server.Core.Logger().Warn(err)
+		}
+		routeName := tag.Get("name")
+		if routeName == "" {
+			routeName = a.routeName(ctl, f.Name)
+		}
+		a.App.Server.URL.SetName(routeName, u)
+		argNames := u.Params
+		if args := tag.Get("args"); args != "" {
+			argNames = strings.Split(args, ",")
+		}
+		d, err := newDispatcher(t, fn, argNames, a.UsePool)
+		if err != nil {
+			a.Server.Core.Logger().Error(err)
+			continue
+		}
+		h := a.dispatchHandler(d, u, name)
 		if len(methods) < 1 {
 			a.Webx.Any(path, h)
 			for strings.HasSuffix(path, `/index`) {
@@ -308,60 +364,24 @@ func (a *Wrapper) RouteMethods() {
 		m := t.Method(i)
 		name := m.Name
 		fn := name
-		h := func(u *Url) func(ctx echo.Context) error {
-			return func(ctx echo.Context) error {
-				c := X(ctx)
-				if !u.ValidExt(c.Format) {
-					return c.HTML(404, `The contents can not be displayed in this format: `+c.Format)
-				}
-				v := reflect.New(e)
-				ac := v.Interface()
-				if err := c.Init(a.App, ac, e.Name(), name); err != nil {
-					return err
-				}
-				if err := ac.(Initer).Init(c); err != nil {
-					return err
-				}
-				if a.HasBefore {
-					if err := ac.(Before).Before(); err != nil {
-						return err
-					}
-					if c.Exit {
-						return nil
-					}
-				}
-				format := strings.ToUpper(c.Format)
-				m := v.MethodByName(fn + `_` + c.Method() + format)
-				if !m.IsValid() {
-					m = v.MethodByName(fn + `_` + c.Method())
-					if !m.IsValid() {
-						m = v.MethodByName(fn + `_` + format)
-						if !m.IsValid() {
-							m = v.MethodByName(fn)
-						}
-					}
-				}
-				if r, err := a.SafelyCall(m, []reflect.Value{}); err != nil {
-					return err
-				} else if len(r) > 0 {
-					if err, ok := r[0].Interface().(error); ok && err != nil {
-						return c.DisplayError(err.Error())
-					}
-				}
-				if a.HasAfter {
-					if c.Exit {
-						return nil
-					}
-					return ac.(After).After()
-				}
+		h := func(u *Url) echo.HandlerFunc {
+			d, err := newDispatcher(t, fn, u.Params, a.UsePool)
+			if err != nil {
+				a.Server.Core.Logger().Error(err)
 				return nil
 			}
+			return a.dispatchHandler(d, u, name)
 		}
 		if strings.HasSuffix(name, `_ANY`) {
 			name = strings.TrimSuffix(name, `_ANY`)
 			path := "/" + ctl + "/" + strings.ToLower(name)
 			u := a.App.Server.URL.SetByKey(path, ctlPath+name+"-fm")
-			handler := echo.HandlerFunc(h(u))
+			a.App.setUrl(u)
+			a.App.Server.URL.SetName(a.routeName(ctl, name), u)
+			handler := h(u)
+			if handler == nil {
+				continue
+			}
 			a.Webx.Any(path, handler)
 			for strings.HasSuffix(path, `/index`) {
 				path = strings.TrimSuffix(path, `/index`)
@@ -377,7 +397,12 @@ func (a *Wrapper) RouteMethods() {
 		name = strings.TrimSuffix(name, matches[0])
 		path := "/" + ctl + "/" + strings.ToLower(name)
 		u := a.App.Server.URL.SetByKey(path, ctlPath+name+"-fm")
-		handler := echo.HandlerFunc(h(u))
+		a.App.setUrl(u)
+		a.App.Server.URL.SetName(a.routeName(ctl, name), u)
+		handler := h(u)
+		if handler == nil {
+			continue
+		}
 		a.Webx.Match(methods, path, handler)
 		for strings.HasSuffix(path, `/index`) {
 			path = strings.TrimSuffix(path, `/index`)
@@ -395,7 +420,8 @@ func (a *Wrapper) Auto(args ...interface{}) {
 	a.RouteTags()
 }
 
-// safelyCall invokes `function` in recover block
+// SafelyCall invokes `function` in recover block.
+// 发生panic时堆栈信息只记录到日志中，返回的错误不包含堆栈信息
 func (a *Wrapper) SafelyCall(fn reflect.Value, args []reflect.Value) (resp []reflect.Value, err error) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -412,7 +438,7 @@ func (a *Wrapper) SafelyCall(fn reflect.Value, args []reflect.Value) (resp []ref
 				content += fmt.Sprintf("%v %v", file, line)
 			}
 			a.Server.Core.Logger().Error(content)
-			err = errors.New(content)
+			err = NewError(http.StatusInternalServerError, ``).SetCause(fmt.Errorf("Handler crashed with error: %v", e))
 			return
 		}
 	}()